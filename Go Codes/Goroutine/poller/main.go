@@ -0,0 +1,273 @@
+// Package main implements a lightweight inventory poller.
+//
+// The Goroutine and Channels examples collect device information once and
+// exit. The poller turns the same collection pattern into a long-running
+// service: jobs run on cron-like schedules, the last N results per device
+// are kept in memory and a local HTTP JSON API exposes them.
+//
+// Architecture:
+//
+//	┌────────────┐  tick   ┌──────────────┐  per device  ┌──────────┐
+//	│ Scheduler  │────────▶│  Job (once   │─────────────▶│ Routers  │
+//	│ (cron/     │         │  at a time)  │◀─────────────│ (sim.)   │
+//	│  @every)   │         └──────┬───────┘   Results    └──────────┘
+//	└────────────┘                │
+//	                              ▼
+//	                       ┌──────────────┐     GET /api/...
+//	                       │ Store (last  │◀──────────────── HTTP client
+//	                       │ N / device)  │
+//	                       └──────────────┘
+//
+// HTTP API:
+//
+//	GET /api/jobs              job status, run and skip counters
+//	GET /api/devices           devices with stored results
+//	GET /api/devices/{name}    result history of one device, newest first
+//
+// Usage:
+//
+//	go run ./poller -config poller/poller.yml
+//
+// Then query it with:
+//
+//	curl localhost:8081/api/devices
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Router is an inventory entry, same shape as ../input.yml.
+type Router struct {
+	Hostname string `yaml:"hostname"`
+	Platform string `yaml:"platform"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// JobConfig binds a collector to a schedule expression.
+type JobConfig struct {
+	Name     string `yaml:"name"`
+	Schedule string `yaml:"schedule"`
+}
+
+// Config is the poller configuration file.
+type Config struct {
+	Listen  string      `yaml:"listen"`
+	History int         `yaml:"history"`
+	Jobs    []JobConfig `yaml:"jobs"`
+	Routers []Router    `yaml:"router"`
+}
+
+// collectors maps job names to the function that queries one router.
+var collectors = map[string]func(ctx context.Context, r Router) (map[string]string, error){
+	"version":    collectVersion,
+	"interfaces": collectInterfaces,
+}
+
+// platformDelay simulates per-platform response times like ../main.go.
+func platformDelay(platform string) time.Duration {
+	switch platform {
+	case "cisco_iosxe":
+		return 2 * time.Second
+	case "cisco_nxos":
+		return 3 * time.Second
+	case "cisco_iosxr":
+		return 1 * time.Second
+	default:
+		return 500 * time.Millisecond
+	}
+}
+
+// wait sleeps for d or until ctx is cancelled.
+func wait(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// collectVersion simulates a "show version" collection.
+func collectVersion(ctx context.Context, r Router) (map[string]string, error) {
+	if err := wait(ctx, platformDelay(r.Platform)); err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		"platform": r.Platform,
+		"version":  "simulated",
+	}, nil
+}
+
+// collectInterfaces simulates an interface counter collection.
+func collectInterfaces(ctx context.Context, r Router) (map[string]string, error) {
+	if err := wait(ctx, platformDelay(r.Platform)/2); err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		"interfaces_up":   "4",
+		"interfaces_down": "0",
+	}, nil
+}
+
+// fanOut runs collect against every router concurrently, like the
+// original WaitGroup collector, and gathers one Result per router.
+func fanOut(job string, routers []Router, collect func(context.Context, Router) (map[string]string, error)) CollectFunc {
+	return func(ctx context.Context) []Result {
+		results := make([]Result, len(routers))
+
+		var wg sync.WaitGroup
+		for i, r := range routers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				start := time.Now()
+				data, err := collect(ctx, r)
+
+				res := Result{
+					Device:   r.Hostname,
+					Job:      job,
+					Time:     start,
+					Duration: time.Since(start).Round(time.Millisecond).String(),
+					Data:     data,
+				}
+				if err != nil {
+					res.Error = err.Error()
+				}
+				results[i] = res
+			}()
+		}
+		wg.Wait()
+
+		return results
+	}
+}
+
+// writeJSON encodes v as the response body.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Write error: %v", err)
+	}
+}
+
+// newAPI wires the HTTP handlers for the scheduler and store.
+func newAPI(sched *Scheduler, store *Store) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /api/jobs", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, sched.Status())
+	})
+
+	mux.HandleFunc("GET /api/devices", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, store.Devices())
+	})
+
+	mux.HandleFunc("GET /api/devices/{name}", func(w http.ResponseWriter, r *http.Request) {
+		history, ok := store.History(r.PathValue("name"))
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown device"})
+			return
+		}
+		writeJSON(w, http.StatusOK, history)
+	})
+
+	return mux
+}
+
+// loadConfig reads and validates the YAML configuration.
+func loadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := Config{Listen: "127.0.0.1:8081", History: 10}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+	if len(cfg.Jobs) == 0 {
+		return nil, errors.New("no jobs configured")
+	}
+
+	return &cfg, nil
+}
+
+func main() {
+	configPath := flag.String("config", "poller.yml", "path to the poller configuration")
+	flag.Parse()
+
+	cfg, err := loadConfig(*configPath)
+	checkError(err)
+
+	store := NewStore(cfg.History)
+	sched := NewScheduler(store)
+
+	for _, jc := range cfg.Jobs {
+		collect, ok := collectors[jc.Name]
+		if !ok {
+			log.Fatalf("Fatal error: unknown job %q", jc.Name)
+		}
+
+		schedule, err := ParseSchedule(jc.Schedule)
+		checkError(err)
+
+		sched.Add(&Job{
+			Name:     jc.Name,
+			Spec:     jc.Schedule,
+			Schedule: schedule,
+			Collect:  fanOut(jc.Name, cfg.Routers, collect),
+		})
+		log.Printf("Job %s scheduled (%s)", jc.Name, jc.Schedule)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	srv := &http.Server{
+		Addr:              cfg.Listen,
+		Handler:           newAPI(sched, store),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		log.Printf("Poller API listening on %s", cfg.Listen)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Fatal error: %s", err.Error())
+		}
+	}()
+
+	sched.Run(ctx)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Shutdown error: %v", err)
+	}
+	log.Println("Poller stopped")
+}
+
+// checkError terminates the program on fatal initialization errors.
+func checkError(err error) {
+	if err != nil {
+		log.Fatalf("Fatal error: %s", err.Error())
+	}
+}
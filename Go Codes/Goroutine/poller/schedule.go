@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule reports the next activation time strictly after a given time.
+type Schedule interface {
+	Next(after time.Time) time.Time
+}
+
+// everySchedule fires at a fixed interval ("@every 30s").
+type everySchedule struct {
+	interval time.Duration
+}
+
+// Next returns after rounded up to the next multiple of the interval.
+func (s everySchedule) Next(after time.Time) time.Time {
+	return after.Truncate(s.interval).Add(s.interval)
+}
+
+// cronSchedule is a classic five-field cron expression:
+//
+//	┌───────────── minute       (0-59)
+//	│ ┌─────────── hour         (0-23)
+//	│ │ ┌───────── day of month (1-31)
+//	│ │ │ ┌─────── month        (1-12)
+//	│ │ │ │ ┌───── day of week  (0-7, Sunday = 0 or 7)
+//	│ │ │ │ │
+//	* * * * *
+//
+// Each field accepts "*", single values, ranges ("1-5"), lists ("1,15")
+// and steps ("*/5", "0-30/10").
+//
+// As in cron, a day field that starts with "*" ("*", "*/2") counts as
+// unrestricted for the day-of-month / day-of-week rule below.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+
+	// domStar and dowStar follow cron semantics: when both day fields are
+	// restricted a day matches if either of them matches, otherwise both
+	// must match.
+	domStar, dowStar bool
+}
+
+// maxCronSearch bounds Next for expressions that can never match (Feb 31).
+const maxCronSearch = 5 * 366 * 24 * time.Hour
+
+// Next walks forward minute by minute until every field matches.
+func (s *cronSchedule) Next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := after.Add(maxCronSearch)
+
+	for t.Before(limit) {
+		if !has(s.month, int(t.Month())) {
+			t = forward(t, time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location()))
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = forward(t, time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location()))
+			continue
+		}
+		if !has(s.hour, t.Hour()) {
+			// Not t.Truncate(time.Hour): that works on absolute time and
+			// lands on :30 in zones such as +05:30.
+			t = forward(t, time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location()))
+			continue
+		}
+		if !has(s.minute, t.Minute()) {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+
+	return time.Time{}
+}
+
+// forward returns next, the wall clock time Next steps to from t. When a
+// daylight saving change skips that time, time.Date normalizes it to
+// before t; the change itself is then the first instant of the step.
+func forward(t, next time.Time) time.Time {
+	if next.After(t) {
+		return next
+	}
+	if _, end := next.ZoneBounds(); end.After(t) {
+		return end
+	}
+	return t.Add(time.Minute)
+}
+
+// dayMatches applies the day-of-month / day-of-week rule.
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	dom := has(s.dom, t.Day())
+	dow := has(s.dow, int(t.Weekday()))
+
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// has reports whether bit v is set in the field mask.
+func has(mask uint64, v int) bool {
+	return mask&(1<<uint(v)) != 0
+}
+
+// ParseSchedule parses either "@every <duration>", one of the cron
+// shortcuts (@hourly, @daily, ...) or a five-field cron expression.
+func ParseSchedule(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+
+	if rest, ok := strings.CutPrefix(spec, "@every "); ok {
+		d, err := time.ParseDuration(strings.TrimSpace(rest))
+		if err != nil {
+			return nil, fmt.Errorf("schedule %q: %w", spec, err)
+		}
+		if d < time.Second {
+			return nil, fmt.Errorf("schedule %q: interval must be at least 1s", spec)
+		}
+		return everySchedule{interval: d}, nil
+	}
+
+	switch spec {
+	case "@hourly":
+		spec = "0 * * * *"
+	case "@daily", "@midnight":
+		spec = "0 0 * * *"
+	case "@weekly":
+		spec = "0 0 * * 0"
+	case "@monthly":
+		spec = "0 0 1 * *"
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("schedule %q: expected 5 fields, got %d", spec, len(fields))
+	}
+
+	var (
+		s   cronSchedule
+		err error
+	)
+	bounds := []struct {
+		mask     *uint64
+		min, max int
+	}{
+		{&s.minute, 0, 59},
+		{&s.hour, 0, 23},
+		{&s.dom, 1, 31},
+		{&s.month, 1, 12},
+		{&s.dow, 0, 7},
+	}
+	for i, b := range bounds {
+		if *b.mask, err = parseField(fields[i], b.min, b.max); err != nil {
+			return nil, fmt.Errorf("schedule %q: %w", spec, err)
+		}
+	}
+	s.domStar = strings.HasPrefix(fields[2], "*")
+	s.dowStar = strings.HasPrefix(fields[4], "*")
+
+	// 7 is Sunday as well
+	if has(s.dow, 7) {
+		s.dow = s.dow&^(1<<7) | 1<<0
+	}
+
+	return &s, nil
+}
+
+// parseField converts one cron field into a bit mask.
+func parseField(field string, min, max int) (uint64, error) {
+	var mask uint64
+
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepStr)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			step = n
+		}
+
+		lo, hi := min, max
+		if rng != "*" {
+			loStr, hiStr, isRange := strings.Cut(rng, "-")
+
+			var err error
+			if lo, err = strconv.Atoi(loStr); err != nil {
+				return 0, fmt.Errorf("invalid value in %q", part)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(hiStr); err != nil {
+					return 0, fmt.Errorf("invalid range in %q", part)
+				}
+			} else if hasStep {
+				hi = max
+			}
+		}
+
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q out of range %d-%d", part, min, max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			mask |= 1 << uint(v)
+		}
+	}
+
+	return mask, nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata" // Zone cases must not depend on the host's zoneinfo
+)
+
+func TestParseSchedule(t *testing.T) {
+	for _, spec := range []string{
+		"@every 30s", "@hourly", "@daily", "@midnight", "@weekly", "@monthly",
+		"* * * * *", "*/5 * * * *", "0 9-17 * * 1-5", "0,30 */2 1,15 * *", "0-30/10 0 * 1-12/3 0", "0 0 * * 7", "0 0 * * 5-7",
+	} {
+		if _, err := ParseSchedule(spec); err != nil {
+			t.Errorf("ParseSchedule(%q): %v", spec, err)
+		}
+	}
+
+	for _, spec := range []string{
+		"", "@every", "@every 10ms", "@every x", "@yearly",
+		"* * * *", "* * * * * *",
+		"60 * * * *", "* 24 * * *", "* * 0 * *", "* * 32 * *", "* * * 13 *", "* * * * 8",
+		"5-1 * * * *", "*/0 * * * *", "*/x * * * *", "a * * * *", "1-x * * * *",
+	} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("ParseSchedule(%q) accepted", spec)
+		}
+	}
+}
+
+func TestParseField(t *testing.T) {
+	for _, tc := range []struct {
+		field    string
+		min, max int
+		want     []int
+	}{
+		{"*", 0, 5, []int{0, 1, 2, 3, 4, 5}},
+		{"3", 0, 5, []int{3}},
+		{"1-3", 0, 5, []int{1, 2, 3}},
+		{"1,4", 0, 5, []int{1, 4}},
+		{"*/2", 0, 5, []int{0, 2, 4}},
+		{"2/2", 0, 7, []int{2, 4, 6}},
+		{"1-5/2,0", 0, 7, []int{0, 1, 3, 5}},
+	} {
+		mask, err := parseField(tc.field, tc.min, tc.max)
+		if err != nil {
+			t.Errorf("parseField(%q): %v", tc.field, err)
+			continue
+		}
+		var want uint64
+		for _, v := range tc.want {
+			want |= 1 << uint(v)
+		}
+		if mask != want {
+			t.Errorf("parseField(%q) = %b, want %b", tc.field, mask, want)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	utc := time.UTC
+	india := time.FixedZone("IST", 5*3600+30*60)
+	nepal := time.FixedZone("NPT", 5*3600+45*60)
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	santiago, err := time.LoadLocation("America/Santiago")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		spec        string
+		after, want time.Time
+	}{
+		{"* * * * *", time.Date(2025, 1, 1, 10, 0, 30, 0, utc), time.Date(2025, 1, 1, 10, 1, 0, 0, utc)},
+		{"* * * * *", time.Date(2025, 1, 1, 10, 1, 0, 0, utc), time.Date(2025, 1, 1, 10, 2, 0, 0, utc)},
+		{"*/15 * * * *", time.Date(2025, 1, 1, 10, 16, 0, 0, utc), time.Date(2025, 1, 1, 10, 30, 0, 0, utc)},
+		{"@hourly", time.Date(2025, 1, 1, 23, 5, 0, 0, utc), time.Date(2025, 1, 2, 0, 0, 0, 0, utc)},
+		{"@daily", time.Date(2025, 12, 31, 12, 0, 0, 0, utc), time.Date(2026, 1, 1, 0, 0, 0, 0, utc)},
+		{"@monthly", time.Date(2025, 1, 31, 0, 0, 0, 0, utc), time.Date(2025, 2, 1, 0, 0, 0, 0, utc)},
+		{"@weekly", time.Date(2025, 1, 1, 0, 0, 0, 0, utc), time.Date(2025, 1, 5, 0, 0, 0, 0, utc)},      // Wednesday -> Sunday
+		{"0 9 * * 1-5", time.Date(2025, 1, 3, 10, 0, 0, 0, utc), time.Date(2025, 1, 6, 9, 0, 0, 0, utc)}, // Friday -> Monday
+		{"0 0 29 2 *", time.Date(2025, 3, 1, 0, 0, 0, 0, utc), time.Date(2028, 2, 29, 0, 0, 0, 0, utc)},
+		{"0 0 31 2 *", time.Date(2025, 1, 1, 0, 0, 0, 0, utc), time.Time{}}, // never
+
+		// Day of month or day of week when both are restricted
+		{"0 0 13 * 5", time.Date(2025, 1, 1, 0, 0, 0, 0, utc), time.Date(2025, 1, 3, 0, 0, 0, 0, utc)},
+		{"0 0 13 * 5", time.Date(2025, 1, 11, 0, 0, 0, 0, utc), time.Date(2025, 1, 13, 0, 0, 0, 0, utc)},
+
+		// A step over "*" leaves the field unrestricted, so both must match:
+		// odd days that are Fridays, and Sundays on the 1st to the 7th
+		{"0 0 */2 * 5", time.Date(2025, 1, 1, 0, 0, 0, 0, utc), time.Date(2025, 1, 3, 0, 0, 0, 0, utc)},
+		{"0 0 */2 * 5", time.Date(2025, 1, 3, 0, 0, 0, 0, utc), time.Date(2025, 1, 17, 0, 0, 0, 0, utc)},
+		{"0 0 1-7 * */7", time.Date(2025, 1, 1, 0, 0, 0, 0, utc), time.Date(2025, 1, 5, 0, 0, 0, 0, utc)},
+
+		// 7 is Sunday
+		{"0 0 * * 7", time.Date(2025, 1, 1, 0, 0, 0, 0, utc), time.Date(2025, 1, 5, 0, 0, 0, 0, utc)},
+		{"0 0 * * 6-7", time.Date(2025, 1, 5, 12, 0, 0, 0, utc), time.Date(2025, 1, 11, 0, 0, 0, 0, utc)},
+
+		// Hours are wall clock hours in zones that are not whole hours off UTC
+		{"0 * * * *", time.Date(2025, 1, 1, 10, 20, 0, 0, india), time.Date(2025, 1, 1, 11, 0, 0, 0, india)},
+		{"0 9 * * *", time.Date(2025, 1, 1, 7, 40, 0, 0, india), time.Date(2025, 1, 1, 9, 0, 0, 0, india)},
+		{"30 * * * *", time.Date(2025, 1, 1, 10, 50, 0, 0, india), time.Date(2025, 1, 1, 11, 30, 0, 0, india)},
+		{"0 9 * * *", time.Date(2025, 1, 1, 7, 50, 0, 0, nepal), time.Date(2025, 1, 1, 9, 0, 0, 0, nepal)},
+		{"15 */6 * * *", time.Date(2025, 1, 1, 1, 0, 0, 0, nepal), time.Date(2025, 1, 1, 6, 15, 0, 0, nepal)},
+
+		// Daylight saving time: 02:00 does not exist on 2025-03-09
+		{"30 3 * * *", time.Date(2025, 3, 9, 0, 0, 0, 0, newYork), time.Date(2025, 3, 9, 3, 30, 0, 0, newYork)},
+		{"0 * * * *", time.Date(2025, 3, 9, 1, 30, 0, 0, newYork), time.Date(2025, 3, 9, 3, 0, 0, 0, newYork)},
+		{"0 2 * * *", time.Date(2025, 3, 8, 12, 0, 0, 0, newYork), time.Date(2025, 3, 10, 2, 0, 0, 0, newYork)},
+
+		// Midnight does not exist on 2024-09-08 in Santiago
+		{"30 0 * * *", time.Date(2024, 9, 7, 12, 0, 0, 0, santiago), time.Date(2024, 9, 9, 0, 30, 0, 0, santiago)},
+		{"0 1 8 9 *", time.Date(2024, 9, 7, 12, 0, 0, 0, santiago), time.Date(2024, 9, 8, 1, 0, 0, 0, santiago)},
+	} {
+		s, err := ParseSchedule(tc.spec)
+		if err != nil {
+			t.Fatalf("ParseSchedule(%q): %v", tc.spec, err)
+		}
+		if got := s.Next(tc.after); !got.Equal(tc.want) {
+			t.Errorf("%q.Next(%s) = %s, want %s", tc.spec, tc.after, got, tc.want)
+		}
+	}
+}
+
+func TestEveryNext(t *testing.T) {
+	s, err := ParseSchedule("@every 30s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := time.Date(2025, 1, 1, 10, 0, 10, 0, time.UTC)
+	for _, want := range []int{30, 60, 90} {
+		after = s.Next(after)
+		if want := time.Date(2025, 1, 1, 10, 0, want, 0, time.UTC); !after.Equal(want) {
+			t.Fatalf("Next = %s, want %s", after, want)
+		}
+	}
+}
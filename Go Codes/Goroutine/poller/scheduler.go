@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CollectFunc runs one collection pass and returns a result per device.
+type CollectFunc func(ctx context.Context) []Result
+
+// Job is a named collection task bound to a schedule.
+type Job struct {
+	Name     string
+	Spec     string
+	Schedule Schedule
+	Collect  CollectFunc
+
+	running atomic.Bool
+	runs    atomic.Uint64
+	skipped atomic.Uint64
+
+	mu      sync.Mutex
+	lastRun time.Time
+	nextRun time.Time
+}
+
+// JobStatus is the JSON view of a job served by the API.
+type JobStatus struct {
+	Name     string    `json:"name"`
+	Schedule string    `json:"schedule"`
+	Running  bool      `json:"running"`
+	Runs     uint64    `json:"runs"`
+	Skipped  uint64    `json:"skipped"`
+	LastRun  time.Time `json:"last_run,omitzero"`
+	NextRun  time.Time `json:"next_run,omitzero"`
+}
+
+// Scheduler triggers jobs on their schedules and stores the results.
+//
+// Every job has its own timer goroutine. When a tick arrives while the
+// previous run of the same job is still in progress the tick is skipped,
+// so a slow device can never cause runs of one job to pile up.
+type Scheduler struct {
+	store *Store
+	jobs  []*Job
+	wg    sync.WaitGroup
+}
+
+// NewScheduler creates a scheduler that writes results into store.
+func NewScheduler(store *Store) *Scheduler {
+	return &Scheduler{store: store}
+}
+
+// Add registers a job. It must be called before Run.
+func (s *Scheduler) Add(job *Job) {
+	s.jobs = append(s.jobs, job)
+}
+
+// Run starts every job and blocks until ctx is cancelled and all
+// in-flight runs have finished.
+func (s *Scheduler) Run(ctx context.Context) {
+	for _, job := range s.jobs {
+		s.wg.Add(1)
+		go s.loop(ctx, job)
+	}
+
+	<-ctx.Done()
+	s.wg.Wait()
+}
+
+// loop waits for each activation time of job and triggers it.
+func (s *Scheduler) loop(ctx context.Context, job *Job) {
+	defer s.wg.Done()
+
+	for {
+		next := job.Schedule.Next(time.Now())
+		if next.IsZero() {
+			log.Printf("[%s] schedule never fires, stopping", job.Name)
+			return
+		}
+		job.setNext(next)
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if !job.running.CompareAndSwap(false, true) {
+			job.skipped.Add(1)
+			log.Printf("[%s] previous run still in progress, skipping", job.Name)
+			continue
+		}
+
+		s.wg.Add(1)
+		go s.run(ctx, job)
+	}
+}
+
+// run executes one pass of job and records its results.
+func (s *Scheduler) run(ctx context.Context, job *Job) {
+	defer s.wg.Done()
+	defer job.running.Store(false)
+
+	start := time.Now()
+	job.mu.Lock()
+	job.lastRun = start
+	job.mu.Unlock()
+
+	results := job.Collect(ctx)
+	for _, r := range results {
+		s.store.Add(r)
+	}
+	job.runs.Add(1)
+
+	log.Printf("[%s] collected %d results in %s", job.Name, len(results), time.Since(start).Round(time.Millisecond))
+}
+
+// setNext records the next activation time for the status API.
+func (j *Job) setNext(t time.Time) {
+	j.mu.Lock()
+	j.nextRun = t
+	j.mu.Unlock()
+}
+
+// Status returns a snapshot of every job, sorted by name.
+func (s *Scheduler) Status() []JobStatus {
+	out := make([]JobStatus, 0, len(s.jobs))
+
+	for _, job := range s.jobs {
+		job.mu.Lock()
+		out = append(out, JobStatus{
+			Name:     job.Name,
+			Schedule: job.Spec,
+			Running:  job.running.Load(),
+			Runs:     job.runs.Load(),
+			Skipped:  job.skipped.Load(),
+			LastRun:  job.lastRun,
+			NextRun:  job.nextRun,
+		})
+		job.mu.Unlock()
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+
+	return out
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestNoOverlap runs a job that takes longer than its interval: ticks
+// during a run are skipped, so there is never more than one run in flight.
+func TestNoOverlap(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	job := &Job{
+		Name:     "slow",
+		Schedule: everySchedule{interval: 10 * time.Millisecond},
+		Collect: func(ctx context.Context) []Result {
+			n := inFlight.Add(1)
+			defer inFlight.Add(-1)
+			for {
+				old := maxInFlight.Load()
+				if n <= old || maxInFlight.CompareAndSwap(old, n) {
+					break
+				}
+			}
+			select {
+			case <-time.After(50 * time.Millisecond):
+			case <-ctx.Done():
+			}
+			return []Result{{Device: "r1", Job: "slow"}}
+		},
+	}
+
+	store := NewStore(100)
+	sched := NewScheduler(store)
+	sched.Add(job)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	sched.Run(ctx)
+
+	if maxInFlight.Load() != 1 {
+		t.Errorf("%d runs in flight at once, want 1", maxInFlight.Load())
+	}
+	if inFlight.Load() != 0 {
+		t.Error("Run returned before the last run finished")
+	}
+
+	status := sched.Status()[0]
+	if status.Runs < 2 || status.Skipped == 0 || status.Running {
+		t.Errorf("status %+v, want several runs, skipped ticks and nothing running", status)
+	}
+	if history, _ := store.History("r1"); uint64(len(history)) != status.Runs {
+		t.Errorf("%d results stored for %d runs", len(history), status.Runs)
+	}
+}
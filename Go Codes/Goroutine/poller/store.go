@@ -0,0 +1,80 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Result is the outcome of one job run against one device.
+type Result struct {
+	Device   string            `json:"device"`
+	Job      string            `json:"job"`
+	Time     time.Time         `json:"time"`
+	Duration string            `json:"duration"`
+	Data     map[string]string `json:"data,omitempty"`
+	Error    string            `json:"error,omitempty"`
+}
+
+// Store keeps the last N results per device in memory.
+type Store struct {
+	mu      sync.RWMutex
+	limit   int
+	devices map[string][]Result
+}
+
+// NewStore creates a store that retains at most limit results per device.
+func NewStore(limit int) *Store {
+	if limit <= 0 {
+		limit = 1
+	}
+	return &Store{
+		limit:   limit,
+		devices: make(map[string][]Result),
+	}
+}
+
+// Add appends r to its device history, evicting the oldest entry when
+// the history is full.
+func (s *Store) Add(r Result) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	history := append(s.devices[r.Device], r)
+	if len(history) > s.limit {
+		history = history[len(history)-s.limit:]
+	}
+	s.devices[r.Device] = history
+}
+
+// Devices returns the sorted names of every device with results.
+func (s *Store) Devices() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names := make([]string, 0, len(s.devices))
+	for name := range s.devices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// History returns a copy of a device's results, newest first.
+func (s *Store) History(device string) ([]Result, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	history, ok := s.devices[device]
+	if !ok {
+		return nil, false
+	}
+
+	out := make([]Result, len(history))
+	for i, r := range history {
+		out[len(history)-1-i] = r
+	}
+
+	return out, true
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestStoreEviction(t *testing.T) {
+	store := NewStore(3)
+	for _, job := range []string{"j1", "j2", "j3", "j4", "j5"} {
+		store.Add(Result{Device: "r1", Job: job})
+	}
+	store.Add(Result{Device: "r0", Job: "j1"})
+
+	if got := store.Devices(); !slices.Equal(got, []string{"r0", "r1"}) {
+		t.Errorf("Devices = %q", got)
+	}
+
+	// The last three, newest first
+	history, ok := store.History("r1")
+	var jobs []string
+	for _, r := range history {
+		jobs = append(jobs, r.Job)
+	}
+	if !ok || !slices.Equal(jobs, []string{"j5", "j4", "j3"}) {
+		t.Errorf("History(r1) = %q, %v, want j5 j4 j3", jobs, ok)
+	}
+
+	// History returns a copy
+	history[0].Job = "changed"
+	if again, _ := store.History("r1"); again[0].Job != "j5" {
+		t.Error("History shares its slice with the store")
+	}
+
+	if _, ok := store.History("missing"); ok {
+		t.Error("History of an unknown device reported ok")
+	}
+
+	// A limit below 1 keeps the latest result
+	one := NewStore(0)
+	one.Add(Result{Device: "r1", Job: "j1"})
+	one.Add(Result{Device: "r1", Job: "j2"})
+	if history, _ := one.History("r1"); len(history) != 1 || history[0].Job != "j2" {
+		t.Errorf("NewStore(0) kept %v", history)
+	}
+}
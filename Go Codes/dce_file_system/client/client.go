@@ -2,28 +2,91 @@ package main
 
 import (
 	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"io/fs"
 	"net"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"text/tabwriter"
+	"time"
 )
 
 func main() {
-	conn, err := net.Dial("tcp", "127.0.0.1:8080")
+	outDir := flag.String("out", ".", "directory GET saves files to")
+	server := flag.String("server", "127.0.0.1:8080", "server address; any node of a cluster will do")
+	timeout := flag.Duration("timeout", 30*time.Second, "give up when the server sends nothing for this long")
+	flag.Parse()
+
+	raw, err := net.DialTimeout("tcp", *server, *timeout)
 	if err != nil {
 		panic(err)
 	}
+	conn := idleConn{Conn: raw, timeout: *timeout}
 	defer conn.Close()
 
-	fmt.Println("Connected to server")
+	fmt.Println("Connected to server", *server)
 
 	reader := bufio.NewReader(os.Stdin)
 	serverReader := bufio.NewReader(conn)
 
 	for {
 		fmt.Print("Command: ")
-		cmd, _ := reader.ReadString('\n')
+		cmd, err := reader.ReadString('\n')
 		cmd = strings.TrimSpace(cmd)
+		if err != nil && cmd == "" {
+			// End of input counts as quit
+			cmd = "quit"
+		}
+
+		// "-json" is a client option, the server always sends the same listing.
+		// Options come before the pattern, which is sent as typed.
+		asJSON := false
+		if cmd == "DIR" || strings.HasPrefix(cmd, "DIR ") {
+			kept := []string{"DIR"}
+			rest := strings.TrimPrefix(cmd, "DIR")
+			for {
+				rest = strings.TrimLeft(rest, " ")
+				option, after, _ := strings.Cut(rest, " ")
+				if !strings.HasPrefix(option, "-") {
+					break
+				}
+				if option == "-json" {
+					asJSON = true
+				} else {
+					kept = append(kept, option)
+				}
+				rest = after
+			}
+			if rest != "" {
+				kept = append(kept, rest)
+			}
+			cmd = strings.Join(kept, " ")
+		}
+
+		// PUT sends its own header followed by the file contents. A PUT
+		// without arguments only prints the usage, sendFile checks them
+		// before anything is written to the server.
+		if cmd == "PUT" || strings.HasPrefix(cmd, "PUT ") {
+			err := sendFile(conn, strings.Fields(strings.TrimPrefix(cmd, "PUT")))
+			if err == nil {
+				err = printReply(serverReader)
+			}
+			if err != nil {
+				fmt.Println("PUT failed:", err)
+				if errors.Is(err, errConnection) {
+					return
+				}
+			}
+			continue
+		}
 
 		// Send command
 		conn.Write([]byte(cmd + "\n"))
@@ -33,22 +96,270 @@ func main() {
 			return
 		}
 
-		if strings.HasPrefix(cmd, "DIR") {
-			// DIR returns multiple lines ending with empty line
-			for {
-				resp, _ := serverReader.ReadString('\n')
-				resp = strings.TrimSpace(resp)
-				if resp == "" {
-					break
-				}
-				fmt.Println("Server:", resp)
+		switch {
+		case cmd == "DIR" || strings.HasPrefix(cmd, "DIR "):
+			err = receiveListing(serverReader, asJSON)
+		case strings.HasPrefix(cmd, "GET "):
+			name := strings.TrimPrefix(cmd, "GET ")
+			err = receiveFile(serverReader, name, filepath.Join(*outDir, filepath.Base(name)))
+		default:
+			// All other commands → read ONE line response
+			err = printReply(serverReader)
+		}
+		if err != nil {
+			fmt.Println("Error:", err)
+			if errors.Is(err, errConnection) {
+				return
 			}
-			continue
 		}
+	}
+}
+
+// idleConn fails a read or write after timeout without progress, so a
+// server that stops answering cannot make the client wait forever
+type idleConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c idleConn) Read(b []byte) (int, error) {
+	c.Conn.SetDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Read(b)
+}
+
+func (c idleConn) Write(b []byte) (int, error) {
+	c.Conn.SetDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Write(b)
+}
+
+// serverError is an "ERR <code> <message>" reply
+type serverError struct {
+	Code    int
+	Message string
+}
+
+func (e *serverError) Error() string {
+	return fmt.Sprintf("server error %d: %s", e.Code, e.Message)
+}
+
+// readLine reads one reply line. An "ERR" line is returned as a
+// *serverError, a broken connection as errConnection.
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errConnection, err)
+	}
+	line = strings.TrimSpace(line)
+
+	if rest, ok := strings.CutPrefix(line, "ERR "); ok {
+		code, msg, _ := strings.Cut(rest, " ")
+		n, _ := strconv.Atoi(code)
+		return "", &serverError{Code: n, Message: msg}
+	}
+	return line, nil
+}
 
-		// All other commands → read ONE line response
-		resp, _ := serverReader.ReadString('\n')
-		resp = strings.TrimSpace(resp)
-		fmt.Println("Server:", resp)
+// printReply shows a one line reply
+func printReply(r *bufio.Reader) error {
+	line, err := readLine(r)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Server:", line)
+	return nil
+}
+
+// receiveListing reads a DIR reply: entries up to an empty line, or a
+// single ERR line
+func receiveListing(r *bufio.Reader, asJSON bool) error {
+	var entries []entry
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return err
+		}
+		if line == "" {
+			break
+		}
+		entries = append(entries, parseEntry(line))
 	}
+	printEntries(entries, asJSON)
+	return nil
+}
+
+// entry is one line of a DIR listing
+type entry struct {
+	Name     string    `json:"name"`
+	Type     string    `json:"type"`
+	Size     int64     `json:"size"`
+	Mode     string    `json:"mode"`
+	Modified time.Time `json:"modified"`
+}
+
+// parseEntry decodes "type=file;size=12;modify=20250114093015;UNIX.mode=0644; name"
+func parseEntry(line string) entry {
+	facts, name, _ := strings.Cut(line, " ")
+
+	e := entry{Name: name}
+	var perm fs.FileMode
+	for _, fact := range strings.Split(strings.TrimSuffix(facts, ";"), ";") {
+		key, value, _ := strings.Cut(fact, "=")
+		switch key {
+		case "type":
+			e.Type = value
+		case "size":
+			e.Size, _ = strconv.ParseInt(value, 10, 64)
+		case "modify":
+			e.Modified, _ = time.Parse("20060102150405", value)
+		case "UNIX.mode":
+			m, _ := strconv.ParseUint(value, 8, 32)
+			perm = fs.FileMode(m).Perm()
+		}
+	}
+	if e.Type == "dir" {
+		perm |= fs.ModeDir
+	}
+	e.Mode = perm.String()
+
+	return e
+}
+
+// printEntries renders a listing as an aligned table or as JSON
+func printEntries(entries []entry, asJSON bool) {
+	if asJSON {
+		out, _ := json.MarshalIndent(entries, "", "  ")
+		fmt.Println(string(out))
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MODE\t        SIZE\tMODIFIED\tNAME")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s\t%12d\t%s\t%s\n",
+			e.Mode, e.Size, e.Modified.Local().Format("2006-01-02 15:04"), e.Name)
+	}
+	w.Flush()
+}
+
+// errConnection marks errors after which the connection is out of sync
+var errConnection = errors.New("connection lost")
+
+// receiveFile reads a GET response: a header line "OK <size> <sha256>"
+// followed by exactly size raw bytes, or a single ERR line. The bytes go
+// to local+".part", which is renamed to local once the checksum matches.
+func receiveFile(r *bufio.Reader, name, local string) error {
+	header, err := readLine(r)
+	if err != nil {
+		return err
+	}
+
+	var size int64
+	var sum string
+	if _, err := fmt.Sscanf(header, "OK %d %s", &size, &sum); err != nil {
+		return fmt.Errorf("%w: bad header %q", errConnection, header)
+	}
+
+	part := local + ".part"
+	f, err := os.Create(part)
+	if err != nil {
+		// The data still has to be read to stay in sync with the server
+		if _, err := io.CopyN(io.Discard, r, size); err != nil {
+			return fmt.Errorf("%w: %v", errConnection, err)
+		}
+		return err
+	}
+
+	h := sha256.New()
+	p := &progress{label: name, total: size, start: time.Now()}
+	_, err = io.CopyN(io.MultiWriter(f, h, p), r, size)
+	p.done()
+	f.Close()
+	if err != nil {
+		os.Remove(part)
+		return fmt.Errorf("%w: %v", errConnection, err)
+	}
+
+	if got := hex.EncodeToString(h.Sum(nil)); got != sum {
+		os.Remove(part)
+		return fmt.Errorf("checksum mismatch: got %s, want %s", got, sum)
+	}
+	if err := os.Rename(part, local); err != nil {
+		return err
+	}
+
+	fmt.Printf("Saved %s (%d bytes, sha256 %s)\n", local, size, sum)
+	return nil
+}
+
+// sendFile uploads "PUT <local> [remote]" as a header line
+// "PUT <remote> <size> <sha256>" followed by exactly size raw bytes. The
+// remote name defaults to the local file name.
+func sendFile(conn net.Conn, args []string) error {
+	if len(args) == 0 || len(args) > 2 {
+		return errors.New("usage: PUT <local file> [remote name]")
+	}
+	local, remote := args[0], filepath.Base(args[0])
+	if len(args) == 2 {
+		remote = args[1]
+	}
+
+	f, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(conn, "PUT %s %d %s\n", remote, info.Size(), hex.EncodeToString(h.Sum(nil)))
+	p := &progress{label: remote, total: info.Size(), start: time.Now()}
+	_, err = io.CopyN(io.MultiWriter(conn, p), f, info.Size())
+	p.done()
+	if err != nil {
+		return fmt.Errorf("%w: %v", errConnection, err)
+	}
+	return nil
+}
+
+// progress prints a one line progress display while a file is transferred
+type progress struct {
+	label   string
+	total   int64
+	current int64
+	start   time.Time
+	last    time.Time
+}
+
+func (p *progress) Write(b []byte) (int, error) {
+	p.current += int64(len(b))
+	if time.Since(p.last) >= 100*time.Millisecond {
+		p.draw()
+		p.last = time.Now()
+	}
+	return len(b), nil
+}
+
+func (p *progress) draw() {
+	percent := 100.0
+	if p.total > 0 {
+		percent = float64(p.current) * 100 / float64(p.total)
+	}
+	bar := strings.Repeat("#", int(percent/5)) + strings.Repeat(".", 20-int(percent/5))
+	rate := float64(p.current) / time.Since(p.start).Seconds() / 1024
+	fmt.Printf("\r%s [%s] %5.1f%% %d/%d bytes %.1f KB/s ", p.label, bar, percent, p.current, p.total, rate)
+}
+
+func (p *progress) done() {
+	p.draw()
+	fmt.Println()
 }
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Users file
+//
+// One account per line, "name:hash:commands"; blank lines and lines
+// starting with "#" are ignored:
+//
+//	admin:pbkdf2-sha256$600000$<salt>$<key>:*
+//	guest:pbkdf2-sha256$600000$<salt>$<key>:DIR,CD
+//
+// The hash is PBKDF2-SHA256 with a random 16 byte salt, both base64
+// encoded. Create one with "go run . -hash-password". The last field
+// lists the commands the user may run, "*" allows all of them.
+
+// Authenticator checks credentials. FileStore reads them from a users
+// file; Lockout wraps any Authenticator with a failed attempt limit.
+type Authenticator interface {
+	Authenticate(name, password string) (*User, error)
+}
+
+// User is an authenticated account.
+type User struct {
+	Name     string
+	hash     string
+	commands map[string]bool // nil allows every command
+}
+
+// Can reports whether the user may run command.
+func (u *User) Can(command string) bool {
+	return u.commands == nil || u.commands[command]
+}
+
+var (
+	errBadCredentials = errorf(codeUnauthorized, "invalid user name or password")
+	errLocked         = errorf(codeLocked, "account locked")
+)
+
+const (
+	hashScheme     = "pbkdf2-sha256"
+	hashIterations = 600000
+	saltLen        = 16
+	keyLen         = 32
+)
+
+// hashPassword returns "pbkdf2-sha256$<iterations>$<salt>$<key>".
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key, err := pbkdf2.Key(sha256.New, password, salt, hashIterations, keyLen)
+	if err != nil {
+		return "", err
+	}
+
+	enc := base64.RawStdEncoding
+	return fmt.Sprintf("%s$%d$%s$%s", hashScheme, hashIterations, enc.EncodeToString(salt), enc.EncodeToString(key)), nil
+}
+
+// checkPassword compares a password against a stored hash in constant time.
+func checkPassword(stored, password string) bool {
+	parts := strings.Split(stored, "$")
+	if len(parts) != 4 || parts[0] != hashScheme {
+		return false
+	}
+
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations < 1 {
+		return false
+	}
+	enc := base64.RawStdEncoding
+	salt, err1 := enc.DecodeString(parts[2])
+	want, err2 := enc.DecodeString(parts[3])
+	if err1 != nil || err2 != nil {
+		return false
+	}
+
+	got, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(want))
+	return err == nil && subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// FileStore is an Authenticator backed by a users file.
+type FileStore struct {
+	users map[string]*User
+	dummy string // compared against for unknown users, see Authenticate
+}
+
+// LoadUsers reads a users file.
+func LoadUsers(path string) (*FileStore, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	store := &FileStore{users: make(map[string]*User)}
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, ":")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%s:%d: expected name:hash:commands", path, lineNo)
+		}
+
+		u := &User{Name: fields[0], hash: fields[1]}
+		if !strings.HasPrefix(u.hash, hashScheme+"$") {
+			return nil, fmt.Errorf("%s:%d: unsupported hash, expected %s", path, lineNo, hashScheme)
+		}
+		if fields[2] != "*" {
+			u.commands = make(map[string]bool)
+			for _, c := range strings.Split(fields[2], ",") {
+				u.commands[strings.TrimSpace(c)] = true
+			}
+		}
+		if _, dup := store.users[u.Name]; dup {
+			return nil, fmt.Errorf("%s:%d: duplicate user %q", path, lineNo, u.Name)
+		}
+		store.users[u.Name] = u
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// Unknown names are checked against a dummy hash so that they take
+	// as long to reject as a wrong password
+	store.dummy, err = hashPassword("dummy password")
+	return store, err
+}
+
+// Authenticate implements Authenticator.
+func (s *FileStore) Authenticate(name, password string) (*User, error) {
+	u, ok := s.users[name]
+	if !ok {
+		checkPassword(s.dummy, password)
+		return nil, errBadCredentials
+	}
+	if !checkPassword(u.hash, password) {
+		return nil, errBadCredentials
+	}
+	return u, nil
+}
+
+// Lockout locks a user name for a while after too many failed attempts.
+//
+// Names are tracked whether or not the account exists, so a locked
+// unknown name looks the same as a locked real one. State for a name is
+// dropped once it has been quiet for the lock duration, so trying many
+// random names does not grow memory without bound.
+type Lockout struct {
+	next        Authenticator
+	maxFailures int
+	duration    time.Duration
+
+	mu        sync.Mutex
+	attempts  map[string]*attempts
+	lastSweep time.Time
+}
+
+// attempts is the login state of one name.
+type attempts struct {
+	failures int       // Consecutive failures
+	pending  int       // Attempts being checked right now
+	last     time.Time // Last attempt
+	until    time.Time // Locked until then
+}
+
+// NewLockout wraps next; maxFailures consecutive failures lock the name
+// for duration. maxFailures must be at least 1, with 0 every name would
+// be locked from the start.
+func NewLockout(next Authenticator, maxFailures int, duration time.Duration) (*Lockout, error) {
+	if maxFailures < 1 {
+		return nil, errors.New("-max-failures must be at least 1")
+	}
+	return &Lockout{
+		next:        next,
+		maxFailures: maxFailures,
+		duration:    duration,
+		attempts:    make(map[string]*attempts),
+	}, nil
+}
+
+// Authenticate implements Authenticator. While a name is locked even the
+// right password is refused.
+//
+// The password check itself is slow and runs without the lock held.
+// Attempts in progress count against the limit, so concurrent attempts
+// cannot get more than maxFailures passwords checked.
+func (l *Lockout) Authenticate(name, password string) (*User, error) {
+	now := time.Now()
+
+	l.mu.Lock()
+	l.sweep(now)
+	a := l.attempts[name]
+	if a == nil {
+		a = &attempts{}
+		l.attempts[name] = a
+	}
+	a.last = now
+	if left := a.until.Sub(now); left > 0 {
+		l.mu.Unlock()
+		return nil, fmt.Errorf("%w, try again in %s", errLocked, left.Round(time.Second))
+	}
+	if a.failures+a.pending >= l.maxFailures {
+		l.mu.Unlock()
+		return nil, fmt.Errorf("%w, too many attempts in progress", errLocked)
+	}
+	a.pending++
+	l.mu.Unlock()
+
+	u, err := l.next.Authenticate(name, password)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	a.pending--
+	if err == nil {
+		a.failures = 0
+		return u, nil
+	}
+
+	a.failures++
+	if a.failures >= l.maxFailures {
+		a.failures = 0
+		a.until = time.Now().Add(l.duration)
+		fmt.Printf("User %q locked for %s after %d failed logins\n", name, l.duration, l.maxFailures)
+		return nil, fmt.Errorf("%w for %s", errLocked, l.duration)
+	}
+	return nil, err
+}
+
+// sweep drops names that have been quiet for the lock duration. It runs
+// at most once per duration, so its cost is spread over many logins.
+func (l *Lockout) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.duration {
+		return
+	}
+	l.lastSweep = now
+	for name, a := range l.attempts {
+		if a.pending == 0 && now.After(a.until) && now.Sub(a.last) >= l.duration {
+			delete(l.attempts, name)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowAuth counts the passwords it checks; every check takes a while so
+// concurrent attempts overlap.
+type slowAuth struct {
+	testAuth
+	checked atomic.Int32
+}
+
+func (a *slowAuth) Authenticate(name, password string) (*User, error) {
+	a.checked.Add(1)
+	time.Sleep(20 * time.Millisecond)
+	return a.testAuth.Authenticate(name, password)
+}
+
+func newLockout(t *testing.T, next Authenticator, maxFailures int, duration time.Duration) *Lockout {
+	t.Helper()
+	l, err := NewLockout(next, maxFailures, duration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestLockoutMaxFailures(t *testing.T) {
+	for _, n := range []int{0, -1} {
+		if _, err := NewLockout(testUsers, n, time.Minute); err == nil {
+			t.Errorf("max failures %d accepted", n)
+		}
+	}
+}
+
+// TestLockoutConcurrent makes many wrong attempts at once. No more than
+// maxFailures of them may reach the password check, and the name must be
+// locked afterwards even for the right password.
+func TestLockoutConcurrent(t *testing.T) {
+	next := &slowAuth{testAuth: testUsers}
+	l := newLockout(t, next, 3, time.Minute)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Go(func() {
+			if _, err := l.Authenticate("admin", "wrong"); err == nil {
+				t.Error("wrong password accepted")
+			}
+		})
+	}
+	wg.Wait()
+
+	if n := next.checked.Load(); n > 3 {
+		t.Errorf("%d passwords checked, want at most 3", n)
+	}
+	if _, err := l.Authenticate("admin", "admin-pw"); errorCode(err) != codeLocked {
+		t.Errorf("right password while locked: %v, want code %d", err, codeLocked)
+	}
+}
+
+func TestLockoutSuccessResets(t *testing.T) {
+	l := newLockout(t, testUsers, 3, time.Minute)
+	for i := 0; i < 10; i++ {
+		l.Authenticate("admin", "wrong")
+		l.Authenticate("admin", "wrong")
+		if _, err := l.Authenticate("admin", "admin-pw"); err != nil {
+			t.Fatalf("round %d: %v", i, err)
+		}
+	}
+}
+
+// TestLockoutForgets tries many unknown names; their state must be
+// dropped once the lock duration has passed.
+func TestLockoutForgets(t *testing.T) {
+	l := newLockout(t, testUsers, 2, 300*time.Millisecond)
+	for i := 0; i < 200; i++ {
+		l.Authenticate(fmt.Sprintf("nobody%d", i), "x")
+	}
+	if _, err := l.Authenticate("nobody0", "x"); errorCode(err) != codeLocked {
+		t.Errorf("unknown name not locked after two failures: %v", err)
+	}
+
+	time.Sleep(700 * time.Millisecond)
+	l.Authenticate("admin", "admin-pw")
+	l.mu.Lock()
+	n := len(l.attempts)
+	l.mu.Unlock()
+	if n > 1 {
+		t.Errorf("%d names still tracked after the lock duration", n)
+	}
+	if _, err := l.Authenticate("nobody0", "x"); errorCode(err) != codeUnauthorized {
+		t.Errorf("after the lock duration: %v, want code %d", err, codeUnauthorized)
+	}
+}
@@ -0,0 +1,684 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cluster mode
+//
+// Several servers form one file service with a shared namespace. Every
+// node is started with the same -nodes list; a file path is mapped to its
+// owners with consistent hashing:
+//
+//	           hash("logs/a.txt")
+//	                  |
+//	   ...--[B#7]-----x-----[A#2]---[C#5]---[B#1]--...   ring of virtual nodes
+//	                        owner 1  owner 2
+//
+// The first -replicas distinct nodes clockwise from the path's hash own
+// it. Adding or removing a node only moves the paths next to its points.
+//
+// A client may connect to any node:
+//
+//	PUT   the node receives the file once and stores it on every owner
+//	GET   the node serves it from the first owner that has it
+//	DIR   the node merges the listings of all nodes
+//	WHERE <path>  shows the owners of a path, NODES the cluster members
+//
+// Nodes talk to each other over the same port. A peer logs in with
+// "PEER <secret>" (-secret, identical on all nodes) and may then use the
+// node-local commands LGET, LPUT and LDIR, which never forward.
+//
+// Three nodes on loopback:
+//
+//	N=127.0.0.1:8081,127.0.0.1:8082,127.0.0.1:8083
+//	go run . -listen 127.0.0.1:8081 -data /tmp/n1 -nodes $N -replicas 2 -secret s3cret &
+//	go run . -listen 127.0.0.1:8082 -data /tmp/n2 -nodes $N -replicas 2 -secret s3cret &
+//	go run . -listen 127.0.0.1:8083 -data /tmp/n3 -nodes $N -replicas 2 -secret s3cret &
+
+// virtualNodes is the number of ring points per node. More points spread
+// the paths more evenly.
+const virtualNodes = 64
+
+// peerTimeout bounds how long a peer may stall a single read or write.
+const peerTimeout = 30 * time.Second
+
+// Ring maps keys to nodes with consistent hashing.
+type Ring struct {
+	points []uint64          // Sorted hashes of all virtual nodes
+	owner  map[uint64]string // Virtual node hash -> node address
+	nodes  int
+}
+
+// NewRing places vnodes points per node on the ring.
+func NewRing(nodes []string, vnodes int) *Ring {
+	r := &Ring{owner: make(map[uint64]string), nodes: len(nodes)}
+	for _, node := range nodes {
+		for i := 0; i < vnodes; i++ {
+			h := hashKey(node + "#" + strconv.Itoa(i))
+			r.points = append(r.points, h)
+			r.owner[h] = node
+		}
+	}
+	slices.Sort(r.points)
+	return r
+}
+
+// Owners returns up to n distinct nodes responsible for key, primary first.
+func (r *Ring) Owners(key string, n int) []string {
+	n = min(n, r.nodes)
+	h := hashKey(key)
+
+	// First point at or after the key's hash, wrapping around
+	i, _ := slices.BinarySearch(r.points, h)
+
+	var owners []string
+	for j := 0; len(owners) < n && j < len(r.points); j++ {
+		node := r.owner[r.points[(i+j)%len(r.points)]]
+		if !slices.Contains(owners, node) {
+			owners = append(owners, node)
+		}
+	}
+	return owners
+}
+
+// hashKey is the ring position of a key.
+func hashKey(s string) uint64 {
+	sum := sha256.Sum256([]byte(s))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// Cluster is this node's view of the cluster.
+type Cluster struct {
+	self     string   // This node's address, as listed in nodes
+	nodes    []string // All members
+	ring     *Ring
+	replicas int
+	secret   string   // Shared secret for PEER logins
+	fsys     *os.Root // Export root of this node, see export.go
+	dir      string   // Absolute path of the export root, for logging
+}
+
+// NewCluster builds the cluster view. With no nodes the cluster is just
+// this node.
+func NewCluster(self string, nodes []string, replicas int, secret, root string) (*Cluster, error) {
+	if len(nodes) == 0 {
+		nodes = []string{self}
+	}
+	if !slices.Contains(nodes, self) {
+		return nil, fmt.Errorf("node address %s is not in -nodes %v", self, nodes)
+	}
+	if len(nodes) > 1 && secret == "" {
+		return nil, errors.New("a cluster of several nodes needs -secret")
+	}
+	if replicas < 1 {
+		return nil, errors.New("-replicas must be at least 1")
+	}
+
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	fsys, err := os.OpenRoot(abs)
+	if err != nil {
+		return nil, err
+	}
+	return &Cluster{
+		self:     self,
+		nodes:    nodes,
+		ring:     NewRing(nodes, virtualNodes),
+		replicas: min(replicas, len(nodes)),
+		secret:   secret,
+		fsys:     fsys,
+		dir:      abs,
+	}, nil
+}
+
+// owners returns the nodes holding key, with this node first if it is one.
+func (c *Cluster) owners(key string) []string {
+	owners := c.ring.Owners(key, c.replicas)
+	if i := slices.Index(owners, c.self); i > 0 {
+		owners[0], owners[i] = owners[i], owners[0]
+	}
+	return owners
+}
+
+// idleConn refreshes the deadline before every read and write, so a large
+// transfer may take as long as it needs but must not stall.
+type idleConn struct {
+	net.Conn
+}
+
+func (c idleConn) Read(b []byte) (int, error) {
+	c.Conn.SetDeadline(time.Now().Add(peerTimeout))
+	return c.Conn.Read(b)
+}
+
+func (c idleConn) Write(b []byte) (int, error) {
+	c.Conn.SetDeadline(time.Now().Add(peerTimeout))
+	return c.Conn.Write(b)
+}
+
+// peer is a logged in connection to another node.
+type peer struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// dialPeer connects to node and logs in with the cluster secret.
+func (c *Cluster) dialPeer(node string) (*peer, error) {
+	conn, err := net.DialTimeout("tcp", node, peerTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &peer{conn: idleConn{conn}, r: bufio.NewReader(idleConn{conn})}
+	resp, err := p.command("PEER " + c.secret)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if resp != "OK" {
+		conn.Close()
+		return nil, fmt.Errorf("%s refused peer login: %s", node, resp)
+	}
+	return p, nil
+}
+
+// command sends one line and reads the one line reply.
+func (p *peer) command(line string) (string, error) {
+	if _, err := p.conn.Write([]byte(line + "\n")); err != nil {
+		return "", err
+	}
+	resp, err := p.r.ReadString('\n')
+	return strings.TrimSpace(resp), err
+}
+
+func (p *peer) Close() error {
+	p.conn.Write([]byte("quit\n"))
+	return p.conn.Close()
+}
+
+// openLocal opens a stored file and computes the GET header values.
+func (c *Cluster) openLocal(key string) (*os.File, int64, string, error) {
+	f, err := c.fsys.Open(key)
+	if err != nil {
+		return nil, 0, "", exportError(err, key)
+	}
+
+	info, err := f.Stat()
+	if err == nil && !info.Mode().IsRegular() {
+		err = errorf(codeBadRequest, "%s is not a regular file", key)
+	}
+	h := sha256.New()
+	if err == nil {
+		_, err = io.Copy(h, f)
+	}
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, 0, "", err
+	}
+
+	return f, info.Size(), hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// storeLocal writes size bytes from r to key on this node. The data goes
+// to a ".part" file first and is renamed into place once the checksum
+// matches, so readers never see a half written file. The data is read
+// even when it cannot be stored, so r stays in sync with the protocol.
+func (c *Cluster) storeLocal(key string, r io.Reader, size int64, sum string) error {
+	if err := c.fsys.MkdirAll(path.Dir(key), 0o755); err != nil {
+		io.CopyN(io.Discard, r, size)
+		return exportError(err, path.Dir(key))
+	}
+
+	part := key + ".part"
+	f, err := c.fsys.Create(part)
+	if err != nil {
+		io.CopyN(io.Discard, r, size)
+		return exportError(err, key)
+	}
+
+	h := sha256.New()
+	_, err = io.CopyN(io.MultiWriter(f, h), r, size)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && hex.EncodeToString(h.Sum(nil)) != sum {
+		err = errChecksum
+	}
+	if err != nil {
+		c.fsys.Remove(part)
+		return err
+	}
+	return exportError(c.fsys.Rename(part, key), key)
+}
+
+// errChecksum is reported when received data does not match its sha256.
+var errChecksum = errorf(codeBadRequest, "checksum mismatch")
+
+// get answers a client GET: from this node if it owns the file, otherwise
+// relayed from the first owner that has it.
+func (c *Cluster) get(sess *session, name string) {
+	key, err := resolve(sess.cwd, name)
+	if err != nil {
+		sess.fail(err)
+		return
+	}
+
+	// An answer from an owner that was reached ("no such file") is more
+	// useful than a connection error from one that is down
+	var answer, down error
+	for _, node := range c.owners(key) {
+		if node == c.self {
+			f, size, sum, err := c.openLocal(key)
+			if err != nil {
+				answer = err
+				continue
+			}
+			sendFile(sess, f, size, sum)
+			f.Close()
+			return
+		}
+
+		err := c.relayGet(sess, node, key)
+		if err == nil {
+			return
+		}
+		var unreachable *net.OpError
+		if errors.As(err, &unreachable) {
+			down = errorf(codeUnavailable, "%s: %v", node, err)
+		} else {
+			answer = err
+		}
+	}
+
+	sess.fail(cmp.Or(answer, down, errorf(codeUnavailable, "no owner of %s reachable", key)))
+}
+
+// relayGet fetches key from node with LGET and passes header and data on
+// to the client. Errors before the header was relayed can be retried on
+// another owner.
+func (c *Cluster) relayGet(sess *session, node, key string) error {
+	p, err := c.dialPeer(node)
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+
+	header, err := p.command("LGET " + key)
+	if err != nil {
+		return err
+	}
+	if err, ok := parseError(header); ok {
+		return err
+	}
+
+	var size int64
+	var sum string
+	if _, err := fmt.Sscanf(header, "OK %d %s", &size, &sum); err != nil {
+		return errorf(codeInternal, "%s sent a bad header %q", node, header)
+	}
+
+	sess.send(header)
+	if _, err := io.CopyN(sess.conn, p.r, size); err != nil {
+		// The client has a header but not all bytes; it cannot resync
+		fmt.Printf("GET %s relay from %s aborted: %v\n", key, node, err)
+		sess.conn.Close()
+	}
+	return nil
+}
+
+// put answers "PUT <name> <size> <sha256>" followed by size raw bytes. The
+// upload is spooled to a temporary file and then stored on every owner.
+// It succeeds if at least one owner stored the file.
+func (c *Cluster) put(sess *session, args string) {
+	fields := strings.Fields(args)
+	if len(fields) < 3 {
+		rejectPut(sess, args, errorf(codeBadRequest, "usage: PUT <name> <size> <sha256>"))
+		return
+	}
+	sum := fields[len(fields)-1]
+	size, err := strconv.ParseInt(fields[len(fields)-2], 10, 64)
+	if err != nil || size < 0 {
+		rejectPut(sess, args, errorf(codeBadRequest, "invalid size %q", fields[len(fields)-2]))
+		return
+	}
+	name := strings.Join(fields[:len(fields)-2], " ")
+
+	// The data is already on its way; spool it before deciding anything
+	spool, err := os.CreateTemp("", "dce-put-*")
+	if err != nil {
+		io.CopyN(io.Discard, sess.reader, size)
+		sess.fail(err)
+		return
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	h := sha256.New()
+	if _, err := io.CopyN(io.MultiWriter(spool, h), sess.reader, size); err != nil {
+		sess.conn.Close()
+		return
+	}
+	if hex.EncodeToString(h.Sum(nil)) != sum {
+		sess.fail(errChecksum)
+		return
+	}
+
+	key, err := resolve(sess.cwd, name)
+	if err != nil {
+		sess.fail(err)
+		return
+	}
+
+	// As in get, an owner's refusal (e.g. 403) beats "no owner reachable"
+	var stored, failed []string
+	var refused error
+	for _, node := range c.owners(key) {
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			break
+		}
+
+		if node == c.self {
+			err = c.storeLocal(key, spool, size, sum)
+		} else {
+			err = c.storePeer(node, key, spool, size, sum)
+		}
+		if err != nil {
+			fmt.Printf("PUT %s on %s failed: %v\n", key, node, err)
+			failed = append(failed, node)
+			var unreachable *net.OpError
+			if !errors.As(err, &unreachable) {
+				refused = err
+			}
+			continue
+		}
+		stored = append(stored, node)
+	}
+
+	if len(stored) == 0 {
+		sess.fail(cmp.Or(refused, errorf(codeUnavailable, "no replica of %s stored", key)))
+		return
+	}
+	fmt.Printf("PUT %s: %d bytes on %s\n", key, size, strings.Join(stored, ", "))
+	sess.send(fmt.Sprintf("OK stored %s on %d/%d replicas (%s)", key, len(stored), len(stored)+len(failed), strings.Join(stored, ", ")))
+}
+
+// rejectPut answers a PUT that is refused before its data is read. The
+// client streams the data right after the header, so the declared size is
+// read and discarded before the reply; otherwise the data would be taken
+// for commands. Without a usable size the data cannot be told apart from
+// the next command, so the connection is closed after the reply.
+func rejectPut(sess *session, args string, reason error) {
+	fields := strings.Fields(args)
+	size := int64(-1)
+	if len(fields) >= 2 {
+		if n, err := strconv.ParseInt(fields[len(fields)-2], 10, 64); err == nil {
+			size = n
+		}
+	}
+	if size < 0 {
+		sess.fail(reason)
+		sess.conn.Close()
+		return
+	}
+
+	if _, err := io.CopyN(io.Discard, sess.reader, size); err != nil {
+		sess.conn.Close()
+		return
+	}
+	sess.fail(reason)
+}
+
+// storePeer sends a file to another node with LPUT.
+func (c *Cluster) storePeer(node, key string, r io.Reader, size int64, sum string) error {
+	p, err := c.dialPeer(node)
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+
+	if _, err := fmt.Fprintf(p.conn, "LPUT %d %s %s\n", size, sum, key); err != nil {
+		return err
+	}
+	if _, err := io.CopyN(p.conn, r, size); err != nil {
+		return err
+	}
+
+	resp, err := p.r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if err, ok := parseError(strings.TrimSpace(resp)); ok {
+		return err
+	}
+	return nil
+}
+
+// list answers a client DIR: the entries of the current directory on all
+// nodes, merged by name. A replicated file appears once, with the newest
+// copy's metadata. Unreachable nodes are skipped.
+func (c *Cluster) list(sess *session, arg string) {
+	opts, err := parseListArgs(arg)
+	if err != nil {
+		sess.fail(err)
+		return
+	}
+
+	dir := sess.cwd
+
+	merged := make(map[string]fs.FileInfo)
+	add := func(info fs.FileInfo) {
+		if strings.HasSuffix(info.Name(), ".part") {
+			return
+		}
+		if old, ok := merged[info.Name()]; !ok || info.ModTime().After(old.ModTime()) {
+			merged[info.Name()] = info
+		}
+	}
+
+	local, err := readDir(c.fsys.FS(), dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		sess.fail(exportError(err, dir))
+		return
+	}
+	for _, info := range local {
+		add(info)
+	}
+
+	for _, node := range c.nodes {
+		if node == c.self {
+			continue
+		}
+		entries, err := c.listPeer(node, dir)
+		if err != nil {
+			fmt.Printf("DIR on %s skipped: %v\n", node, err)
+			continue
+		}
+		for _, info := range entries {
+			add(info)
+		}
+	}
+
+	var entries []fs.FileInfo
+	for _, info := range merged {
+		if opts.match(info.Name()) {
+			entries = append(entries, info)
+		}
+	}
+	sortEntries(entries, opts)
+
+	for _, info := range entries {
+		sess.send(formatEntry(info))
+	}
+	sess.send("")
+}
+
+// listPeer reads a node's local entries of dir with LDIR.
+func (c *Cluster) listPeer(node, dir string) ([]fs.FileInfo, error) {
+	p, err := c.dialPeer(node)
+	if err != nil {
+		return nil, err
+	}
+	defer p.Close()
+
+	if _, err := p.conn.Write([]byte("LDIR " + dir + "\n")); err != nil {
+		return nil, err
+	}
+
+	var entries []fs.FileInfo
+	for {
+		line, err := p.r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			return entries, nil
+		}
+		if err, ok := parseError(line); ok {
+			return nil, err
+		}
+		if info, err := parseEntry(line); err == nil {
+			entries = append(entries, info)
+		}
+	}
+}
+
+// where answers WHERE <name> with the owners of a path.
+func (c *Cluster) where(sess *session, name string) {
+	key, err := resolve(sess.cwd, name)
+	if err != nil {
+		sess.fail(err)
+		return
+	}
+	sess.send(fmt.Sprintf("OK %s -> %s", key, strings.Join(c.ring.Owners(key, c.replicas), ", ")))
+}
+
+// handlePeer runs the node-local commands for a logged in peer.
+func (c *Cluster) handlePeer(sess *session, line string) {
+	command, arg, _ := strings.Cut(line, " ")
+
+	switch command {
+	case "LGET":
+		key, err := resolve(".", arg)
+		if err != nil {
+			sess.fail(err)
+			return
+		}
+		f, size, sum, err := c.openLocal(key)
+		if err != nil {
+			sess.fail(err)
+			return
+		}
+		sendFile(sess, f, size, sum)
+		f.Close()
+
+	case "LPUT":
+		// "LPUT <size> <sha256> <key>"; a malformed header leaves the
+		// data unframed, so the connection is dropped after the reply
+		fields := strings.SplitN(arg, " ", 3)
+		size := int64(-1)
+		if len(fields) == 3 {
+			if n, err := strconv.ParseInt(fields[0], 10, 64); err == nil {
+				size = n
+			}
+		}
+		if size < 0 {
+			sess.fail(errorf(codeBadRequest, "usage: LPUT <size> <sha256> <key>"))
+			sess.conn.Close()
+			return
+		}
+		key, err := resolve(".", fields[2])
+		if err != nil {
+			io.CopyN(io.Discard, sess.reader, size)
+			sess.fail(err)
+			return
+		}
+		if err := c.storeLocal(key, sess.reader, size, fields[1]); err != nil {
+			sess.fail(err)
+			return
+		}
+		sess.send("OK")
+
+	case "LDIR":
+		key, err := resolve(".", arg)
+		var entries []fs.FileInfo
+		if err == nil {
+			entries, err = readDir(c.fsys.FS(), key)
+		}
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			sess.fail(exportError(err, arg))
+			return
+		}
+		for _, info := range entries {
+			sess.send(formatEntry(info))
+		}
+		sess.send("")
+
+	default:
+		sess.fail(errorf(codeUnknown, "unknown peer command %q", command))
+	}
+}
+
+// entryInfo is an fs.FileInfo parsed from a DIR line of another node.
+type entryInfo struct {
+	name string
+	size int64
+	mode fs.FileMode
+	mod  time.Time
+}
+
+func (e entryInfo) Name() string       { return e.name }
+func (e entryInfo) Size() int64        { return e.size }
+func (e entryInfo) Mode() fs.FileMode  { return e.mode }
+func (e entryInfo) ModTime() time.Time { return e.mod }
+func (e entryInfo) IsDir() bool        { return e.mode.IsDir() }
+func (e entryInfo) Sys() any           { return nil }
+
+// parseEntry reads a line written by formatEntry.
+func parseEntry(line string) (fs.FileInfo, error) {
+	facts, name, ok := strings.Cut(line, " ")
+	if !ok {
+		return nil, fmt.Errorf("bad entry %q", line)
+	}
+
+	e := entryInfo{name: name}
+	for _, fact := range strings.Split(strings.TrimSuffix(facts, ";"), ";") {
+		key, value, _ := strings.Cut(fact, "=")
+		switch key {
+		case "type":
+			if value == "dir" {
+				e.mode |= fs.ModeDir
+			}
+		case "size":
+			e.size, _ = strconv.ParseInt(value, 10, 64)
+		case "modify":
+			e.mod, _ = time.Parse("20060102150405", value)
+		case "UNIX.mode":
+			m, _ := strconv.ParseUint(value, 8, 32)
+			e.mode |= fs.FileMode(m).Perm()
+		}
+	}
+	return e, nil
+}
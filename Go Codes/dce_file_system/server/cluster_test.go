@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRingOwners(t *testing.T) {
+	nodes := []string{"a:1", "b:1", "c:1"}
+	ring := NewRing(nodes, virtualNodes)
+
+	counts := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprintf("file%d", i)
+		owners := ring.Owners(key, 2)
+		if len(owners) != 2 || owners[0] == owners[1] {
+			t.Fatalf("Owners(%q) = %v, want 2 distinct nodes", key, owners)
+		}
+		if !slices.Equal(owners, ring.Owners(key, 2)) {
+			t.Fatalf("Owners(%q) is not stable", key)
+		}
+		counts[owners[0]]++
+	}
+	for _, node := range nodes {
+		if counts[node] < 600 {
+			t.Errorf("node %s is primary for only %d of 3000 keys: %v", node, counts[node], counts)
+		}
+	}
+
+	if got := ring.Owners("x", 5); len(got) != 3 {
+		t.Errorf("Owners with n > nodes = %v, want all 3 nodes", got)
+	}
+}
+
+// TestClusterReplication runs three nodes on loopback with two replicas.
+// Files are uploaded through one node and must be readable through every
+// node, stored on exactly their owners, and survive one owner going down.
+func TestClusterReplication(t *testing.T) {
+	nodes := startCluster(t, 3, 2, testUsers)
+	byAddr := make(map[string]*testNode)
+	for _, node := range nodes {
+		byAddr[node.addr] = node
+	}
+
+	files := make(map[string][]byte)
+	for i := 0; i < 12; i++ {
+		files[fmt.Sprintf("dir%d/file%d.txt", i%3, i)] = []byte(strings.Repeat(fmt.Sprintf("data %d\n", i), i+1))
+	}
+
+	up := dial(t, nodes[0].addr)
+	up.login("admin")
+	for name, data := range files {
+		wantReply(t, "PUT "+name, up.put(name, data), "OK stored "+name+" on 2/2 replicas")
+	}
+
+	for name := range files {
+		owners := nodes[0].cluster.ring.Owners(name, 2)
+		for _, node := range nodes {
+			_, err := os.Stat(filepath.Join(node.dir, filepath.FromSlash(name)))
+			if stored := err == nil; stored != slices.Contains(owners, node.addr) {
+				t.Errorf("%s on %s: stored %v, owners %v", name, node.addr, stored, owners)
+			}
+		}
+	}
+
+	// Every node serves every file, relaying from an owner if needed
+	for _, node := range nodes {
+		c := dial(t, node.addr)
+		c.login("admin")
+		for name, data := range files {
+			if header, got := c.get(name); string(got) != string(data) {
+				t.Errorf("GET %s via %s: %s %q", name, node.addr, header, got)
+			}
+		}
+		if got := c.cmd("WHERE dir0/file0.txt"); !strings.Contains(got, strings.Join(nodes[0].cluster.ring.Owners("dir0/file0.txt", 2), ", ")) {
+			t.Errorf("WHERE via %s: %s", node.addr, got)
+		}
+
+		// DIR merges all nodes, every file once
+		wantReply(t, "CD dir1", c.cmd("CD dir1"), "SUCCEEDED")
+		var names []string
+		for _, line := range c.dir("") {
+			names = append(names, line[strings.LastIndex(line, " ")+1:])
+		}
+		if want := []string{"file1.txt", "file10.txt", "file4.txt", "file7.txt"}; !slices.Equal(names, want) {
+			t.Errorf("DIR dir1 via %s: %v, want %v", node.addr, names, want)
+		}
+	}
+
+	// One owner down: every file still has another replica
+	name := "dir0/file0.txt"
+	victim := byAddr[nodes[0].cluster.ring.Owners(name, 2)[0]]
+	victim.ln.Close()
+	for _, node := range nodes {
+		if node == victim {
+			continue
+		}
+		c := dial(t, node.addr)
+		c.login("admin")
+		for name, data := range files {
+			if header, got := c.get(name); string(got) != string(data) {
+				t.Errorf("GET %s via %s with %s down: %s", name, node.addr, victim.addr, header)
+			}
+		}
+		fresh := "new-" + node.addr
+		wantReply(t, "PUT with a node down", c.put(fresh, []byte("new")), "OK stored "+fresh+" on ")
+		if _, got := c.get(fresh); string(got) != "new" {
+			t.Errorf("GET %s with %s down: %q", fresh, victim.addr, got)
+		}
+	}
+}
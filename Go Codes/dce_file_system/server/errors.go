@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+)
+
+// Error replies
+//
+// Every command gets exactly one response. A failure is always one line
+//
+//	ERR <code> <message>
+//
+// in every state and for every command, including DIR and GET, so a
+// client only has to look at the first line of a reply to know whether
+// more follows. The codes follow HTTP where there is an equivalent.
+const (
+	codeBadRequest   = 400 // Malformed command or arguments
+	codeUnauthorized = 401 // Not logged in or wrong credentials
+	codeForbidden    = 403 // Permission denied or path outside the export root
+	codeNotFound     = 404 // No such file or directory
+	codeLocked       = 423 // Account locked after failed logins
+	codeInternal     = 500 // I/O failure on the server
+	codeUnknown      = 501 // Unknown command
+	codeUnavailable  = 503 // No node holding the file is reachable
+)
+
+// protoError is an error with the code it is reported with.
+type protoError struct {
+	code int
+	msg  string
+}
+
+func (e *protoError) Error() string {
+	return e.msg
+}
+
+// errorf builds a protoError.
+func errorf(code int, format string, args ...any) error {
+	return &protoError{code: code, msg: fmt.Sprintf(format, args...)}
+}
+
+// errorCode picks the code for any error; plain errors are I/O failures.
+func errorCode(err error) int {
+	var pe *protoError
+	switch {
+	case errors.As(err, &pe):
+		return pe.code
+	case errors.Is(err, fs.ErrNotExist):
+		return codeNotFound
+	case errors.Is(err, fs.ErrPermission):
+		return codeForbidden
+	}
+	return codeInternal
+}
+
+// errorLine formats the reply for err.
+func errorLine(err error) string {
+	return fmt.Sprintf("ERR %d %s", errorCode(err), err)
+}
+
+// parseError reads an "ERR <code> <message>" line, e.g. from a peer, so
+// the original code can be passed on.
+func parseError(line string) (error, bool) {
+	rest, ok := strings.CutPrefix(line, "ERR ")
+	if !ok {
+		return nil, false
+	}
+	code, msg, _ := strings.Cut(rest, " ")
+	n, err := strconv.Atoi(code)
+	if err != nil {
+		return errorf(codeInternal, "%s", rest), true
+	}
+	return errorf(n, "%s", msg), true
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+)
+
+// Export root
+//
+// Clients only see the directory given with -data. Every session has its
+// own working directory, a slash separated path relative to that root
+// ("." is the root itself), so one client's CD never moves another.
+//
+// Client paths are resolved against the session directory by resolve:
+// absolute paths and paths that climb above the root with ".." are
+// refused. The files themselves are opened through an *os.Root, so a
+// symbolic link inside the export that points outside it is refused by
+// the operating system as well.
+
+// errOutsideRoot is returned for paths that would leave the export root.
+var errOutsideRoot = errorf(codeForbidden, "path escapes the export root")
+
+// resolve turns a client path into a key relative to the export root.
+func resolve(cwd, name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if path.IsAbs(name) || isDrivePath(name) {
+		return "", errorf(codeForbidden, "%s: absolute paths are not allowed", name)
+	}
+
+	key := path.Join(cwd, name)
+	if key == ".." || strings.HasPrefix(key, "../") {
+		return "", errOutsideRoot
+	}
+	return key, nil
+}
+
+// isDrivePath reports whether name starts with a Windows drive such as
+// "c:/". Names like "a:b" are ordinary relative names.
+func isDrivePath(name string) bool {
+	if len(name) < 3 || name[1] != ':' || name[2] != '/' {
+		return false
+	}
+	c := name[0] | 0x20
+	return 'a' <= c && c <= 'z'
+}
+
+// exportError hides the node's real paths from errors returned by the
+// export root and maps symlink escapes to errOutsideRoot.
+func exportError(err error, key string) error {
+	var pe *fs.PathError
+	var le *os.LinkError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &pe):
+		err = pe.Err
+	case errors.As(err, &le):
+		err = le.Err
+	}
+
+	switch {
+	case strings.Contains(err.Error(), "escapes from parent"):
+		return errOutsideRoot
+	case errors.Is(err, fs.ErrNotExist):
+		return errorf(codeNotFound, "%s: no such file or directory", key)
+	}
+	return errorf(errorCode(err), "%s: %v", key, err)
+}
+
+// changeDir answers "CD <dir>". The directory may exist on another node
+// only, since PUT creates parent directories on the owners of a file.
+func (c *Cluster) changeDir(sess *session, name string) {
+	key, err := resolve(sess.cwd, name)
+	if err != nil {
+		sess.fail(err)
+		return
+	}
+
+	info, err := c.fsys.Stat(key)
+	if err == nil && !info.IsDir() {
+		sess.fail(errorf(codeBadRequest, "%s is not a directory", key))
+		return
+	}
+	if err != nil && (!errors.Is(err, fs.ErrNotExist) || !c.remoteDir(key)) {
+		sess.fail(exportError(err, key))
+		return
+	}
+
+	sess.cwd = key
+	sess.send("SUCCEEDED")
+}
+
+// remoteDir reports whether any other node has key as a directory.
+func (c *Cluster) remoteDir(key string) bool {
+	if key == "." {
+		return true
+	}
+
+	parent, base := path.Split(key)
+	for _, node := range c.nodes {
+		if node == c.self {
+			continue
+		}
+		entries, err := c.listPeer(node, path.Clean(parent))
+		if err != nil {
+			continue
+		}
+		for _, info := range entries {
+			if info.Name() == base && info.IsDir() {
+				return true
+			}
+		}
+	}
+	return false
+}
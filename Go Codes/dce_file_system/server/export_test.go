@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	for _, tc := range []struct {
+		cwd, name string
+		want      string // "" when the path must be refused
+	}{
+		{".", "a.txt", "a.txt"},
+		{".", "sub/../a.txt", "a.txt"},
+		{"sub", "a.txt", "sub/a.txt"},
+		{"sub", "..", "."},
+		{"sub", `dir\file`, "sub/dir/file"},
+		{".", "..", ""},
+		{".", "../a.txt", ""},
+		{".", "sub/../../a.txt", ""},
+		{"sub", "../../a.txt", ""},
+		{".", "/etc/passwd", ""},
+		{".", `\etc\passwd`, ""},
+		{".", `C:\Windows`, ""},
+		{".", "c:/x", ""},
+		{".", "a:b", "a:b"},
+		{"sub", "1:/x", "sub/1:/x"},
+	} {
+		got, err := resolve(tc.cwd, tc.name)
+		switch {
+		case tc.want == "" && err == nil:
+			t.Errorf("resolve(%q, %q) = %q, want it refused", tc.cwd, tc.name, got)
+		case tc.want == "" && errorCode(err) != codeForbidden:
+			t.Errorf("resolve(%q, %q): code %d, want %d", tc.cwd, tc.name, errorCode(err), codeForbidden)
+		case tc.want != "" && (err != nil || got != tc.want):
+			t.Errorf("resolve(%q, %q) = %q, %v, want %q", tc.cwd, tc.name, got, err, tc.want)
+		}
+	}
+}
+
+// TestSymlinkEscape puts symbolic links into the export that point
+// outside it. resolve cannot see them; the export root must refuse them
+// with 403 for every command.
+func TestSymlinkEscape(t *testing.T) {
+	outside := t.TempDir()
+	os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0o644)
+
+	node := startServer(t, testUsers)
+	os.Mkdir(filepath.Join(node.dir, "sub"), 0o755)
+	os.WriteFile(filepath.Join(node.dir, "sub", "ok.txt"), []byte("ok"), 0o644)
+	for link, target := range map[string]string{
+		"outdir":         outside,
+		"secret.txt":     filepath.Join(outside, "secret.txt"),
+		"sub/up.txt":     filepath.Join("..", "..", filepath.Base(outside), "secret.txt"), // outside is a sibling of the export
+		"sub/inside.txt": "ok.txt",                                                        // stays inside, must keep working
+		"sub/relout":     filepath.Join("..", ".."),
+	} {
+		if err := os.Symlink(target, filepath.Join(node.dir, link)); err != nil {
+			t.Skipf("cannot create symlinks: %v", err)
+		}
+	}
+
+	c := dial(t, node.addr)
+	c.login("admin")
+	for _, command := range []string{
+		"GET secret.txt",
+		"GET outdir/secret.txt",
+		"GET sub/up.txt",
+		"GET sub/relout/etc/passwd",
+		"CD outdir",
+		"CD sub/relout",
+	} {
+		wantReply(t, command, c.cmd(command), "ERR 403 ")
+	}
+
+	if _, data := c.get("sub/inside.txt"); string(data) != "ok" {
+		t.Errorf("GET through an inside link: got %q", data)
+	}
+
+	// Writing through a link must not create anything outside either
+	wantReply(t, "PUT outdir/new.txt", c.put("outdir/new.txt", []byte("x")), "ERR 403 ")
+	if _, err := os.Stat(filepath.Join(outside, "new.txt")); !errors.Is(err, os.ErrNotExist) {
+		t.Error("PUT wrote outside the export root")
+	}
+
+	// A CD that failed leaves the session where it was
+	wantReply(t, "CD sub", c.cmd("CD sub"), "SUCCEEDED")
+	wantReply(t, "GET ../../x", c.cmd("GET ../../x"), "ERR 403 ")
+	if _, data := c.get("ok.txt"); string(data) != "ok" {
+		t.Errorf("GET after CD: got %q", data)
+	}
+}
@@ -2,93 +2,362 @@ package main
 
 import (
 	"bufio"
+	"crypto/subtle"
+	"flag"
 	"fmt"
+	"io"
+	"io/fs"
 	"net"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 )
 
 func main() {
-	ln, err := net.Listen("tcp", ":8080")
+	usersFile := flag.String("users", "users.txt", "users file (name:hash:commands, see auth.go)")
+	maxFailures := flag.Int("max-failures", 5, "failed logins before an account is locked")
+	lockout := flag.Duration("lockout", 5*time.Minute, "how long a locked account stays locked")
+	hashPw := flag.Bool("hash-password", false, "read a password from stdin, print its hash and exit")
+	listen := flag.String("listen", ":8080", "address to listen on")
+	self := flag.String("self", "", "this node's address in -nodes (default: the listen address)")
+	nodes := flag.String("nodes", "", "comma separated addresses of all cluster nodes, see cluster.go")
+	replicas := flag.Int("replicas", 2, "number of nodes each file is stored on")
+	secret := flag.String("secret", "", "shared secret nodes use to log in to each other")
+	dataDir := flag.String("data", ".", "export root; clients cannot reach files outside it")
+	flag.Parse()
+
+	if *hashPw {
+		fmt.Fprint(os.Stderr, "Password: ")
+		line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		hash, err := hashPassword(strings.TrimRight(line, "\r\n"))
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println(hash)
+		return
+	}
+
+	store, err := LoadUsers(*usersFile)
 	if err != nil {
 		panic(err)
 	}
-	fmt.Println("Server started on :8080")
+	auth, err := NewLockout(store, *maxFailures, *lockout)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Loaded %d users from %s\n", len(store.users), *usersFile)
+
+	if *self == "" {
+		*self = *listen
+		if strings.HasPrefix(*self, ":") {
+			*self = "127.0.0.1" + *self
+		}
+	}
+	var members []string
+	for _, node := range strings.Split(*nodes, ",") {
+		if node = strings.TrimSpace(node); node != "" {
+			members = append(members, node)
+		}
+	}
+	cluster, err := NewCluster(*self, members, *replicas, *secret, *dataDir)
+	if err != nil {
+		panic(err)
+	}
+
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Server started on %s, serving %s\n", *listen, cluster.dir)
+	if len(cluster.nodes) > 1 {
+		fmt.Printf("Cluster node %s of %s, %d replicas\n", cluster.self, strings.Join(cluster.nodes, ", "), cluster.replicas)
+	}
 
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
 			continue
 		}
-		go handleClient(conn)
+		go handleClient(conn, auth, cluster)
 	}
 }
 
-func handleClient(conn net.Conn) {
+// sessionState is where a session is in the protocol
+type sessionState int
+
+const (
+	stateLogin sessionState = iota // waiting for LOGIN
+	stateReady                     // logged in, file commands allowed
+	statePeer                      // another cluster node, see cluster.go
+)
+
+func (s sessionState) String() string {
+	switch s {
+	case stateLogin:
+		return "login"
+	case stateReady:
+		return "ready"
+	case statePeer:
+		return "peer"
+	}
+	return "unknown"
+}
+
+// session carries the state and identity of one connection
+type session struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	state  sessionState
+	user   *User  // nil until LOGIN succeeded
+	cwd    string // working directory relative to the export root
+}
+
+func (s *session) send(line string) {
+	s.conn.Write([]byte(line + "\n"))
+}
+
+// fail sends the error reply for err, see errors.go.
+func (s *session) fail(err error) {
+	s.send(errorLine(err))
+}
+
+// name identifies the session in log lines.
+func (s *session) name() string {
+	if s.user != nil {
+		return s.user.Name
+	}
+	return "peer " + s.conn.RemoteAddr().String()
+}
+
+// commands are the client commands of the ready state, see handleClient.
+var commands = map[string]bool{
+	"DIR": true, "GET": true, "PUT": true, "CD": true, "WHERE": true, "NODES": true,
+}
+
+func handleClient(conn net.Conn, auth Authenticator, cluster *Cluster) {
 	defer conn.Close()
 
-	state := "login"
-	reader := bufio.NewReader(conn)
+	sess := &session{conn: conn, reader: bufio.NewReader(conn), state: stateLogin, cwd: "."}
 
 	for {
-		line, err := reader.ReadString('\n')
+		line, err := sess.reader.ReadString('\n')
 		if err != nil {
 			return
 		}
 
 		line = strings.TrimSpace(line)
+		command, arg, _ := strings.Cut(line, " ")
 
-		switch state {
+		// Every command gets exactly one reply, in every state
+		switch {
+		case line == "quit":
+			sess.send("bye")
+			return
+		case line == "":
+			sess.fail(errorf(codeBadRequest, "empty command"))
+			continue
+		}
 
-		case "login":
-			parts := strings.Split(line, " ")
-			if len(parts) == 3 && parts[0] == "LOGIN" {
-				user := parts[1]
-				pass := parts[2]
+		switch sess.state {
 
-				if user == "admin" && pass == "123" {
-					conn.Write([]byte("OK\n"))
-					state = "file_transfer"
-				} else {
-					conn.Write([]byte("FAILED\n"))
+		case stateLogin:
+			if command == "PEER" && cluster.secret != "" {
+				if subtle.ConstantTimeCompare([]byte(arg), []byte(cluster.secret)) != 1 {
+					fmt.Printf("Peer login failed from %s\n", conn.RemoteAddr())
+					sess.fail(errorf(codeUnauthorized, "invalid cluster secret"))
+					return
 				}
-			} else {
-				conn.Write([]byte("FAILED\n"))
+				sess.state = statePeer
+				sess.send("OK")
+				continue
 			}
 
-		case "file_transfer":
+			if command != "LOGIN" {
+				err := errorf(codeUnauthorized, "log in first: LOGIN <user> <password>")
+				if command == "PUT" {
+					rejectPut(sess, arg, err)
+				} else {
+					sess.fail(err)
+				}
+				continue
+			}
+			parts := strings.Split(line, " ")
+			if len(parts) != 3 {
+				sess.fail(errorf(codeBadRequest, "usage: LOGIN <user> <password>"))
+				continue
+			}
 
-			if line == "quit" {
-				conn.Write([]byte("bye\n"))
-				return
+			user, err := auth.Authenticate(parts[1], parts[2])
+			if err != nil {
+				fmt.Printf("Login failed for %q from %s: %v\n", parts[1], conn.RemoteAddr(), err)
+				sess.fail(err)
+				continue
 			}
 
-			if strings.HasPrefix(line, "DIR") {
-				files, _ := os.ReadDir(".")
-				for _, f := range files {
-					conn.Write([]byte(f.Name() + "\n"))
-				}
-				conn.Write([]byte("\n"))
+			sess.user = user
+			sess.state = stateReady
+			fmt.Printf("User %s logged in from %s\n", user.Name, conn.RemoteAddr())
+			sess.send("OK")
+
+		case statePeer:
+			cluster.handlePeer(sess, line)
+
+		case stateReady:
+			if !commands[command] {
+				sess.fail(errorf(codeUnknown, "unknown command %q", command))
+				continue
 			}
 
-			if strings.HasPrefix(line, "GET ") {
-				filename := strings.TrimPrefix(line, "GET ")
-				data, err := os.ReadFile(filename)
-				if err != nil {
-					conn.Write([]byte("FAILED\n"))
+			// Every command is checked against the user's allowed commands
+			if !sess.user.Can(command) {
+				fmt.Printf("User %s denied %s\n", sess.user.Name, command)
+				err := errorf(codeForbidden, "permission denied")
+				if command == "PUT" {
+					rejectPut(sess, arg, err)
 				} else {
-					conn.Write([]byte(string(data) + "\n"))
+					sess.fail(err)
 				}
+				continue
 			}
 
-			if strings.HasPrefix(line, "CD ") {
-				dir := strings.TrimPrefix(line, "CD ")
-				if os.Chdir(dir) == nil {
-					conn.Write([]byte("SUCCEEDED\n"))
-				} else {
-					conn.Write([]byte("FAILED\n"))
+			switch command {
+			case "DIR":
+				cluster.list(sess, arg)
+			case "NODES":
+				sess.send(fmt.Sprintf("OK %s (self %s, %d replicas)", strings.Join(cluster.nodes, ", "), cluster.self, cluster.replicas))
+			case "PUT":
+				cluster.put(sess, arg)
+			default:
+				// The rest take one path argument
+				if arg == "" {
+					sess.fail(errorf(codeBadRequest, "usage: %s <path>", command))
+					continue
+				}
+				switch command {
+				case "GET":
+					cluster.get(sess, arg)
+				case "CD":
+					cluster.changeDir(sess, arg)
+				case "WHERE":
+					cluster.where(sess, arg)
 				}
 			}
 		}
 	}
 }
+
+// sendFile answers "GET <name>" with a header line followed by the raw
+// file contents, so files with newlines or binary data arrive intact:
+//
+//	OK <size> <sha256 hex>\n
+//	<size bytes>
+//
+// or "ERR <code> <message>" when the file cannot be sent. openLocal computes
+// the checksum in a first pass, so the header is known before any data
+// is sent.
+func sendFile(sess *session, f *os.File, size int64, sum string) {
+	sess.send(fmt.Sprintf("OK %d %s", size, sum))
+
+	// Exactly size bytes follow; if the file shrank meanwhile the client
+	// sees a short read and the connection is closed
+	n, err := io.CopyN(sess.conn, f, size)
+	if err != nil {
+		fmt.Printf("GET %s aborted after %d bytes: %v\n", f.Name(), n, err)
+		sess.conn.Close()
+		return
+	}
+	fmt.Printf("GET %s: %d bytes sent to %s\n", f.Name(), n, sess.name())
+}
+
+// listOptions are the arguments of "DIR [-S|-t|-r] [pattern]". Entries
+// are sorted by name, -S sorts by size (largest first), -t by
+// modification time (newest first) and -r reverses the order. The pattern
+// uses filepath.Match syntax, e.g. "DIR -S *.png". The options come
+// first; the rest of the line is the pattern as sent, spaces included.
+type listOptions struct {
+	sortBy  string
+	reverse bool
+	pattern string
+}
+
+func parseListArgs(arg string) (listOptions, error) {
+	opts := listOptions{sortBy: "name"}
+	for {
+		arg = strings.TrimLeft(arg, " ")
+		option, rest, _ := strings.Cut(arg, " ")
+		switch option {
+		case "-S":
+			opts.sortBy = "size"
+		case "-t":
+			opts.sortBy = "time"
+		case "-r":
+			opts.reverse = true
+		default:
+			if _, err := filepath.Match(arg, ""); err != nil {
+				return opts, errorf(codeBadRequest, "invalid pattern %q", arg)
+			}
+			opts.pattern = arg
+			return opts, nil
+		}
+		arg = rest
+	}
+}
+
+// match reports whether name passes the pattern filter.
+func (o listOptions) match(name string) bool {
+	if o.pattern == "" {
+		return true
+	}
+	ok, _ := filepath.Match(o.pattern, name)
+	return ok
+}
+
+// readDir returns the entries of a directory on this node.
+func readDir(fsys fs.FS, dir string) ([]fs.FileInfo, error) {
+	files, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []fs.FileInfo
+	for _, f := range files {
+		info, err := f.Info()
+		if err != nil {
+			continue // removed while listing
+		}
+		entries = append(entries, info)
+	}
+	return entries, nil
+}
+
+// sortEntries orders a listing as requested by opts.
+func sortEntries(entries []fs.FileInfo, opts listOptions) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if opts.reverse {
+			a, b = b, a
+		}
+		switch {
+		case opts.sortBy == "size" && a.Size() != b.Size():
+			return a.Size() > b.Size()
+		case opts.sortBy == "time" && !a.ModTime().Equal(b.ModTime()):
+			return a.ModTime().After(b.ModTime())
+		}
+		return a.Name() < b.Name()
+	})
+}
+
+// formatEntry writes one DIR line in the MLSD fact format of RFC 3659:
+//
+//	type=file;size=1024;modify=20250114093015;UNIX.mode=0644; photo1.png
+func formatEntry(info fs.FileInfo) string {
+	kind := "file"
+	if info.IsDir() {
+		kind = "dir"
+	}
+	return fmt.Sprintf("type=%s;size=%d;modify=%s;UNIX.mode=%04o; %s",
+		kind, info.Size(), info.ModTime().UTC().Format("20060102150405"),
+		info.Mode().Perm(), info.Name())
+}
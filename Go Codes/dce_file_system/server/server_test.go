@@ -0,0 +1,495 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Protocol tests. They start real servers on loopback ports and talk to
+// them like the client does. The server directory has no go.mod, so run
+// them with the file list:
+//
+//	go test *.go
+
+const testSecret = "s3cret"
+
+// testAuth accepts "<name>-pw" as the password of every user it knows;
+// hashing real passwords would only slow the tests down.
+type testAuth map[string]*User
+
+func (a testAuth) Authenticate(name, password string) (*User, error) {
+	u, ok := a[name]
+	if !ok || password != name+"-pw" {
+		return nil, errBadCredentials
+	}
+	return u, nil
+}
+
+var testUsers = testAuth{
+	"admin": {Name: "admin"},
+	"guest": {Name: "guest", commands: map[string]bool{"DIR": true, "CD": true}},
+}
+
+// testNode is one server started by startCluster.
+type testNode struct {
+	addr    string
+	dir     string // Export root
+	cluster *Cluster
+	ln      net.Listener
+}
+
+// startCluster starts n nodes on loopback, each with its own export
+// root. extra addresses are added to the node list without starting
+// anything there, e.g. to simulate a node that is down.
+func startCluster(t *testing.T, n, replicas int, auth Authenticator, extra ...string) []*testNode {
+	t.Helper()
+	nodes := make([]*testNode, n)
+	var addrs []string
+	for i := range nodes {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { ln.Close() })
+		nodes[i] = &testNode{addr: ln.Addr().String(), dir: t.TempDir(), ln: ln}
+		addrs = append(addrs, nodes[i].addr)
+	}
+	addrs = append(addrs, extra...)
+
+	for _, node := range nodes {
+		cluster, err := NewCluster(node.addr, addrs, replicas, testSecret, node.dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { cluster.fsys.Close() })
+		node.cluster = cluster
+
+		go func() {
+			for {
+				conn, err := node.ln.Accept()
+				if err != nil {
+					return
+				}
+				go handleClient(conn, auth, cluster)
+			}
+		}()
+	}
+	return nodes
+}
+
+// startServer starts a single node.
+func startServer(t *testing.T, auth Authenticator) *testNode {
+	t.Helper()
+	return startCluster(t, 1, 1, auth)[0]
+}
+
+// testClient speaks the protocol to one server.
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dial(t *testing.T, addr string) *testClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	return &testClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+func (c *testClient) send(line string) {
+	c.t.Helper()
+	if _, err := c.conn.Write([]byte(line + "\n")); err != nil {
+		c.t.Fatalf("send %q: %v", line, err)
+	}
+}
+
+// line reads one reply line.
+func (c *testClient) line() string {
+	c.t.Helper()
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatalf("reading reply: %v", err)
+	}
+	return strings.TrimRight(line, "\r\n")
+}
+
+// cmd sends a command and returns its one line reply.
+func (c *testClient) cmd(line string) string {
+	c.t.Helper()
+	c.send(line)
+	return c.line()
+}
+
+func (c *testClient) login(user string) {
+	c.t.Helper()
+	if reply := c.cmd("LOGIN " + user + " " + user + "-pw"); reply != "OK" {
+		c.t.Fatalf("LOGIN %s: %s", user, reply)
+	}
+}
+
+// put uploads data the way the client does: header and data in one go.
+func (c *testClient) put(name string, data []byte) string {
+	c.t.Helper()
+	c.conn.Write([]byte(fmt.Sprintf("PUT %s %d %s\n", name, len(data), sha(data))))
+	c.conn.Write(data)
+	return c.line()
+}
+
+// get downloads a file. On an error reply data is nil.
+func (c *testClient) get(name string) (string, []byte) {
+	c.t.Helper()
+	header := c.cmd("GET " + name)
+	var size int64
+	var sum string
+	if _, err := fmt.Sscanf(header, "OK %d %s", &size, &sum); err != nil {
+		return header, nil
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		c.t.Fatalf("GET %s: %v", name, err)
+	}
+	if sha(data) != sum {
+		c.t.Fatalf("GET %s: checksum mismatch", name)
+	}
+	return header, data
+}
+
+// dir returns the lines of a DIR listing, or the error line.
+func (c *testClient) dir(args string) []string {
+	c.t.Helper()
+	c.send(strings.TrimSpace("DIR " + args))
+	var lines []string
+	for {
+		line := c.line()
+		if line == "" {
+			return lines
+		}
+		if strings.HasPrefix(line, "ERR ") {
+			return []string{line}
+		}
+		lines = append(lines, line)
+	}
+}
+
+// closed reports whether the server closed the connection without
+// sending anything more.
+func (c *testClient) closed() bool {
+	c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err := c.r.ReadByte()
+	return err == io.EOF
+}
+
+func sha(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// wantReply fails unless reply starts with want.
+func wantReply(t *testing.T, command, reply, want string) {
+	t.Helper()
+	if !strings.HasPrefix(reply, want) {
+		t.Errorf("%q: got %q, want %q...", command, reply, want)
+	}
+}
+
+// TestOneReplyPerCommand walks one session through every state and
+// command. Each command must get exactly one reply; an extra line would
+// shift every following reply.
+func TestOneReplyPerCommand(t *testing.T) {
+	node := startServer(t, testUsers)
+	os.WriteFile(filepath.Join(node.dir, "a.txt"), []byte("hello\n"), 0o644)
+
+	c := dial(t, node.addr)
+	for _, step := range []struct{ command, want string }{
+		{"", "ERR 400 "},
+		{"DIR", "ERR 401 "},
+		{"GET a.txt", "ERR 401 "},
+		{"LOGIN admin", "ERR 400 "},
+		{"LOGIN admin wrong", "ERR 401 "},
+		{"LOGIN nobody nobody-pw", "ERR 401 "},
+		{"LOGIN admin admin-pw", "OK"},
+		{"", "ERR 400 "},
+		{"FOO bar", "ERR 501 "},
+		{"LGET a.txt", "ERR 501 "},
+		{"GET", "ERR 400 "},
+		{"GET missing.txt", "ERR 404 "},
+		{"GET /etc/passwd", "ERR 403 "},
+		{"CD", "ERR 400 "},
+		{"CD ..", "ERR 403 "},
+		{"CD a.txt", "ERR 400 "},
+		{"CD missing", "ERR 404 "},
+		{"DIR [", "ERR 400 "},
+		{"WHERE", "ERR 400 "},
+		{"WHERE a.txt", "OK a.txt -> " + node.addr},
+		{"NODES", "OK " + node.addr},
+	} {
+		wantReply(t, step.command, c.cmd(step.command), step.want)
+	}
+
+	if got := c.dir("-S *.txt"); len(got) != 1 || !strings.HasSuffix(got[0], "; a.txt") {
+		t.Errorf("DIR: got %q", got)
+	}
+	if got := c.cmd("quit"); got != "bye" {
+		t.Errorf("quit: got %q", got)
+	}
+}
+
+func TestGuestPermissions(t *testing.T) {
+	node := startServer(t, testUsers)
+	os.WriteFile(filepath.Join(node.dir, "a.txt"), []byte("hello\n"), 0o644)
+
+	c := dial(t, node.addr)
+	c.login("guest")
+	for _, step := range []struct{ command, want string }{
+		{"GET a.txt", "ERR 403 permission denied"},
+		{"NODES", "ERR 403 permission denied"},
+		{"BOGUS", "ERR 501 "}, // unknown before forbidden
+		{"CD .", "SUCCEEDED"},
+	} {
+		wantReply(t, step.command, c.cmd(step.command), step.want)
+	}
+}
+
+func TestPutGet(t *testing.T) {
+	node := startServer(t, testUsers)
+	c := dial(t, node.addr)
+	c.login("admin")
+
+	data := []byte("line 1\nline 2\n\x00\xffbinary")
+	wantReply(t, "PUT", c.put("sub/dir/file.bin", data), "OK stored sub/dir/file.bin on 1/1 replicas")
+
+	if got, _ := os.ReadFile(filepath.Join(node.dir, "sub", "dir", "file.bin")); string(got) != string(data) {
+		t.Errorf("stored file: got %q", got)
+	}
+	if _, got := c.get("sub/dir/file.bin"); string(got) != string(data) {
+		t.Errorf("GET: got %q", got)
+	}
+
+	wantReply(t, "CD sub", c.cmd("CD sub"), "SUCCEEDED")
+	if _, got := c.get("dir/file.bin"); string(got) != string(data) {
+		t.Errorf("GET relative to CD: got %q", got)
+	}
+}
+
+// TestRejectedPut checks that the data of a refused PUT is discarded
+// and never read as commands.
+func TestRejectedPut(t *testing.T) {
+	body := []byte("DIR\nCD ..\nhello\n")
+
+	for _, tc := range []struct {
+		name   string
+		user   string // "" stays logged out
+		header string
+		want   string
+	}{
+		{"not logged in", "", fmt.Sprintf("PUT x.txt %d %s", len(body), sha(body)), "ERR 401 "},
+		{"forbidden", "guest", fmt.Sprintf("PUT x.txt %d %s", len(body), sha(body)), "ERR 403 "},
+		{"missing name", "admin", fmt.Sprintf("PUT %d %s", len(body), sha(body)), "ERR 400 usage"},
+		{"checksum", "admin", fmt.Sprintf("PUT x.txt %d %s", len(body), sha(nil)), "ERR 400 checksum"},
+		{"outside root", "admin", fmt.Sprintf("PUT ../x.txt %d %s", len(body), sha(body)), "ERR 403 "},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			node := startServer(t, testUsers)
+			c := dial(t, node.addr)
+			if tc.user != "" {
+				c.login(tc.user)
+			}
+
+			c.conn.Write([]byte(tc.header + "\n"))
+			c.conn.Write(body)
+			wantReply(t, tc.header, c.line(), tc.want)
+
+			// The next reply must belong to the next command
+			wantReply(t, "empty line", c.cmd(""), "ERR 400 empty command")
+			if _, err := os.Stat(filepath.Join(node.dir, "x.txt")); err == nil {
+				t.Error("rejected PUT stored x.txt")
+			}
+		})
+	}
+
+	// Without a size the data cannot be framed: one reply, then the
+	// connection is closed
+	node := startServer(t, testUsers)
+	c := dial(t, node.addr)
+	c.login("admin")
+	wantReply(t, "PUT x y", c.cmd("PUT x y"), "ERR 400 ")
+	if !c.closed() {
+		t.Error("connection still open after an unframed PUT")
+	}
+}
+
+func TestLockedAccount(t *testing.T) {
+	node := startServer(t, newLockout(t, testUsers, 2, time.Minute))
+	c := dial(t, node.addr)
+
+	wantReply(t, "1st wrong", c.cmd("LOGIN admin wrong"), "ERR 401 ")
+	wantReply(t, "2nd wrong", c.cmd("LOGIN admin wrong"), "ERR 423 ")
+	wantReply(t, "right password while locked", c.cmd("LOGIN admin admin-pw"), "ERR 423 ")
+	wantReply(t, "other user", c.cmd("LOGIN guest guest-pw"), "OK")
+}
+
+func TestPeerCommands(t *testing.T) {
+	node := startServer(t, testUsers)
+	os.WriteFile(filepath.Join(node.dir, "a.txt"), []byte("hello"), 0o644)
+
+	bad := dial(t, node.addr)
+	wantReply(t, "PEER wrong", bad.cmd("PEER wrong"), "ERR 401 ")
+	if !bad.closed() {
+		t.Error("connection still open after a bad PEER secret")
+	}
+
+	p := dial(t, node.addr)
+	wantReply(t, "PEER", p.cmd("PEER "+testSecret), "OK")
+
+	header := p.cmd("LGET a.txt")
+	wantReply(t, "LGET a.txt", header, fmt.Sprintf("OK 5 %s", sha([]byte("hello"))))
+	io.CopyN(io.Discard, p.r, 5)
+
+	wantReply(t, "LGET missing", p.cmd("LGET missing"), "ERR 404 ")
+	wantReply(t, "LGET ../x", p.cmd("LGET ../x"), "ERR 403 ")
+	wantReply(t, "DIR", p.cmd("DIR"), "ERR 501 ")
+
+	data := []byte("stored by a peer")
+	p.send(fmt.Sprintf("LPUT %d %s b.txt", len(data), sha(data)))
+	p.conn.Write(data)
+	wantReply(t, "LPUT", p.line(), "OK")
+
+	p.send(fmt.Sprintf("LPUT %d %s c.txt", len(data), sha(nil)))
+	p.conn.Write(data)
+	wantReply(t, "LPUT bad checksum", p.line(), "ERR 400 checksum")
+
+	p.send(fmt.Sprintf("LPUT %d %s ../d.txt", len(data), sha(data)))
+	p.conn.Write(data)
+	wantReply(t, "LPUT outside", p.line(), "ERR 403 ")
+
+	p.send("LDIR .")
+	var names []string
+	for line := p.line(); line != ""; line = p.line() {
+		names = append(names, line[strings.LastIndex(line, " ")+1:])
+	}
+	if strings.Join(names, ",") != "a.txt,b.txt" {
+		t.Errorf("LDIR: got %v", names)
+	}
+
+	wantReply(t, "LPUT malformed", p.cmd("LPUT nonsense"), "ERR 400 ")
+	if !p.closed() {
+		t.Error("connection still open after a malformed LPUT")
+	}
+}
+
+// TestUnavailableOwner covers the codes for owners that cannot be used:
+// 503 when the only owner is down, 500 when it answers nonsense.
+func TestUnavailableOwner(t *testing.T) {
+	// A port nothing listens on
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	down := ln.Addr().String()
+	ln.Close()
+
+	// A "node" that accepts the peer login but then answers garbage
+	broken, _ := net.Listen("tcp", "127.0.0.1:0")
+	t.Cleanup(func() { broken.Close() })
+	go func() {
+		for {
+			conn, err := broken.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if strings.HasPrefix(line, "PEER ") {
+						conn.Write([]byte("OK\n"))
+					} else {
+						conn.Write([]byte("what?\n"))
+					}
+				}
+			}()
+		}
+	}()
+
+	node := startCluster(t, 1, 1, testUsers, down, broken.Addr().String())[0]
+	keyOn := func(owner string) string {
+		for i := 0; ; i++ {
+			key := fmt.Sprintf("file%d", i)
+			if node.cluster.ring.Owners(key, 1)[0] == owner {
+				return key
+			}
+		}
+	}
+
+	c := dial(t, node.addr)
+	c.login("admin")
+	key := keyOn(down)
+	wantReply(t, "GET on down node", c.cmd("GET "+key), "ERR 503 ")
+	wantReply(t, "PUT on down node", c.put(key, []byte("x")), "ERR 503 ")
+	wantReply(t, "GET on broken node", c.cmd("GET "+keyOn(broken.Addr().String())), "ERR 500 ")
+}
+
+func TestParseListArgs(t *testing.T) {
+	for _, tc := range []struct {
+		arg     string
+		want    listOptions
+		wantErr bool
+	}{
+		{"", listOptions{sortBy: "name"}, false},
+		{"-S", listOptions{sortBy: "size"}, false},
+		{"-t -r", listOptions{sortBy: "time", reverse: true}, false},
+		{"-r  -S *.png", listOptions{sortBy: "size", reverse: true, pattern: "*.png"}, false},
+		{"my  notes*", listOptions{sortBy: "name", pattern: "my  notes*"}, false},
+		{"-S a b -r", listOptions{sortBy: "size", pattern: "a b -r"}, false},
+		{"[", listOptions{}, true},
+	} {
+		got, err := parseListArgs(tc.arg)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("parseListArgs(%q) accepted an invalid pattern", tc.arg)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("parseListArgs(%q) = %+v, %v, want %+v", tc.arg, got, err, tc.want)
+		}
+	}
+}
+
+// TestListPattern checks that a DIR pattern reaches the server as typed,
+// runs of spaces included.
+func TestListPattern(t *testing.T) {
+	node := startServer(t, testUsers)
+	for _, name := range []string{"my  notes.txt", "my notes.txt", "other.txt"} {
+		if err := os.WriteFile(filepath.Join(node.dir, name), []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := dial(t, node.addr)
+	c.login("admin")
+
+	for pattern, want := range map[string]string{"my  notes*": "; my  notes.txt", "my notes*": "; my notes.txt"} {
+		got := c.dir(pattern)
+		if len(got) != 1 || !strings.HasSuffix(got[0], want) {
+			t.Errorf("DIR %s: got %q", pattern, got)
+		}
+	}
+	if got := c.dir("-S -r *.txt"); len(got) != 3 || !strings.HasSuffix(got[0], "; other.txt") {
+		t.Errorf("DIR -S -r: got %q", got)
+	}
+}
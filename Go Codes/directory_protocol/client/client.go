@@ -2,11 +2,12 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"net"
 	"os"
+	"strconv"
 	"strings"
-	"time"
 )
 
 func main() {
@@ -18,28 +19,99 @@ func main() {
 	reader := bufio.NewReader(os.Stdin) // Kullanıcıdan terminal girisi
 	serverReader := bufio.NewReader(conn) // Sunucudan gelen yanıtları okumak icin
 
+	// Sunucu surekli okunur ki watch olaylari komut beklemeden basilsin;
+	// yanitlar sirayla kanala gider
+	responses := make(chan response)
+	go func() {
+		for {
+			lines, err := readResponse(serverReader)
+			responses <- response{lines, err}
+			if errors.Is(err, errConnection) {
+				return
+			}
+		}
+	}()
+
 	for {
 		fmt.Print("> ")
-		cmd, _ := reader.ReadString('\n')
+		cmd, err := reader.ReadString('\n')
 		cmd = strings.TrimSpace(cmd)
+		if err != nil && cmd == "" {
+			cmd = "quit" // girdi bitti
+		}
+		if cmd == "" {
+			continue
+		}
 
 		// Send command to server
 		conn.Write([]byte(cmd + "\n"))
 
+		// Read response
+		resp := <-responses
+		lines, err := resp.lines, resp.err
+		if errors.Is(err, errConnection) {
+			fmt.Println("Error:", err)
+			return
+		}
+		if err != nil {
+			fmt.Println("ERROR", err)
+			continue
+		}
+		for _, line := range lines {
+			fmt.Println(line) // her satir alinir ve yazilir
+		}
+
 		if cmd == "quit" {
 			break
 		}
+	}
+}
 
-		// Read response
-		for {
-			conn.SetReadDeadline(time.Now().Add(time.Millisecond * 200)) // dongu kirici
-			line, err := serverReader.ReadString('\n')
-			if err != nil {
-				break
-			}
-			fmt.Print(line) // her satir alinir ve yazilir
+// errConnection sunucuyla baglanti koptugunda doner
+var errConnection = errors.New("connection lost")
+
+// response sunucudan okunan bir yanittir
+type response struct {
+	lines []string
+	err   error
+}
+
+// readResponse bir yaniti okur: "OK <n>" basligi ve n satir ya da tek
+// satirlik "ERROR <mesaj>". Sonu satir sayisindan bilinir. Arada gelen
+// "EVENT" satirlari (watch) hemen basilir.
+func readResponse(r *bufio.Reader) ([]string, error) {
+	var header string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errConnection, err)
+		}
+		header = strings.TrimRight(line, "\r\n")
+		if event, ok := strings.CutPrefix(header, "EVENT "); ok {
+			fmt.Println("\n[watch]", event)
+			continue
+		}
+		break
+	}
+
+	if msg, ok := strings.CutPrefix(header, "ERROR "); ok {
+		return nil, errors.New(msg)
+	}
+	count, ok := strings.CutPrefix(header, "OK ")
+	n, err := strconv.Atoi(count)
+	if !ok || err != nil || n < 0 {
+		return nil, fmt.Errorf("%w: unexpected reply %q", errConnection, header)
+	}
+
+	lines := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errConnection, err)
 		}
+		lines = append(lines, strings.TrimRight(line, "\r\n"))
 	}
+	return lines, nil
 }
 
 func checkError(err error) {
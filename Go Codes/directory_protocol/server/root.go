@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Export root: sunucu sadece -root ile verilen dizini paylasir. Client
+// gercek yollari hic gormez; oturumun cwd'si "/" ile baslayan sanal bir
+// yoldur ve "/" export root'un kendisidir.
+//
+// Yollar once resolve ile metin olarak kontrol edilir (".." ile root'un
+// ustune cikmak reddedilir), dosyalara da *os.Root uzerinden erisilir,
+// boylece root icinde disari isaret eden sembolik linkler de isletim
+// sistemi seviyesinde reddedilir.
+
+// errOutsideRoot export root disina cikan yollar icin doner
+var errOutsideRoot = errors.New("path escapes the export root")
+
+// exportRoot paylasilan dizindir
+type exportRoot struct {
+	root *os.Root
+	dir  string // gercek dizin; inotify gercek yol ister
+}
+
+// openExport dir'i export root olarak acar
+func openExport(dir string) (*exportRoot, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	root, err := os.OpenRoot(abs)
+	if err != nil {
+		return nil, err
+	}
+	return &exportRoot{root: root, dir: abs}, nil
+}
+
+// resolve client'in verdigi yolu sanal mutlak yola ("/a/b") ve os.Root
+// ile kullanilan goreli ada ("a/b", root icin ".") cevirir. "/" ile
+// baslayan yollar root'tan, digerleri cwd'den baslar. Root'un ustune
+// cikan yol sessizce kirpilmaz, reddedilir.
+func resolve(cwd, p string) (virtual, rel string, err error) {
+	p = strings.ReplaceAll(p, "\\", "/")
+
+	// Root'a goreli birlestirilir ki path.Clean fazla ".."lari yutmasin
+	rel = strings.TrimLeft(p, "/")
+	if !path.IsAbs(p) {
+		rel = path.Join(strings.TrimLeft(cwd, "/"), p)
+	}
+	rel = path.Clean(rel)
+
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", "", errOutsideRoot
+	}
+	return path.Clean("/" + rel), rel, nil
+}
+
+// rootError os.Root'un kacis hatasini errOutsideRoot'a cevirir ve gercek
+// yollari iceren hata metinlerini gizler
+func rootError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case strings.Contains(err.Error(), "escapes from parent"):
+		return errOutsideRoot
+	case errors.Is(err, fs.ErrNotExist):
+		return errors.New("not found")
+	case errors.Is(err, fs.ErrPermission):
+		return errors.New("permission denied")
+	}
+	return errors.New("cannot access path")
+}
+
+// openDir yolu cozer ve root icinde var olan bir dizin oldugunu dogrular
+func (e *exportRoot) openDir(cwd, p string) (virtual, rel string, err error) {
+	virtual, rel, err = resolve(cwd, p)
+	if err != nil {
+		return "", "", err
+	}
+
+	info, err := e.root.Stat(rel)
+	switch {
+	case errors.Is(rootError(err), errOutsideRoot):
+		return "", "", errOutsideRoot
+	case err != nil:
+		return "", "", errors.New("directory not found")
+	case !info.IsDir():
+		return "", "", errors.New("not a directory")
+	}
+	return virtual, rel, nil
+}
+
+// realPath goreli adin diskteki yoludur
+func (e *exportRoot) realPath(rel string) string {
+	return filepath.Join(e.dir, filepath.FromSlash(rel))
+}
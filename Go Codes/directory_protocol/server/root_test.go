@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// go.mod olmadigi icin testler GOPATH kipinde calisir; boylece
+// watch_linux.go / watch_other.go ayrimi da korunur:
+//
+//	GO111MODULE=off go test .
+
+func TestResolve(t *testing.T) {
+	for _, tc := range []struct {
+		cwd, p       string
+		virtual, rel string // ikisi de bossa yol reddedilmeli
+	}{
+		{"/", "a", "/a", "a"},
+		{"/", ".", "/", "."},
+		{"/", "", "/", "."},
+		{"/a", "b/c", "/a/b/c", "a/b/c"},
+		{"/a/b", "..", "/a", "a"},
+		{"/a/b", "../..", "/", "."},
+		{"/a", "./b/../c", "/a/c", "a/c"},
+		{"/a", "b//c/", "/a/b/c", "a/b/c"},
+		{"/a", `b\c`, "/a/b/c", "a/b/c"},
+
+		// "/" ile baslayan yol root'tan baslar, diskin kokunden degil
+		{"/a", "/b", "/b", "b"},
+		{"/a", "/etc/passwd", "/etc/passwd", "etc/passwd"},
+		{"/a", `\b`, "/b", "b"},
+		{"/a", "//b", "/b", "b"},
+
+		// root'un ustune cikan yollar kirpilmaz, reddedilir
+		{"/", "..", "", ""},
+		{"/", "../a", "", ""},
+		{"/a", "../..", "", ""},
+		{"/a/b", "../../../etc", "", ""},
+		{"/a", "b/../../../c", "", ""},
+		{"/", "/..", "", ""},
+		{"/", "/../etc/passwd", "", ""},
+		{"/a", `..\..\etc`, "", ""},
+	} {
+		virtual, rel, err := resolve(tc.cwd, tc.p)
+		if tc.virtual == "" {
+			if !errors.Is(err, errOutsideRoot) {
+				t.Errorf("resolve(%q, %q) = %q, %q, %v; reddedilmeli", tc.cwd, tc.p, virtual, rel, err)
+			}
+			continue
+		}
+		if err != nil || virtual != tc.virtual || rel != tc.rel {
+			t.Errorf("resolve(%q, %q) = %q, %q, %v; beklenen %q, %q", tc.cwd, tc.p, virtual, rel, err, tc.virtual, tc.rel)
+		}
+	}
+}
+
+// TestOpenDir gecici bir export root kurar; icinde root disina ve root
+// icine isaret eden sembolik linkler vardir.
+func TestOpenDir(t *testing.T) {
+	base := t.TempDir()
+	dir := filepath.Join(base, "export")
+	outside := filepath.Join(base, "outside")
+	for _, d := range []string{dir, outside, filepath.Join(dir, "a", "b")} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "a", "f.txt"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for link, target := range map[string]string{
+		"out":     outside,                   // mutlak, disari
+		"a/up":    filepath.Join("..", ".."), // goreli, disari
+		"a/inb":   "b",                       // goreli, iceride
+		"a/tof":   "f.txt",                   // dosyaya
+		"a/loose": "yok",                     // hedefi yok
+	} {
+		if err := os.Symlink(target, filepath.Join(dir, link)); err != nil {
+			t.Skipf("sembolik link olusturulamadi: %v", err)
+		}
+	}
+
+	export, err := openExport(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errNotDir := errors.New("not a directory")
+	errNotFound := errors.New("directory not found")
+	for _, tc := range []struct {
+		cwd, p  string
+		virtual string // hata beklenmiyorsa
+		err     error
+	}{
+		{"/", "a", "/a", nil},
+		{"/", "a/b", "/a/b", nil},
+		{"/a/b", "..", "/a", nil},
+		{"/a", "/", "/", nil},
+		{"/", "a/inb", "/a/inb", nil},
+		{"/", "a/f.txt", "", errNotDir},
+		{"/", "a/tof", "", errNotDir},
+		{"/", "yok", "", errNotFound},
+		{"/", "a/loose", "", errNotFound},
+		{"/", "..", "", errOutsideRoot},
+		{"/a", "../../outside", "", errOutsideRoot},
+		{"/", "out", "", errOutsideRoot},
+		{"/", "out/..", "/", nil}, // metin olarak cozulur, link izlenmez
+		{"/", "a/up", "", errOutsideRoot},
+		{"/", "a/up/outside", "", errOutsideRoot},
+	} {
+		virtual, _, err := export.openDir(tc.cwd, tc.p)
+		switch {
+		case tc.err == nil && (err != nil || virtual != tc.virtual):
+			t.Errorf("openDir(%q, %q) = %q, %v; beklenen %q", tc.cwd, tc.p, virtual, err, tc.virtual)
+		case tc.err != nil && (err == nil || err.Error() != tc.err.Error()):
+			t.Errorf("openDir(%q, %q) = %q, %v; beklenen hata %q", tc.cwd, tc.p, virtual, err, tc.err)
+		}
+	}
+
+	// Disari isaret eden linkten dosya da okunamaz
+	if _, err := export.root.Open("out/x"); !errors.Is(rootError(err), errOutsideRoot) {
+		t.Errorf("link uzerinden disari acildi: %v", err)
+	}
+}
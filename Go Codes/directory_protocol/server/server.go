@@ -2,7 +2,9 @@ package main
 
 import (
 	"bufio"
+	"flag"
 	"fmt"
+	"io/fs"
 	"net"
 	"os"
 	"path/filepath"
@@ -10,22 +12,37 @@ import (
 )
 
 func main() {
+	rootDir := flag.String("root", ".", "export root; clients cannot leave this directory")
+	flag.Parse()
+
+	export, err := openExport(*rootDir)
+	checkError(err)
+
 	listener, err := net.Listen("tcp", ":8080")
 	checkError(err)
-	fmt.Println("Server started on port 8080")
+	fmt.Println("Server started on port 8080, exporting", export.dir)
 
 	for {
 		conn, err := listener.Accept() // Her client baglantısı bagımsız conn nesnesidir
 		if err != nil {
 			continue
 		}
-		go handleClient(conn) // concurrent saglanir
+		go handleClient(conn, export) // concurrent saglanir
 	}
 }
 
-func handleClient(conn net.Conn) {
+func handleClient(raw net.Conn, export *exportRoot) {
+	conn := &syncConn{Conn: raw} // yanitlar ve watch olaylari ayni baglantiya yazilir
 	defer conn.Close()
-	cwd, _ := os.Getwd()
+
+	watches := make(map[string]watch) // izlenen dizin (root'a goreli) -> izleme
+	defer func() {
+		for _, w := range watches {
+			w.stop()
+		}
+	}()
+
+	cwd := "/" // sanal yol, "/" export root'tur (bkz. root.go)
 	reader := bufio.NewReader(conn) // Tcp'de satır satır veri okumak icin buffer saglanir
 
 	for {
@@ -40,41 +57,141 @@ func handleClient(conn net.Conn) {
 
 		switch cmd {
 		case "dir":
-			files, err := os.ReadDir(cwd) // Mevcut dzindeki dosyalar
+			_, rel, _ := resolve(cwd, ".")
+			files, err := fs.ReadDir(export.root.FS(), rel) // Mevcut dzindeki dosyalar
 			if err != nil {
-				conn.Write([]byte("ERROR reading directory\n")) 
+				replyError(conn, "reading directory")
 				continue
 			}
+			var names []string
 			for _, f := range files {
-				conn.Write([]byte(f.Name() + "\n")) // Her dosya ismini client'a gonderir
+				names = append(names, f.Name()) // Her dosya ismi bir satir olur
 			}
+			reply(conn, names...)
 
 		case "cd":
 			if len(parts) < 2 {
-				conn.Write([]byte("ERROR no directory specified\n"))
+				replyError(conn, "no directory specified")
 				continue
 			}
-			newDir := filepath.Join(cwd, parts[1])
-			if _, err := os.Stat(newDir); os.IsNotExist(err) { // dizin var mı ? 
-				conn.Write([]byte("ERROR directory not found\n"))
+			newDir, _, err := export.openDir(cwd, strings.TrimSpace(parts[1])) // dizin var mı, root icinde mi ?
+			if err != nil {
+				replyError(conn, err.Error())
 			} else {
 				cwd = newDir
-				conn.Write([]byte("OK changed to " + cwd + "\n")) 
+				reply(conn, "changed to "+cwd)
+			}
+
+		case "tree", "find", "stat", "du":
+			arg := ""
+			if len(parts) == 2 {
+				arg = strings.TrimSpace(parts[1])
+			}
+			var lines []string
+			switch cmd {
+			case "tree":
+				lines, err = treeCommand(export, cwd, arg)
+			case "find":
+				lines, err = findCommand(export, cwd, arg)
+			case "stat":
+				lines, err = statCommand(export, cwd, arg)
+			case "du":
+				lines, err = duCommand(export, cwd, arg)
+			}
+			if err != nil {
+				replyError(conn, err.Error())
+				continue
+			}
+			reply(conn, lines...)
+
+		case "watch":
+			if len(parts) < 2 {
+				replyError(conn, "no directory specified")
+				continue
+			}
+			name := strings.TrimSpace(parts[1])
+			_, dir, err := export.openDir(cwd, name)
+			if err != nil {
+				replyError(conn, err.Error())
+				continue
+			}
+			if _, ok := watches[dir]; ok {
+				replyError(conn, "already watching "+name)
+				continue
+			}
+			stop, method, err := watchDir(export.realPath(dir), func(kind, entry string) {
+				conn.Write([]byte("EVENT " + kind + " " + escapeLine(filepath.Join(name, entry)) + "\n"))
+			})
+			if err != nil {
+				replyError(conn, "cannot watch "+name)
+				continue
 			}
+			watches[dir] = watch{name: name, stop: stop}
+			reply(conn, "watching "+name+" ("+method+")")
+
+		case "unwatch":
+			// Parametresiz unwatch butun izlemeleri bitirir
+			var only string
+			if len(parts) == 2 {
+				_, only, _ = resolve(cwd, strings.TrimSpace(parts[1]))
+			}
+			var stopped []string
+			for dir, w := range watches {
+				if len(parts) == 2 && dir != only {
+					continue
+				}
+				w.stop()
+				delete(watches, dir)
+				stopped = append(stopped, "stopped watching "+w.name)
+			}
+			if len(stopped) == 0 {
+				replyError(conn, "not watching")
+				continue
+			}
+			reply(conn, stopped...)
 
 		case "pwd":
-			conn.Write([]byte("Current directory: " + cwd + "\n"))
+			reply(conn, "Current directory: "+cwd)
 
 		case "quit":
-			conn.Write([]byte("Bye!\n"))
+			reply(conn, "Bye!")
 			return
 
 		default:
-			conn.Write([]byte("Unknown command\n"))
+			replyError(conn, "Unknown command")
 		}
 	}
 }
 
+// Yanıt formati: her komut tam olarak bir yanıt alir
+//
+//	OK <n>\n          ardindan tam n satir veri
+//	ERROR <mesaj>\n   veri satiri yok
+//
+// Client satir sayisina bakarak yanitin nerede bittigini bilir, zaman
+// asimina gerek kalmaz. Yanitlarin arasinda watch olaylari da gelebilir
+// ("EVENT ..." satirlari, bkz. watch.go).
+
+// reply basarili bir yaniti tek Write ile gonderir
+func reply(conn net.Conn, lines ...string) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "OK %d\n", len(lines))
+	for _, line := range lines {
+		b.WriteString(escapeLine(line) + "\n")
+	}
+	conn.Write([]byte(b.String()))
+}
+
+// escapeLine satir icindeki '\n'i kacirir, satir sayimi bozulmasin diye
+func escapeLine(line string) string {
+	return strings.ReplaceAll(line, "\n", `\n`)
+}
+
+// replyError hata yanitini gonderir
+func replyError(conn net.Conn, msg string) {
+	conn.Write([]byte("ERROR " + msg + "\n"))
+}
+
 func checkError(err error) {
 	if err != nil {
 		fmt.Println("Error:", err)
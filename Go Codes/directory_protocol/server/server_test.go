@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// session dir'i export eden bir sunucuya loopback uzerinden baglanir
+type session struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// startSession dir'i export eder ve bir client baglantisi acar
+func startSession(t *testing.T, dir string) *session {
+	t.Helper()
+	export, err := openExport(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go handleClient(conn, export)
+		}
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	t.Cleanup(func() { conn.Close() })
+	return &session{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+// readLine bir satir okur, sondaki '\n' olmadan
+func (s *session) readLine() string {
+	s.t.Helper()
+	line, err := s.r.ReadString('\n')
+	if err != nil {
+		s.t.Fatalf("okuma: %v", err)
+	}
+	return strings.TrimSuffix(line, "\n")
+}
+
+// send komutu gonderir ve yanitini okur. OK yanitinda satirlari, ERROR
+// yanitinda mesaji doner. Yanittan once gelen EVENT satirlari events'e
+// eklenir.
+func (s *session) send(cmd string) (lines []string, errMsg string, events []string) {
+	s.t.Helper()
+	if _, err := s.conn.Write([]byte(cmd + "\n")); err != nil {
+		s.t.Fatal(err)
+	}
+	return s.readReply(cmd)
+}
+
+// readReply cmd'nin yanitini okur, bkz. send
+func (s *session) readReply(cmd string) (lines []string, errMsg string, events []string) {
+	s.t.Helper()
+	for {
+		head := s.readLine()
+		switch {
+		case strings.HasPrefix(head, "EVENT "):
+			events = append(events, head)
+		case strings.HasPrefix(head, "ERROR "):
+			return nil, strings.TrimPrefix(head, "ERROR "), events
+		case strings.HasPrefix(head, "OK "):
+			n, err := strconv.Atoi(strings.TrimPrefix(head, "OK "))
+			if err != nil || n < 0 {
+				s.t.Fatalf("%s: bozuk yanit basligi %q", cmd, head)
+			}
+			lines = []string{}
+			for range n {
+				lines = append(lines, s.readLine())
+			}
+			return lines, "", events
+		default:
+			s.t.Fatalf("%s: beklenmeyen satir %q", cmd, head)
+		}
+	}
+}
+
+// ok komutun basarili oldugunu kontrol eder ve satirlarini doner
+func (s *session) ok(cmd string) []string {
+	s.t.Helper()
+	lines, errMsg, _ := s.send(cmd)
+	if lines == nil {
+		s.t.Fatalf("%s: ERROR %s", cmd, errMsg)
+	}
+	return lines
+}
+
+// TestWatchSession watch/unwatch'u protokol uzerinden dener: olay EVENT
+// satiri olarak gelir, unwatch yanitindan sonra olay gelmez
+func TestWatchSession(t *testing.T) {
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "in"), 0o755)
+	s := startSession(t, dir)
+
+	if got := s.ok("watch in"); len(got) != 1 || !strings.HasPrefix(got[0], "watching in (") {
+		t.Fatalf("watch yaniti %q", got)
+	}
+	if _, errMsg, _ := s.send("watch in"); errMsg != "already watching in" {
+		t.Errorf("ikinci watch: %q", errMsg)
+	}
+
+	os.WriteFile(filepath.Join(dir, "in", "report.txt"), []byte("x"), 0o644)
+	if line := s.readLine(); line != "EVENT create in/report.txt" {
+		t.Errorf("olay satiri %q", line)
+	}
+
+	// Komutlar olay beklerken de calisir; araya giren olaylar ayrilir
+	var events []string
+	for i := 0; ; i++ {
+		lines, _, evs := s.send("unwatch in")
+		events = append(events, evs...)
+		if lines != nil {
+			if len(lines) != 1 || lines[0] != "stopped watching in" {
+				t.Errorf("unwatch yaniti %q", lines)
+			}
+			break
+		}
+	}
+	for _, ev := range events {
+		if !strings.HasPrefix(ev, "EVENT ") {
+			t.Errorf("bozuk olay %q", ev)
+		}
+	}
+
+	os.WriteFile(filepath.Join(dir, "in", "late.txt"), nil, 0o644)
+	time.Sleep(2 * pollInterval)
+	if got := s.ok("pwd"); len(got) != 1 || got[0] != "Current directory: /" {
+		t.Errorf("pwd yaniti %q", got)
+	}
+	if _, errMsg, _ := s.send("unwatch"); errMsg != "not watching" {
+		t.Errorf("bos unwatch: %q", errMsg)
+	}
+}
+
+// TestReplyFraming her komutun tek bir yanit aldigini ve "OK n"deki
+// sayinin ardindan gelen satir sayisina esit oldugunu kontrol eder: bos
+// dizinde, hata yanitlarinda, adinda '\n' olan dosyada ve arka arkaya
+// tek seferde gonderilen komutlarda.
+func TestReplyFraming(t *testing.T) {
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "empty"), 0o755)
+	os.WriteFile(filepath.Join(dir, "a.txt"), nil, 0o644)
+	newline := runtime.GOOS != "windows" && os.WriteFile(filepath.Join(dir, "two\nlines"), nil, 0o644) == nil
+	s := startSession(t, dir)
+
+	want := []string{"a.txt", "empty"}
+	if newline {
+		want = append(want, `two\nlines`)
+	}
+	if got := s.ok("dir"); !slices.Equal(got, want) {
+		t.Errorf("dir = %q, beklenen %q", got, want)
+	}
+	if newline {
+		if got := s.ok("find two*"); !slices.Equal(got, []string{`two\nlines`}) {
+			t.Errorf("find = %q", got)
+		}
+	}
+
+	for _, tc := range []struct{ cmd, err string }{
+		{"cd nope", "directory not found"},
+		{"cd a.txt", "not a directory"},
+		{"cd ..", errOutsideRoot.Error()},
+		{"cd", "no directory specified"},
+		{"find", "no pattern specified"},
+		{"stat ../x", errOutsideRoot.Error()},
+		{"tree x", `invalid depth "x"`},
+		{"bogus", "Unknown command"},
+		{"", "Unknown command"},
+	} {
+		if lines, errMsg, _ := s.send(tc.cmd); lines != nil || errMsg != tc.err {
+			t.Errorf("%q: %q, %q; beklenen ERROR %s", tc.cmd, lines, errMsg, tc.err)
+		}
+	}
+
+	s.ok("cd empty")
+	for _, cmd := range []string{"dir", "find *", "du"} {
+		if got := s.ok(cmd); len(got) != 0 && !(cmd == "du" && slices.Equal(got, []string{"0\ttotal"})) {
+			t.Errorf("bos dizinde %s = %q", cmd, got)
+		}
+	}
+	if got := s.ok("tree"); !slices.Equal(got, []string{"."}) {
+		t.Errorf("bos dizinde tree = %q", got)
+	}
+
+	// Tek Write'ta gelen komutlar sirayla, tek tek yanitlanir
+	if _, err := s.conn.Write([]byte("pwd\ndir\nbogus\ncd /\nstat a.txt\npwd\n")); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		cmd   string
+		lines int // -1: ERROR
+	}{{"pwd", 1}, {"dir", 0}, {"bogus", -1}, {"cd /", 1}, {"stat a.txt", 5}, {"pwd", 1}} {
+		lines, errMsg, _ := s.readReply(tc.cmd)
+		if (tc.lines < 0) != (lines == nil) || (lines != nil && len(lines) != tc.lines) {
+			t.Errorf("%s: %q, %q", tc.cmd, lines, errMsg)
+		}
+	}
+
+	if got := s.ok("quit"); !slices.Equal(got, []string{"Bye!"}) {
+		t.Errorf("quit = %q", got)
+	}
+	if line, err := s.r.ReadString('\n'); err == nil {
+		t.Errorf("quit'ten sonra %q", line)
+	}
+}
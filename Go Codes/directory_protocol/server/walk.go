@@ -0,0 +1,234 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Agac komutlari: tree, find, stat ve du. Hepsi oturumun cwd'sinden
+// baslar ve export root'un fs.FS'i uzerinden gezer, root disina cikmaz.
+// Buyuk arsivlerde yanitin ve isin sinirli kalmasi icin derinlik, yanit
+// satiri ve gezilen kayit sayisi limitlidir; limite takilan yanit son
+// satirinda bunu soyler.
+
+const (
+	defaultTreeDepth = 3      // tree icin varsayilan derinlik
+	maxDepth         = 16     // hicbir gezinti bundan derine inmez
+	maxEntries       = 1000   // bir yanittaki en fazla kayit satiri
+	maxVisited       = 100000 // bir komutun gezebilecegi en fazla kayit
+)
+
+// errLimit gezintiyi limitte durdurmak icin kullanilir
+var errLimit = errors.New("limit reached")
+
+// walk fsys icinde start altini en fazla depth seviye gezer. visit her
+// kayit icin start'a gore goreli yol ile cagrilir (start'in kendisi
+// haric) ve errLimit donerek gezintiyi bitirebilir. Limite takilirsa
+// truncated true olur. Sembolik linkler izlenmez.
+func walk(fsys fs.FS, start string, depth int, visit func(rel string, d fs.DirEntry) error) (truncated bool, err error) {
+	visited := 0
+	err = fs.WalkDir(fsys, start, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == start {
+				return rootError(err)
+			}
+			return nil // okunamayan alt dizin atlanir
+		}
+		if p == start {
+			return nil
+		}
+
+		visited++
+		if visited > maxVisited {
+			return errLimit
+		}
+
+		rel := p
+		if start != "." {
+			rel = strings.TrimPrefix(p, start+"/")
+		}
+		if err := visit(rel, d); err != nil {
+			return err
+		}
+		if d.IsDir() && strings.Count(rel, "/")+1 >= depth {
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if errors.Is(err, errLimit) {
+		return true, nil
+	}
+	return false, err
+}
+
+// truncatedLine limite takilan yanitin son satiridir
+func truncatedLine(lines []string) string {
+	return fmt.Sprintf("... truncated after %d entries", len(lines))
+}
+
+// treeCommand "tree [depth]" yanitini hazirlar
+func treeCommand(e *exportRoot, cwd, arg string) ([]string, error) {
+	depth := defaultTreeDepth
+	if arg != "" {
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid depth %q", arg)
+		}
+		depth = min(n, maxDepth)
+	}
+
+	lines := []string{"."}
+	_, start, err := resolve(cwd, ".")
+	if err != nil {
+		return nil, err
+	}
+	truncated, err := walk(e.root.FS(), start, depth, func(rel string, d fs.DirEntry) error {
+		if len(lines) > maxEntries {
+			return errLimit
+		}
+		indent := strings.Repeat("  ", strings.Count(rel, "/")+1)
+		name := d.Name()
+		if d.IsDir() {
+			name += "/"
+		}
+		lines = append(lines, indent+name)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if truncated {
+		lines = append(lines, truncatedLine(lines[1:]))
+	}
+	return lines, nil
+}
+
+// findCommand "find <glob>" yanitini hazirlar. Desen dosya adina
+// uygulanir ("*.conf"), sonuc cwd'ye gore goreli yoldur.
+func findCommand(e *exportRoot, cwd, pattern string) ([]string, error) {
+	if pattern == "" {
+		return nil, errors.New("no pattern specified")
+	}
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid pattern %q", pattern)
+	}
+
+	_, start, err := resolve(cwd, ".")
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	truncated, err := walk(e.root.FS(), start, maxDepth, func(rel string, d fs.DirEntry) error {
+		if ok, _ := filepath.Match(pattern, d.Name()); !ok {
+			return nil
+		}
+		if len(lines) == maxEntries {
+			return errLimit
+		}
+		lines = append(lines, rel)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if truncated {
+		lines = append(lines, truncatedLine(lines))
+	}
+	return lines, nil
+}
+
+// statCommand "stat <path>" yanitini hazirlar. Sembolik linkler
+// izlenmez, link olarak gosterilir.
+func statCommand(e *exportRoot, cwd, arg string) ([]string, error) {
+	if arg == "" {
+		return nil, errors.New("no path specified")
+	}
+	_, rel, err := resolve(cwd, arg)
+	if err != nil {
+		return nil, err
+	}
+	info, err := e.root.Lstat(rel)
+	if err != nil {
+		return nil, rootError(err)
+	}
+
+	kind := "file"
+	switch {
+	case info.IsDir():
+		kind = "dir"
+	case info.Mode()&fs.ModeSymlink != 0:
+		kind = "symlink"
+	case !info.Mode().IsRegular():
+		kind = "other"
+	}
+	return []string{
+		"name: " + info.Name(),
+		"type: " + kind,
+		"size: " + strconv.FormatInt(info.Size(), 10),
+		"mode: " + info.Mode().String(),
+		"modified: " + info.ModTime().Format(time.RFC3339),
+	}, nil
+}
+
+// duCommand "du [path]" yanitini hazirlar: path altindaki her kaydin ve
+// toplamin bayt cinsinden boyutu.
+func duCommand(e *exportRoot, cwd, arg string) ([]string, error) {
+	_, start, err := resolve(cwd, arg)
+	if err != nil {
+		return nil, err
+	}
+	info, err := e.root.Lstat(start)
+	if err != nil {
+		return nil, rootError(err)
+	}
+	if !info.IsDir() {
+		return []string{fmt.Sprintf("%d\t%s", info.Size(), info.Name())}, nil
+	}
+
+	sizes := make(map[string]int64) // ilk seviye kayit -> toplam boyut
+	var total int64
+	truncated, err := walk(e.root.FS(), start, maxDepth, func(rel string, d fs.DirEntry) error {
+		// Alt kayitlar ilk seviyedeki dizinlerinin toplamina eklenir
+		top, _, nested := strings.Cut(rel, "/")
+		if nested || d.IsDir() {
+			top += "/"
+		}
+		sizes[top] += 0
+		if d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				sizes[top] += info.Size()
+				total += info.Size()
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(sizes))
+	for name := range sizes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) > maxEntries {
+		names = names[:maxEntries]
+		truncated = true
+	}
+
+	var lines []string
+	for _, name := range names {
+		lines = append(lines, fmt.Sprintf("%d\t%s", sizes[name], name))
+	}
+	lines = append(lines, fmt.Sprintf("%d\ttotal", total))
+	if truncated {
+		lines = append(lines, "... partial: stopped at the entry limit")
+	}
+	return lines, nil
+}
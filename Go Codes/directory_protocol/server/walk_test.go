@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// makeExport files'taki dosyalari (yol -> icerik) ve links'teki sembolik
+// linkleri (yol -> hedef) dir altinda olusturur ve dir'i export eder
+func makeExport(t *testing.T, dir string, files, links map[string]string) *exportRoot {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if strings.HasSuffix(name, "/") {
+			if err := os.MkdirAll(p, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, filepath.Join(dir, filepath.FromSlash(link))); err != nil {
+			t.Skipf("sembolik link olusturulamadi: %v", err)
+		}
+	}
+	export, err := openExport(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return export
+}
+
+// smallExport disari isaret eden linkleri olan kucuk bir agac kurar
+func smallExport(t *testing.T) *exportRoot {
+	base := t.TempDir()
+	outside := filepath.Join(base, "outside")
+	makeExport(t, outside, map[string]string{"secret.conf": "secret"}, nil)
+	return makeExport(t, filepath.Join(base, "export"), map[string]string{
+		"top.conf":         "top",
+		"a/x.conf":         "xx",
+		"a/b/c/d/deep.txt": "deep",
+		"empty/":           "",
+	}, map[string]string{
+		"out":  outside,                   // mutlak, disari
+		"a/up": filepath.Join("..", ".."), // goreli, disari
+	})
+}
+
+// bigExport limitleri denemek icin maxEntries'ten fazla dosyasi olan bir
+// dizin ve maxDepth'ten derin bir dizin zinciri kurar
+func bigExport(t *testing.T) *exportRoot {
+	files := make(map[string]string)
+	for i := range maxEntries + 5 {
+		files[fmt.Sprintf("big/f%04d", i)] = "x"
+	}
+	chain := "deep"
+	for i := 1; i <= maxDepth+4; i++ {
+		chain += fmt.Sprintf("/l%d", i)
+	}
+	files[chain+"/"] = ""
+	return makeExport(t, t.TempDir(), files, nil)
+}
+
+// check bir komut sonucunu beklenenle karsilastirir. wantErr bos degilse
+// hata mesaji onu icermelidir.
+func check(t *testing.T, cmd string, got []string, err error, want []string, wantErr string) {
+	t.Helper()
+	switch {
+	case wantErr != "":
+		if err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("%s: %q, %v; beklenen hata %q", cmd, got, err, wantErr)
+		}
+	case err != nil:
+		t.Errorf("%s: %v", cmd, err)
+	case !slices.Equal(got, want):
+		t.Errorf("%s:\n got %q\nwant %q", cmd, got, want)
+	}
+}
+
+func TestTree(t *testing.T) {
+	small := smallExport(t)
+	for _, tc := range []struct {
+		cwd, arg string
+		want     []string
+		err      string
+	}{
+		{"/", "1", []string{".", "  a/", "  empty/", "  out", "  top.conf"}, ""},
+		{"/", "2", []string{".", "  a/", "    b/", "    up", "    x.conf", "  empty/", "  out", "  top.conf"}, ""},
+		{"/a/b", "", []string{".", "  c/", "    d/", "      deep.txt"}, ""},
+		{"/empty", "", []string{"."}, ""},
+		{"/", "0", nil, "invalid depth"},
+		{"/", "-1", nil, "invalid depth"},
+		{"/", "x", nil, "invalid depth"},
+	} {
+		got, err := treeCommand(small, tc.cwd, tc.arg)
+		check(t, fmt.Sprintf("tree %s (%s)", tc.arg, tc.cwd), got, err, tc.want, tc.err)
+	}
+
+	big := bigExport(t)
+
+	// Derinlik maxDepth ile sinirlanir
+	got, err := treeCommand(big, "/deep", "50")
+	if err != nil || len(got) != maxDepth+1 {
+		t.Fatalf("tree 50: %d satir, %v; beklenen %d", len(got), err, maxDepth+1)
+	}
+	if last := got[maxDepth]; last != strings.Repeat("  ", maxDepth)+fmt.Sprintf("l%d/", maxDepth) {
+		t.Errorf("tree 50 son satir %q", last)
+	}
+
+	// Kayit limiti: "." + maxEntries kayit + kesildi satiri
+	got, err = treeCommand(big, "/big", "")
+	if err != nil || len(got) != maxEntries+2 {
+		t.Fatalf("tree /big: %d satir, %v", len(got), err)
+	}
+	if last := got[len(got)-1]; last != fmt.Sprintf("... truncated after %d entries", maxEntries) {
+		t.Errorf("tree /big son satir %q", last)
+	}
+}
+
+func TestFind(t *testing.T) {
+	small := smallExport(t)
+	for _, tc := range []struct {
+		cwd, pattern string
+		want         []string
+		err          string
+	}{
+		// out ve a/up izlenmez, disaridaki secret.conf bulunmaz
+		{"/", "*.conf", []string{"a/x.conf", "top.conf"}, ""},
+		{"/a", "*.conf", []string{"x.conf"}, ""},
+		{"/", "deep.txt", []string{"a/b/c/d/deep.txt"}, ""},
+		{"/", "up", []string{"a/up"}, ""},
+		{"/", "secret*", nil, ""},
+		{"/", "", nil, "no pattern"},
+		{"/", "[", nil, "invalid pattern"},
+	} {
+		got, err := findCommand(small, tc.cwd, tc.pattern)
+		check(t, fmt.Sprintf("find %s (%s)", tc.pattern, tc.cwd), got, err, tc.want, tc.err)
+	}
+
+	big := bigExport(t)
+	got, err := findCommand(big, "/", "f*")
+	if err != nil || len(got) != maxEntries+1 {
+		t.Fatalf("find f*: %d satir, %v", len(got), err)
+	}
+	if got[0] != "big/f0000" || got[len(got)-1] != fmt.Sprintf("... truncated after %d entries", maxEntries) {
+		t.Errorf("find f*: ilk %q, son %q", got[0], got[len(got)-1])
+	}
+	if got, err := findCommand(big, "/", fmt.Sprintf("l%d", maxDepth+1)); err != nil || len(got) != 0 {
+		t.Errorf("maxDepth'ten derin kayit bulundu: %q, %v", got, err)
+	}
+}
+
+func TestStatDu(t *testing.T) {
+	small := smallExport(t)
+	for _, tc := range []struct {
+		cmd      string
+		run      func(*exportRoot, string, string) ([]string, error)
+		cwd, arg string
+		want     []string
+		err      string
+	}{
+		{"du", duCommand, "/", "", []string{"6\ta/", "0\tempty/", "0\tout", "3\ttop.conf", "9\ttotal"}, ""},
+		{"du", duCommand, "/a", "", []string{"4\tb/", "0\tup", "2\tx.conf", "6\ttotal"}, ""},
+		{"du", duCommand, "/", "top.conf", []string{"3\ttop.conf"}, ""},
+		{"du", duCommand, "/", "..", nil, errOutsideRoot.Error()},
+		{"du", duCommand, "/", "out/secret.conf", nil, errOutsideRoot.Error()},
+		{"stat", statCommand, "/", "", nil, "no path"},
+		{"stat", statCommand, "/a", "../..", nil, errOutsideRoot.Error()},
+		{"stat", statCommand, "/", "a/up/outside", nil, errOutsideRoot.Error()},
+	} {
+		got, err := tc.run(small, tc.cwd, tc.arg)
+		check(t, fmt.Sprintf("%s %s (%s)", tc.cmd, tc.arg, tc.cwd), got, err, tc.want, tc.err)
+	}
+
+	// stat linki izlemez
+	for arg, kind := range map[string]string{"top.conf": "file", "a": "dir", "out": "symlink", "a/up": "symlink"} {
+		got, err := statCommand(small, "/", arg)
+		if err != nil || len(got) != 5 || got[1] != "type: "+kind {
+			t.Errorf("stat %s: %q, %v; beklenen tur %s", arg, got, err, kind)
+		}
+	}
+
+	// du kayit limitinde durur ama toplam tum dosyalari sayar
+	big := bigExport(t)
+	got, err := duCommand(big, "/big", "")
+	if err != nil || len(got) != maxEntries+2 {
+		t.Fatalf("du /big: %d satir, %v", len(got), err)
+	}
+	want := []string{fmt.Sprintf("%d\ttotal", maxEntries+5), "... partial: stopped at the entry limit"}
+	if !slices.Equal(got[maxEntries:], want) {
+		t.Errorf("du /big son satirlar %q, beklenen %q", got[maxEntries:], want)
+	}
+}
+
+// TestWalkErrors gezintinin baslangic hatalarini kontrol eder
+func TestWalkErrors(t *testing.T) {
+	small := smallExport(t)
+	for _, start := range []string{"yok", "out", "a/up"} {
+		if _, err := walk(small.root.FS(), start, maxDepth, func(string, os.DirEntry) error { return nil }); err == nil {
+			t.Errorf("walk(%q) hata vermedi", start)
+		}
+	}
+	var seen []string
+	truncated, err := walk(small.root.FS(), "a", 1, func(rel string, d os.DirEntry) error {
+		seen = append(seen, rel)
+		return nil
+	})
+	if err != nil || truncated || !slices.Equal(seen, []string{"b", "up", "x.conf"}) {
+		t.Errorf("walk(a, 1) = %q, %v, %v", seen, truncated, err)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// watch <path> bir dizindeki degisiklikleri client'a olay satirlari
+// olarak gonderir, unwatch ile durur:
+//
+//	EVENT create <path>/<ad>
+//	EVENT modify <path>/<ad>
+//	EVENT delete <path>/<ad>
+//
+// Olaylar herhangi bir anda gelebilir ama hicbir zaman bir yanitin
+// ortasina girmez: her yanit ve her olay tek Write ile yazilir ve
+// syncConn yazmalari sirayla yapar. Linux'ta inotify kullanilir, o
+// olmazsa dizin pollInterval'da bir taranir.
+//
+// Dosya listesiyle derlerken build etiketleri dikkate alinmaz, platforma
+// uygun dosya secilmelidir:
+//
+//	go run server.go root.go walk.go watch.go watch_linux.go   # Linux
+//	go run server.go root.go walk.go watch.go watch_other.go   # diger sistemler
+
+// pollInterval inotify olmadiginda dizinin taranma araligidir
+const pollInterval = time.Second
+
+// syncConn aynı baglantiya farkli goroutine'lerden gelen yazmalari sirayla yapar
+type syncConn struct {
+	net.Conn
+	mu sync.Mutex
+}
+
+func (c *syncConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Conn.Write(b)
+}
+
+// watch bir oturumun aktif izlemesidir
+type watch struct {
+	name string // client'in verdigi yol, olaylarda bu gorunur
+	stop func()
+}
+
+// watchDir dir'i izlemeye baslar ve her degisiklikte notify'i cagirir.
+// Donen stop izlemeyi bitirir ve izleyen goroutine cikana kadar bekler;
+// stop dondukten sonra notify bir daha cagrilmaz, boylece unwatch
+// yanitindan sonra EVENT satiri gelmez. method "inotify" ya da "polling"
+// olur.
+func watchDir(dir string, notify func(kind, name string)) (stop func(), method string, err error) {
+	if stop, err := watchNative(dir, notify); err == nil {
+		return stop, "inotify", nil
+	}
+	stop, err = watchPoll(dir, notify)
+	return stop, "polling", err
+}
+
+// fileState polling icin bir kaydin son bilinen halidir
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// snapshot dizindeki kayitlarin o anki halini okur
+func snapshot(dir string) (map[string]fileState, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	state := make(map[string]fileState, len(entries))
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			continue // okurken silindi
+		}
+		state[e.Name()] = fileState{size: info.Size(), modTime: info.ModTime()}
+	}
+	return state, nil
+}
+
+// watchPoll dizini duzenli tarayip onceki taramayla karsilastirir
+func watchPoll(dir string, notify func(kind, name string)) (func(), error) {
+	last, err := snapshot(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(chan struct{})   // stop kapatir
+	exited := make(chan struct{}) // goroutine cikinca kapanir
+	go func() {
+		defer close(exited)
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			now, err := snapshot(dir)
+			if err != nil {
+				notify("delete", ".") // izlenen dizin kayboldu
+				return
+			}
+			for name, st := range now {
+				old, ok := last[name]
+				switch {
+				case !ok:
+					notify("create", name)
+				case old != st:
+					notify("modify", name)
+				}
+			}
+			for name := range last {
+				if _, ok := now[name]; !ok {
+					notify("delete", name)
+				}
+			}
+			last = now
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+		<-exited
+	}, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// inotifyMask izlenen olaylar. Yazma olaylari dosya kapaninca bildirilir,
+// boylece buyuk bir yedek dosyasi icin tek "modify" gelir.
+const inotifyMask = syscall.IN_CREATE | syscall.IN_MOVED_TO |
+	syscall.IN_CLOSE_WRITE |
+	syscall.IN_DELETE | syscall.IN_MOVED_FROM |
+	syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+
+// watchNative dizini inotify ile izler
+func watchNative(dir string, notify func(kind, name string)) (func(), error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := syscall.InotifyAddWatch(fd, dir, inotifyMask|syscall.IN_ONLYDIR); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	// Non-blocking fd Go'nun poller'ina kaydolur; Close bekleyen Read'i bitirir
+	f := os.NewFile(uintptr(fd), "inotify")
+
+	exited := make(chan struct{}) // goroutine cikinca kapanir
+	go func() {
+		defer close(exited)
+		buf := make([]byte, 64*1024)
+		for {
+			n, err := f.Read(buf)
+			if err != nil {
+				return // stop cagrildi
+			}
+
+			for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+				ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+				nameBytes := buf[off+syscall.SizeofInotifyEvent : off+syscall.SizeofInotifyEvent+int(ev.Len)]
+				name := string(bytes.TrimRight(nameBytes, "\x00"))
+				off += syscall.SizeofInotifyEvent + int(ev.Len)
+
+				switch {
+				case ev.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0:
+					notify("create", name)
+				case ev.Mask&syscall.IN_CLOSE_WRITE != 0:
+					notify("modify", name)
+				case ev.Mask&(syscall.IN_DELETE|syscall.IN_MOVED_FROM) != 0:
+					notify("delete", name)
+				case ev.Mask&(syscall.IN_DELETE_SELF|syscall.IN_MOVE_SELF) != 0:
+					notify("delete", ".") // izlenen dizin kayboldu
+				case ev.Mask&syscall.IN_Q_OVERFLOW != 0:
+					notify("overflow", ".") // olaylar kacirildi
+				}
+			}
+		}
+	}()
+
+	// Read'den once okunmus olaylar hala bildiriliyor olabilir; stop
+	// goroutine'in cikmasini bekler
+	return func() {
+		f.Close()
+		<-exited
+	}, nil
+}
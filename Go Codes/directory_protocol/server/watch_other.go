@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// watchNative sadece Linux'ta vardir; digerlerinde polling kullanilir
+func watchNative(dir string, notify func(kind, name string)) (func(), error) {
+	return nil, errors.New("inotify not available")
+}
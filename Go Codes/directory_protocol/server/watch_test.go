@@ -0,0 +1,125 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// event bir notify cagrisidir
+type event struct{ kind, name string }
+
+// watchers test edilen iki izleme yoludur; polling her sistemde vardir
+func watchers(t *testing.T) map[string]func(string, func(kind, name string)) (func(), error) {
+	w := map[string]func(string, func(kind, name string)) (func(), error){"polling": watchPoll}
+	if runtime.GOOS == "linux" {
+		w["inotify"] = watchNative
+	}
+	return w
+}
+
+// waitEvent want gelene kadar bekler; arada gelen baska olaylari atlar
+func waitEvent(t *testing.T, events <-chan event, want event) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev := <-events:
+			if ev == want {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("%v olayi gelmedi", want)
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	for method, watchFn := range watchers(t) {
+		t.Run(method, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "old.txt"), []byte("x"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			events := make(chan event, 100)
+			stop, err := watchFn(dir, func(kind, name string) { events <- event{kind, name} })
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer stop()
+
+			if err := os.WriteFile(filepath.Join(dir, "new.txt"), []byte("a"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			waitEvent(t, events, event{"create", "new.txt"})
+
+			// Polling degisikligi boyut ya da zamandan anlar
+			if err := os.WriteFile(filepath.Join(dir, "old.txt"), []byte("longer"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			waitEvent(t, events, event{"modify", "old.txt"})
+
+			if err := os.Remove(filepath.Join(dir, "new.txt")); err != nil {
+				t.Fatal(err)
+			}
+			waitEvent(t, events, event{"delete", "new.txt"})
+
+			// stop dondukten sonra olay gelmez
+			stop()
+			os.WriteFile(filepath.Join(dir, "late.txt"), nil, 0o644)
+			select {
+			case ev := <-events:
+				if ev.name == "late.txt" {
+					t.Errorf("stop'tan sonra olay: %v", ev)
+				}
+			case <-time.After(2 * pollInterval):
+			}
+		})
+	}
+}
+
+// TestWatchStopWaits notify calisirken cagrilan stop'un notify bitene
+// kadar donmedigini kontrol eder
+func TestWatchStopWaits(t *testing.T) {
+	for method, watchFn := range watchers(t) {
+		t.Run(method, func(t *testing.T) {
+			dir := t.TempDir()
+			entered := make(chan struct{}, 10)
+			release := make(chan struct{})
+			stop, err := watchFn(dir, func(kind, name string) {
+				entered <- struct{}{}
+				<-release
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			os.WriteFile(filepath.Join(dir, "a.txt"), nil, 0o644)
+			select {
+			case <-entered:
+			case <-time.After(5 * time.Second):
+				t.Fatal("olay gelmedi")
+			}
+
+			stopped := make(chan struct{})
+			go func() {
+				stop()
+				close(stopped)
+			}()
+			select {
+			case <-stopped:
+				t.Fatal("stop, notify bitmeden dondu")
+			case <-time.After(100 * time.Millisecond):
+			}
+
+			close(release)
+			select {
+			case <-stopped:
+			case <-time.After(5 * time.Second):
+				t.Fatal("stop donmedi")
+			}
+		})
+	}
+}
@@ -4,110 +4,880 @@ package main
 
 import (
 	"bufio"
-	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"io"
 	"net"
+	"net/textproto"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const (
-	uiDir  = "dir"
-	uiCd   = "cd"
-	uiPwd  = "pwd"
-	uiQuit = "quit"
-
-	DIR = "DIR"
-	CD  = "CD"
-	PWD = "PWD"
+	uiDir    = "dir"
+	uiCd     = "cd"
+	uiPwd    = "pwd"
+	uiGet    = "get"
+	uiPut    = "put"
+	uiReget  = "reget"
+	uiReput  = "reput"
+	uiDel    = "del"
+	uiMkdir  = "mkdir"
+	uiRename = "rename"
+	uiQuit   = "quit"
+
+	MLSD = "MLSD"
+	CWD  = "CWD"
+	PWD  = "PWD"
+	RETR = "RETR"
+	STOR = "STOR"
+	ALLO = "ALLO"
+	DELE = "DELE"
+	MKD  = "MKD"
+	RNFR = "RNFR"
+	RNTO = "RNTO"
+	REST = "REST"
+	SIZE = "SIZE"
+	HASH = "HASH"
+	QUIT = "QUIT"
+	USER = "USER"
+	PASS = "PASS"
+	AUTH = "AUTH"
+	PBSZ = "PBSZ"
+	PROT = "PROT"
+	PASV = "PASV"
+	EPSV = "EPSV"
 )
 
+// FTP-style reply codes used by the server
+const (
+	StatusTransferStarting = 150
+	StatusOK               = 200
+	StatusReady            = 220
+	StatusFileStatus       = 213
+	StatusClosing          = 221
+	StatusTransferComplete = 226
+	StatusPassive          = 227
+	StatusExtendedPassive  = 229
+	StatusLoggedIn         = 230
+	StatusAuthOK           = 234
+	StatusFileActionOK     = 250
+	StatusPathCreated      = 257
+	StatusNeedPassword     = 331
+	StatusPendingInfo      = 350
+)
+
+// defaultPort is used when the address has no port
+const defaultPort = "1202"
+
+// errQuit is not an error but the signal the quit command returns to exit
+var errQuit = errors.New("quit")
+
+// showProgress turns on the progress bar in interactive mode; it is off
+// in script mode so the logs do not fill up with \r lines
+var showProgress = true
+
 func main() {
-	if len(os.Args) != 2 {
-		log.Fatalln("Usage:", os.Args[0], "host")
+	tlsMode := flag.String("tls", "off", "TLS mode: off, implicit or explicit (AUTH TLS)")
+	caFile := flag.String("cacert", "", "PEM certificate to trust (e.g. the server's self-signed cert.pem)")
+	insecure := flag.Bool("insecure", false, "skip TLS certificate verification")
+	user := flag.String("user", "", "log in as this user (password from $FTP_PASSWORD or prompted)")
+	timeout := flag.Duration("timeout", 30*time.Second, "connect timeout and maximum idle time per read or write, 0 to disable")
+	script := flag.String("script", "", "run commands from this file (\"-\" for stdin) instead of the prompt")
+	keepGoing := flag.Bool("continue", false, "in script mode, keep running after a failed command")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage:", os.Args[0], "[flags] host[:port]")
+		fmt.Fprintln(os.Stderr, "\nExit codes in script mode: 0 ok, 1 a command failed, 2 usage, 3 connection or login failed")
+		flag.PrintDefaults()
 	}
+	flag.Parse()
 
-	host := os.Args[1]
-	conn, err := net.Dial("tcp", host+":1202")
-	checkError(err)
-	fmt.Println("Connected to server:", host)
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+
+	// Add the default port when none is given; IPv6 addresses such as "[::1]" work too
+	addr := flag.Arg(0)
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = strings.Trim(addr, "[]")
+		addr = net.JoinHostPort(host, defaultPort)
+	}
 
 	reader := bufio.NewReader(os.Stdin)
 
+	// Open the script before connecting, so a wrong path never bothers the server
+	var scriptInput io.Reader = reader
+	if *script != "" && *script != "-" {
+		f, err := os.Open(*script)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Cannot open script:", err)
+			os.Exit(exitUsage)
+		}
+		defer f.Close()
+		scriptInput = f
+	}
+
+	tlsConfig := &tls.Config{ServerName: host, InsecureSkipVerify: *insecure}
+	if *caFile != "" {
+		pool := x509.NewCertPool()
+		pemData, err := os.ReadFile(*caFile)
+		checkError(err)
+		if !pool.AppendCertsFromPEM(pemData) {
+			fmt.Fprintln(os.Stderr, "No certificates in", *caFile)
+			os.Exit(exitUsage)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	conn := dial(addr, *tlsMode, tlsConfig, *timeout)
+
+	if *user != "" {
+		password, ok := os.LookupEnv("FTP_PASSWORD")
+		if !ok {
+			fmt.Print("Password: ")
+			password, err = reader.ReadString('\n')
+			checkError(err)
+		}
+		loginRequest(conn, *user, strings.TrimRight(password, "\r\n"))
+	}
+
+	if *script != "" {
+		showProgress = false
+		os.Exit(runScript(conn, *script, scriptInput, *keepGoing))
+	}
+
 	for {
 		fmt.Print("> ")
 		line, err := reader.ReadString('\n')
-		line = strings.TrimRight(line, " \t\r\n")
+		line = strings.TrimSpace(line)
 
 		if err != nil {
 			break
 		}
+		if line == "" {
+			continue
+		}
 
-		strs := strings.SplitN(line, " ", 2)
-
-		switch strs[0] {
-		case uiDir:
-			dirRequest(conn)
-		case uiCd:
-			if len(strs) != 2 {
-				fmt.Println("Usage: cd <dir>")
-				continue
-			}
-			cdRequest(conn, strs[1])
-		case uiPwd:
-			pwdRequest(conn)
-		case uiQuit:
-			conn.Close()
+		err = execute(conn, line)
+		if err == errQuit {
 			fmt.Println("Goodbye!")
 			os.Exit(0)
-		default:
-			fmt.Println("Unknown command")
+		}
+		if err != nil {
+			fmt.Println(err)
 		}
 	}
 }
 
-func dirRequest(conn net.Conn) {
-	conn.Write([]byte(DIR + " "))
-	var buf [512]byte
-	result := bytes.NewBuffer(nil)
+// execute runs one user command. Both the prompt and script mode use it;
+// every command that fails returns an error.
+//
+// For commands that take one path (cd, del, mkdir, get, put, ...) the rest
+// of the line is the path, as on the server, so paths with spaces need no
+// quotes. To give get/put a second name, or to use paths with spaces in
+// rename, put the paths in double quotes: get "lab notes.txt" notes.txt
+func execute(conn *ftpConn, line string) error {
+	verb, rest, _ := strings.Cut(line, " ")
+	rest = strings.TrimSpace(rest)
 
-	for {
-		n, _ := conn.Read(buf[0:])
-		result.Write(buf[0:n])
-		length := result.Len()
-		contents := result.Bytes()
+	switch verb {
+	case uiDir:
+		args, err := splitArgs(rest)
+		if err != nil {
+			return err
+		}
+		return dirRequest(conn, args...)
+	case uiCd:
+		path, err := onePath(rest)
+		if err != nil {
+			return errors.New("usage: cd <dir>")
+		}
+		return cdRequest(conn, path)
+	case uiPwd:
+		return pwdRequest(conn)
+	case uiGet, uiReget:
+		paths, err := transferPaths(rest)
+		if err != nil {
+			return fmt.Errorf("usage: %s <remote> | %s \"<remote>\" <local>", verb, verb)
+		}
+		remote := paths[0]
+		local := filepath.Base(remote)
+		if len(paths) == 2 {
+			local = paths[1]
+		}
+		if verb == uiReget {
+			return regetRequest(conn, remote, local)
+		}
+		return getRequest(conn, remote, local)
+	case uiPut, uiReput:
+		paths, err := transferPaths(rest)
+		if err != nil {
+			return fmt.Errorf("usage: %s <local> | %s \"<local>\" <remote>", verb, verb)
+		}
+		local := paths[0]
+		remote := filepath.Base(local)
+		if len(paths) == 2 {
+			remote = paths[1]
+		}
+		if verb == uiReput {
+			return reputRequest(conn, local, remote)
+		}
+		return putRequest(conn, local, remote)
+	case uiDel:
+		path, err := onePath(rest)
+		if err != nil {
+			return errors.New("usage: del <path>")
+		}
+		return simpleRequest(conn, StatusFileActionOK, DELE+" %s", path)
+	case uiMkdir:
+		path, err := onePath(rest)
+		if err != nil {
+			return errors.New("usage: mkdir <dir>")
+		}
+		return simpleRequest(conn, StatusPathCreated, MKD+" %s", path)
+	case uiRename:
+		paths, err := splitArgs(rest)
+		if err != nil || len(paths) != 2 {
+			return errors.New(`usage: rename <from> <to>, quote paths with spaces`)
+		}
+		return renameRequest(conn, paths[0], paths[1])
+	case uiQuit:
+		simpleRequest(conn, StatusClosing, QUIT)
+		conn.Close()
+		return errQuit
+	}
+	return fmt.Errorf("unknown command %q", verb)
+}
+
+// errUsage is returned for bad command arguments; the caller prints the usage
+var errUsage = errors.New("bad arguments")
+
+// onePath returns the path of a command that takes one: the rest of the
+// line, without the quotes if it is quoted.
+func onePath(rest string) (string, error) {
+	if !strings.HasPrefix(rest, `"`) {
+		if rest == "" {
+			return "", errUsage
+		}
+		return rest, nil
+	}
+	args, err := splitArgs(rest)
+	if err != nil || len(args) != 1 {
+		return "", errUsage
+	}
+	return args[0], nil
+}
 
-		// \r\n\r\n ile bitiyorsa liste tamamlandı
-		if length >= 4 && string(contents[length-4:]) == "\r\n\r\n" {
-			fmt.Println(string(contents[0 : length-4]))
-			return
+// transferPaths parses the get/put arguments: unquoted, the rest of the
+// line is one path; starting with a quote, one or two paths may be given.
+func transferPaths(rest string) ([]string, error) {
+	if !strings.HasPrefix(rest, `"`) {
+		if rest == "" {
+			return nil, errUsage
 		}
+		return []string{rest}, nil
 	}
+	args, err := splitArgs(rest)
+	if err != nil || len(args) < 1 || len(args) > 2 {
+		return nil, errUsage
+	}
+	return args, nil
 }
 
-func cdRequest(conn net.Conn, dir string) {
-	conn.Write([]byte(CD + " " + dir))
-	var response [512]byte
-	n, _ := conn.Read(response[0:])
-	s := string(response[0:n])
-	if s != "OK" {
-		fmt.Println("Failed to change dir")
+// splitArgs splits the line at spaces; spaces inside double quotes do not
+// split and the quotes are dropped ("a b" c -> [a b] [c]).
+func splitArgs(s string) ([]string, error) {
+	var (
+		args    []string
+		arg     strings.Builder
+		inArg   bool
+		inQuote bool
+	)
+	for _, r := range s {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+			inArg = true
+		case !inQuote && (r == ' ' || r == '\t'):
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if inQuote {
+		return nil, errors.New("unterminated quote")
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// ftpConn is the control connection and what it takes to open data connections
+type ftpConn struct {
+	*textproto.Conn
+	host    string        // Server to open data connections to
+	tls     *tls.Config   // For data connections when protect is set
+	protect bool          // PROT P: data connections use TLS too
+	timeout time.Duration // Dial and read/write timeout
+}
+
+// idleConn renews the deadline before every read and write, so the timeout
+// limits the time without progress rather than the total, and long
+// transfers are not cut off
+type idleConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c idleConn) Read(b []byte) (int, error) {
+	c.Conn.SetDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Read(b)
+}
+
+func (c idleConn) Write(b []byte) (int, error) {
+	c.Conn.SetDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Write(b)
+}
+
+// dialTCP dials with a timeout and wraps the connection in idleConn when timeout > 0
+func dialTCP(addr string, timeout time.Duration) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil || timeout <= 0 {
+		return conn, err
+	}
+	return idleConn{conn, timeout}, nil
+}
+
+// dial connects to the server and reads the 220 greeting.
+// In implicit mode the TLS handshake happens right away; in explicit mode
+// the same socket is upgraded with AUTH TLS after the plaintext greeting.
+// With TLS, PBSZ 0 / PROT P encrypt the data connections as well.
+// If the connection fails the program exits with exitConnection.
+func dial(addr, mode string, cfg *tls.Config, timeout time.Duration) *ftpConn {
+	if mode != "off" && mode != "implicit" && mode != "explicit" {
+		fmt.Fprintln(os.Stderr, "Unknown TLS mode", mode)
+		os.Exit(exitUsage)
+	}
+
+	raw, err := dialTCP(addr, timeout)
+	checkError(err)
+	if mode == "implicit" {
+		tlsConn := tls.Client(raw, cfg)
+		checkError(tlsConn.Handshake())
+		raw = tlsConn
+	}
+
+	host, _, err := net.SplitHostPort(raw.RemoteAddr().String())
+	checkError(err)
+	conn := &ftpConn{Conn: textproto.NewConn(raw), host: host, tls: cfg, timeout: timeout}
+
+	// Greeting: 220
+	_, msg, err := conn.ReadResponse(StatusReady)
+	checkError(err)
+	fmt.Println("Connected to server:", addr, "-", msg)
+
+	if mode == "explicit" {
+		_, _, err := command(conn, StatusAuthOK, AUTH+" TLS")
+		checkError(err)
+
+		tlsConn := tls.Client(raw, cfg)
+		checkError(tlsConn.Handshake())
+		conn.Conn = textproto.NewConn(tlsConn)
+		fmt.Println("Connection upgraded to", tls.VersionName(tlsConn.ConnectionState().Version))
+	}
+
+	if mode != "off" {
+		_, _, err := command(conn, StatusOK, PBSZ+" 0")
+		checkError(err)
+		_, _, err = command(conn, StatusOK, PROT+" P")
+		checkError(err)
+		conn.protect = true
+	}
+
+	return conn
+}
+
+// openData gets a port from the server with EPSV (PASV if that fails) and
+// opens the data connection. Every transfer uses a new data connection.
+func openData(conn *ftpConn) (net.Conn, error) {
+	var port string
+
+	_, msg, err := command(conn, StatusExtendedPassive, EPSV)
+	if err == nil {
+		// "Entering Extended Passive Mode (|||port|)"
+		start, end := strings.Index(msg, "(|||"), strings.LastIndex(msg, "|)")
+		if start < 0 || end < start+4 {
+			return nil, fmt.Errorf("bad EPSV reply: %s", msg)
+		}
+		port = msg[start+4 : end]
 	} else {
-		fmt.Println("Directory changed to:", dir)
+		_, msg, err = command(conn, StatusPassive, PASV)
+		if err != nil {
+			return nil, err
+		}
+
+		// "Entering Passive Mode (h1,h2,h3,h4,p1,p2)"; only the port is
+		// taken, the address is that of the control connection
+		start, end := strings.Index(msg, "("), strings.LastIndex(msg, ")")
+		fields := strings.Split(msg[start+1:max(start+1, end)], ",")
+		if start < 0 || len(fields) != 6 {
+			return nil, fmt.Errorf("bad PASV reply: %s", msg)
+		}
+		p1, err1 := strconv.Atoi(fields[4])
+		p2, err2 := strconv.Atoi(fields[5])
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("bad PASV reply: %s", msg)
+		}
+		port = strconv.Itoa(p1<<8 | p2)
+	}
+
+	data, err := dialTCP(net.JoinHostPort(conn.host, port), conn.timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	// The handshake happens on the first read/write: the server accepts the
+	// data connection only after it has received RETR/STOR/LIST
+	if conn.protect {
+		return tls.Client(data, conn.tls), nil
+	}
+	return data, nil
+}
+
+// transferSum returns the "sha256 <hex>" value of a 226 reply
+func transferSum(msg string) string {
+	_, sum, _ := strings.Cut(msg, "sha256 ")
+	return sum
+}
+
+// loginRequest sends USER/PASS; the program exits if the login fails
+func loginRequest(conn *ftpConn, user, password string) {
+	// 331 asks for a password; 230 means the server needs no login
+	code, msg, err := command(conn, 0, USER+" %s", user)
+	if code != StatusNeedPassword && code != StatusLoggedIn {
+		fmt.Fprintln(os.Stderr, "Login failed:", code, msg)
+		os.Exit(exitConnection)
+	}
+
+	if code == StatusNeedPassword {
+		_, msg, err = command(conn, StatusLoggedIn, PASS+" %s", password)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Login failed:", err)
+			os.Exit(exitConnection)
+		}
+	}
+	fmt.Println(msg)
+}
+
+// command sends a command and reads the reply with the expected code.
+// A different reply code returns a *textproto.Error; connection errors
+// end the program, since the protocol is out of sync.
+func command(conn *ftpConn, expect int, format string, args ...any) (int, string, error) {
+	checkError(conn.PrintfLine(format, args...))
+
+	code, msg, err := conn.ReadResponse(expect)
+	if err != nil {
+		if _, ok := err.(*textproto.Error); !ok {
+			checkError(err)
+		}
+	}
+	return code, msg, err
+}
+
+func cdRequest(conn *ftpConn, dir string) error {
+	_, msg, err := command(conn, StatusFileActionOK, CWD+" %s", dir)
+	if err != nil {
+		return fmt.Errorf("failed to change dir: %w", err)
 	}
+	fmt.Println(msg)
+	return nil
 }
 
-func pwdRequest(conn net.Conn) {
-	conn.Write([]byte(PWD))
-	var response [512]byte
-	n, _ := conn.Read(response[0:])
-	s := string(response[0:n])
-	fmt.Println("Current directory:", s)
+func pwdRequest(conn *ftpConn) error {
+	_, msg, err := command(conn, StatusPathCreated, PWD)
+	if err != nil {
+		return err
+	}
+
+	dir, err := strconv.Unquote(msg)
+	if err != nil {
+		dir = msg
+	}
+	fmt.Println("Current directory:", dir)
+	return nil
+}
+
+// renameRequest renames a file with RNFR + RNTO
+func renameRequest(conn *ftpConn, from, to string) error {
+	if _, _, err := command(conn, StatusPendingInfo, RNFR+" %s", from); err != nil {
+		return err
+	}
+	return simpleRequest(conn, StatusFileActionOK, RNTO+" %s", to)
+}
+
+// simpleRequest is for commands with a one-line reply
+func simpleRequest(conn *ftpConn, expect int, format string, args ...any) error {
+	_, msg, err := command(conn, expect, format, args...)
+	if err != nil {
+		return err
+	}
+	fmt.Println(msg)
+	return nil
+}
+
+// getRequest downloads a file from the start
+func getRequest(conn *ftpConn, remote, local string) error {
+	return download(conn, remote, local, 0)
+}
+
+// download opens the data connection, sends RETR and reads the data until
+// the connection closes. The 150 reply carries "(<size> bytes)", the 226
+// reply "sha256 <hex>". With offset > 0, REST is sent first and the data
+// is appended to local+".part"; the checksum in the 226 then covers only
+// the range received.
+func download(conn *ftpConn, remote, local string, offset int64) error {
+	if offset > 0 {
+		if _, _, err := command(conn, StatusPendingInfo, REST+" %d", offset); err != nil {
+			return err
+		}
+	}
+
+	data, err := openData(conn)
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+
+	_, msg, err := command(conn, StatusTransferStarting, RETR+" %s", remote)
+	if err != nil {
+		return err
+	}
+
+	// "Opening data connection for <path> (<size> bytes)"
+	var size int64 = -1
+	if i := strings.LastIndex(msg, "("); i >= 0 {
+		fmt.Sscanf(msg[i:], "(%d bytes)", &size)
+	}
+
+	// Write to the .part file first and move it into place if the checksum
+	// matches. If the connection drops, the .part file stays for reget.
+	part := local + ".part"
+	flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if offset > 0 {
+		flag = os.O_WRONLY | os.O_APPEND
+	}
+	f, err := os.OpenFile(part, flag, 0o644)
+	if err != nil {
+		// Read the data anyway so the server can finish the transfer
+		io.Copy(io.Discard, data)
+		data.Close()
+		conn.ReadResponse(StatusTransferComplete)
+		return fmt.Errorf("cannot open local file: %w", err)
+	}
+
+	h := sha256.New()
+	progress := newProgress("get "+remote, size)
+	received, err := io.Copy(io.MultiWriter(f, h, progress), data)
+	progress.done()
+	f.Close()
+	data.Close()
+	if err == nil && size >= 0 && received != size {
+		err = fmt.Errorf("got %d of %d bytes", received, size)
+	}
+	if err != nil {
+		conn.ReadResponse(StatusTransferComplete)
+		return fmt.Errorf("download interrupted, resume with reget: %w", err)
+	}
+
+	_, msg, err = conn.ReadResponse(StatusTransferComplete)
+	sum := transferSum(msg)
+	if err != nil || sum != hex.EncodeToString(h.Sum(nil)) {
+		os.Remove(part)
+		return errors.New("checksum mismatch, download discarded")
+	}
+
+	// The resumed range arrived intact; compare the whole file with the server too
+	if offset > 0 {
+		fullSum, _ := hashLocal(part, -1)
+		if remoteSum, err := hashRemote(conn, remote, 0, -1); err != nil || remoteSum != fullSum {
+			os.Remove(part)
+			return errors.New("checksum mismatch after resume, download discarded")
+		}
+		sum = fullSum
+	}
+
+	if err := os.Rename(part, local); err != nil {
+		return err
+	}
+	fmt.Printf("Downloaded %s -> %s (sha256 %s)\n", remote, local, sum)
+	return nil
+}
+
+// regetRequest resumes an interrupted download.
+//
+// The size and SHA-256 of local+".part" are compared with the same range
+// of the file on the server. If they match only the missing part is
+// requested, otherwise the download starts over.
+func regetRequest(conn *ftpConn, remote, local string) error {
+	info, err := os.Stat(local + ".part")
+	if err != nil {
+		fmt.Println("No partial download found, starting from zero")
+		return download(conn, remote, local, 0)
+	}
+
+	total, err := sizeRemote(conn, remote)
+	if err != nil {
+		return err
+	}
+
+	have := info.Size()
+	if have == 0 || have > total {
+		fmt.Println("Partial file does not fit remote file, starting from zero")
+		return download(conn, remote, local, 0)
+	}
+
+	localSum, err := hashLocal(local+".part", have)
+	if err != nil {
+		return err
+	}
+	remoteSum, err := hashRemote(conn, remote, 0, have)
+	if err != nil {
+		return err
+	}
+	if localSum != remoteSum {
+		fmt.Println("Partial file differs from remote file, starting from zero")
+		return download(conn, remote, local, 0)
+	}
+
+	fmt.Printf("Resuming %s at %s of %s\n", remote, humanBytes(float64(have)), humanBytes(float64(total)))
+	return download(conn, remote, local, have)
+}
+
+// putRequest uploads a file from the start
+func putRequest(conn *ftpConn, local, remote string) error {
+	return upload(conn, local, remote, 0)
+}
+
+// reputRequest resumes an interrupted upload: if the size and hash of
+// remote+".part" on the server match the start of the local file, the
+// rest is sent with REST.
+func reputRequest(conn *ftpConn, local, remote string) error {
+	info, err := os.Stat(local)
+	if err != nil {
+		return fmt.Errorf("cannot open local file: %w", err)
+	}
+
+	have, err := sizeRemote(conn, remote+".part")
+	if err != nil || have == 0 || have > info.Size() {
+		fmt.Println("No usable partial upload, starting from zero")
+		return upload(conn, local, remote, 0)
+	}
+
+	localSum, err := hashLocal(local, have)
+	if err != nil {
+		return err
+	}
+	remoteSum, err := hashRemote(conn, remote+".part", 0, have)
+	if err != nil || localSum != remoteSum {
+		fmt.Println("Partial upload differs from local file, starting from zero")
+		return upload(conn, local, remote, 0)
+	}
+
+	fmt.Printf("Resuming %s at %s of %s\n", local, humanBytes(float64(have)), humanBytes(float64(info.Size())))
+	return upload(conn, local, remote, have)
+}
+
+// upload announces the size with ALLO, opens the data connection, sends
+// STOR and the file; closing the data connection marks the end of file.
+// With offset > 0, REST is sent first; the checksum always covers the
+// whole file.
+func upload(conn *ftpConn, local, remote string, offset int64) error {
+	f, err := os.Open(local)
+	if err != nil {
+		return fmt.Errorf("cannot open local file: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		return fmt.Errorf("not a regular file: %s", local)
+	}
+
+	// Read from the start so the part already on the server is in the checksum
+	h := sha256.New()
+	if offset > 0 {
+		if _, err := io.CopyN(h, f, offset); err != nil {
+			return err
+		}
+		if _, _, err := command(conn, StatusPendingInfo, REST+" %d", offset); err != nil {
+			return err
+		}
+	}
+
+	// Announcing the size keeps a dropped upload from counting as complete
+	remaining := info.Size() - offset
+	if _, _, err := command(conn, StatusOK, ALLO+" %d", remaining); err != nil {
+		return err
+	}
+
+	data, err := openData(conn)
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+
+	// If the server refuses the path no data is sent
+	if _, _, err := command(conn, StatusTransferStarting, STOR+" %s", remote); err != nil {
+		return err
+	}
+
+	progress := newProgress("put "+local, remaining)
+	_, err = io.CopyN(io.MultiWriter(data, h, progress), f, remaining)
+	progress.done()
+	if closeErr := data.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		conn.ReadResponse(StatusTransferComplete)
+		return fmt.Errorf("upload interrupted, resume with reput: %w", err)
+	}
+
+	_, msg, err := conn.ReadResponse(StatusTransferComplete)
+	if err != nil {
+		return err
+	}
+
+	sum := hex.EncodeToString(h.Sum(nil))
+	if transferSum(msg) != sum {
+		return fmt.Errorf("checksum mismatch, server has %s", transferSum(msg))
+	}
+	fmt.Printf("Uploaded %s -> %s (sha256 %s)\n", local, remote, sum)
+	return nil
+}
+
+// sizeRemote asks for the size of a file on the server with SIZE
+func sizeRemote(conn *ftpConn, remote string) (int64, error) {
+	_, msg, err := command(conn, StatusFileStatus, SIZE+" %s", remote)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(msg, 10, 64)
+}
+
+// hashRemote asks for the SHA-256 of a range on the server with HASH
+func hashRemote(conn *ftpConn, remote string, offset, length int64) (string, error) {
+	format := HASH + ` "%s" %d`
+	args := []any{remote, offset}
+	if length >= 0 {
+		format += " %d"
+		args = append(args, length)
+	}
+
+	_, msg, err := command(conn, StatusFileStatus, format, args...)
+	if err != nil {
+		return "", err
+	}
+	return msg, nil
+}
+
+// hashLocal returns the SHA-256 of the first length bytes (-1: all) of a local file
+func hashLocal(path string, length int64) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if length >= 0 {
+		r = io.LimitReader(f, length)
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// progress prints a one-line progress bar during a transfer
+type progress struct {
+	label   string
+	total   int64
+	current int64
+	start   time.Time
+	last    time.Time
+}
+
+func newProgress(label string, total int64) *progress {
+	now := time.Now()
+	return &progress{label: label, total: total, start: now}
+}
+
+func (p *progress) Write(b []byte) (int, error) {
+	p.current += int64(len(b))
+
+	// Draw at most 10 times a second so the terminal keeps up
+	if showProgress && time.Since(p.last) >= 100*time.Millisecond {
+		p.draw()
+		p.last = time.Now()
+	}
+	return len(b), nil
+}
+
+func (p *progress) draw() {
+	percent := 100.0
+	if p.total > 0 {
+		percent = float64(p.current) * 100 / float64(p.total)
+	}
+
+	const width = 30
+	filled := int(percent / 100 * width)
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", width-filled)
+
+	rate := float64(p.current) / time.Since(p.start).Seconds()
+	fmt.Printf("\r%s [%s] %5.1f%% %s/%s %s/s ",
+		p.label, bar, percent, humanBytes(float64(p.current)), humanBytes(float64(p.total)), humanBytes(rate))
+}
+
+func (p *progress) done() {
+	if showProgress {
+		p.draw()
+		fmt.Println()
+	}
+}
+
+// humanBytes formats a byte count as KB/MB/GB
+func humanBytes(n float64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	i := 0
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f%s", n, units[i])
 }
 
+// checkError ends the program on a connection or protocol error
 func checkError(err error) {
 	if err != nil {
-		log.Fatalln("Fatal error:", err.Error())
+		fmt.Fprintln(os.Stderr, "Fatal error:", err.Error())
+		os.Exit(exitConnection)
 	}
 }
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"a", []string{"a"}},
+		{"a  b\tc", []string{"a", "b", "c"}},
+		{`"a b" c`, []string{"a b", "c"}},
+		{`"a b" "c d"`, []string{"a b", "c d"}},
+		{`x"y z"`, []string{"xy z"}},
+		{`""`, []string{""}},
+		{`-S "*.tar gz"`, []string{"-S", "*.tar gz"}},
+	} {
+		got, err := splitArgs(tc.in)
+		if err != nil || !slices.Equal(got, tc.want) {
+			t.Errorf("splitArgs(%q) = %q, %v, want %q", tc.in, got, err, tc.want)
+		}
+	}
+	if _, err := splitArgs(`"a b`); err == nil {
+		t.Error("unterminated quote accepted")
+	}
+}
+
+func TestTransferPaths(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want []string // nil when the arguments must be refused
+	}{
+		{"notes.txt", []string{"notes.txt"}},
+		{"lab notes.txt", []string{"lab notes.txt"}},
+		{"dir/my file 2.bin", []string{"dir/my file 2.bin"}},
+		{`"lab notes.txt"`, []string{"lab notes.txt"}},
+		{`"lab notes.txt" local.txt`, []string{"lab notes.txt", "local.txt"}},
+		{`"lab notes.txt" "local copy.txt"`, []string{"lab notes.txt", "local copy.txt"}},
+		{"", nil},
+		{`"a" b c`, nil},
+		{`"a`, nil},
+	} {
+		got, err := transferPaths(tc.in)
+		switch {
+		case tc.want == nil && err == nil:
+			t.Errorf("transferPaths(%q) = %q, want it refused", tc.in, got)
+		case tc.want != nil && (err != nil || !slices.Equal(got, tc.want)):
+			t.Errorf("transferPaths(%q) = %q, %v, want %q", tc.in, got, err, tc.want)
+		}
+	}
+}
+
+func TestOnePath(t *testing.T) {
+	for _, tc := range []struct {
+		in, want string // want "" when refused
+	}{
+		{"sub", "sub"},
+		{"my dir", "my dir"},
+		{`"my dir"`, "my dir"},
+		{"", ""},
+		{`"my dir" x`, ""},
+	} {
+		got, err := onePath(tc.in)
+		if (tc.want == "") != (err != nil) || got != tc.want {
+			t.Errorf("onePath(%q) = %q, %v, want %q", tc.in, got, err, tc.want)
+		}
+	}
+}
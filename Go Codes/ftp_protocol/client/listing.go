@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// entry is a parsed MLSD line
+type entry struct {
+	Name     string    `json:"name"`
+	Type     string    `json:"type"`
+	Size     int64     `json:"size"`
+	Mode     string    `json:"mode"`
+	Modified time.Time `json:"modified"`
+}
+
+// parseFacts parses a "type=file;size=12;modify=20250114093015;UNIX.mode=0644; name"
+// line. Unknown facts are skipped.
+func parseFacts(line string) (entry, error) {
+	facts, name, ok := strings.Cut(line, " ")
+	if !ok {
+		return entry{}, fmt.Errorf("bad MLSD line: %q", line)
+	}
+
+	e := entry{Name: name}
+	var perm fs.FileMode
+	for _, fact := range strings.Split(strings.TrimSuffix(facts, ";"), ";") {
+		key, value, _ := strings.Cut(fact, "=")
+		switch strings.ToLower(key) {
+		case "type":
+			e.Type = value
+		case "size":
+			e.Size, _ = strconv.ParseInt(value, 10, 64)
+		case "modify":
+			e.Modified, _ = time.Parse("20060102150405", value)
+		case "unix.mode":
+			m, _ := strconv.ParseUint(value, 8, 32)
+			perm = fs.FileMode(m).Perm()
+		}
+	}
+
+	if e.Type == "dir" {
+		perm |= fs.ModeDir
+	}
+	e.Mode = perm.String()
+	return e, nil
+}
+
+// dirRequest reads the MLSD listing from the data connection; the listing
+// ends when the server closes the data connection. The options (-S, -t, -r)
+// and glob are passed to the server as given; with -json the output is
+// JSON instead of a table.
+func dirRequest(conn *ftpConn, args ...string) error {
+	asJSON := false
+	list := MLSD
+	for _, arg := range args {
+		if arg == "-json" {
+			asJSON = true
+			continue
+		}
+		list += " " + arg
+	}
+
+	data, err := openData(conn)
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+
+	if _, _, err := command(conn, StatusTransferStarting, "%s", list); err != nil {
+		return err
+	}
+
+	var entries []entry
+	scanner := bufio.NewScanner(data)
+	for scanner.Scan() {
+		e, err := parseFacts(scanner.Text())
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		conn.ReadResponse(StatusTransferComplete)
+		return fmt.Errorf("listing interrupted: %w", err)
+	}
+
+	if _, _, err := conn.ReadResponse(StatusTransferComplete); err != nil {
+		return err
+	}
+
+	if asJSON {
+		out, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+		return nil
+	}
+	printTable(entries)
+	return nil
+}
+
+// printTable prints the entries in aligned columns
+func printTable(entries []entry) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MODE\tSIZE\tMODIFIED\tNAME")
+	for _, e := range entries {
+		size := humanBytes(float64(e.Size))
+		if e.Type == "dir" {
+			size = "-"
+		}
+		fmt.Fprintf(w, "%s\t%8s\t%s\t%s\n",
+			e.Mode, size, e.Modified.Local().Format("2006-01-02 15:04"), e.Name)
+	}
+	w.Flush()
+	fmt.Printf("%d entries\n", len(entries))
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// serverBin is the FTP server from ../server and clientBin this client,
+// built once for all tests.
+var serverBin, clientBin string
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "ftp-client-test")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	serverBin = filepath.Join(dir, "server")
+	clientBin = filepath.Join(dir, "client")
+	for bin, pkg := range map[string]string{serverBin: "../server", clientBin: "."} {
+		if out, err := exec.Command("go", "build", "-o", bin, pkg).CombinedOutput(); err != nil {
+			fmt.Fprintf(os.Stderr, "building %s: %v\n%s", pkg, err, out)
+			os.Exit(1)
+		}
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// freeAddr returns a loopback address with a port nobody listens on.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+// startServer runs the server on root until the test ends and returns its
+// address once it accepts connections.
+func startServer(t *testing.T, root string, args ...string) string {
+	t.Helper()
+	addr := freeAddr(t)
+	cmd := exec.Command(serverBin, append([]string{"-root", root, "-listen", addr}, args...)...)
+	cmd.Stdout = io.Discard
+	cmd.Stderr = io.Discard
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(20 * time.Millisecond) {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			return addr
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server did not start on %s: %v", addr, err)
+		}
+	}
+}
+
+// connect starts a server on root and opens a client session to it.
+func connect(t *testing.T, root string) *ftpConn {
+	t.Helper()
+	showProgress = false
+	conn := dial(startServer(t, root), "off", nil, 10*time.Second)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testContent returns n bytes that differ from any shifted copy of them.
+func testContent(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i*7 + i/251)
+	}
+	return b
+}
+
+// checkFile compares a file with want and checks that no ".part" file is
+// left next to it.
+func checkFile(t *testing.T, name string, want []byte) {
+	t.Helper()
+	got, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s has %d bytes, differs from the %d bytes sent", name, len(got), len(want))
+	}
+	if _, err := os.Stat(name + ".part"); err == nil {
+		t.Errorf("%s.part left behind", name)
+	}
+}
+
+// stdout runs f and returns what it printed.
+func stdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make(chan []byte)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- b
+	}()
+
+	saved := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = saved }()
+	f()
+	w.Close()
+	return string(<-out)
+}
+
+func TestReput(t *testing.T) {
+	content := testContent(300 << 10)
+	local := filepath.Join(t.TempDir(), "fw.bin")
+	if err := os.WriteFile(local, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		part []byte // on the server before reput, nil for none
+		want string // what reput reports
+	}{
+		{"resume", content[:100<<10], "Resuming"},
+		{"mismatch", bytes.Repeat([]byte("x"), 100<<10), "Partial upload differs"},
+		{"longer", append(content, 'x'), "No usable partial upload"},
+		{"none", nil, "No usable partial upload"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			remote := filepath.Join(root, "fw image.bin")
+			if tc.part != nil {
+				if err := os.WriteFile(remote+".part", tc.part, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			conn := connect(t, root)
+
+			var err error
+			out := stdout(t, func() { err = execute(conn, `reput "`+local+`" "fw image.bin"`) })
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(out, tc.want) {
+				t.Errorf("reput printed %q, want %q", out, tc.want)
+			}
+			checkFile(t, remote, content)
+		})
+	}
+}
+
+func TestReget(t *testing.T) {
+	content := testContent(300 << 10)
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "fw image.bin"), content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		part []byte // local before reget, nil for none
+		want string // what reget reports
+	}{
+		{"resume", content[:100<<10], "Resuming"},
+		{"mismatch", bytes.Repeat([]byte("x"), 100<<10), "Partial file differs"},
+		{"longer", append(content, 'x'), "Partial file does not fit"},
+		{"none", nil, "No partial download found"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			local := filepath.Join(t.TempDir(), "copy.bin")
+			if tc.part != nil {
+				if err := os.WriteFile(local+".part", tc.part, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			conn := connect(t, root)
+
+			var err error
+			out := stdout(t, func() { err = execute(conn, `reget "fw image.bin" "`+local+`"`) })
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(out, tc.want) {
+				t.Errorf("reget printed %q, want %q", out, tc.want)
+			}
+			checkFile(t, local, content)
+		})
+	}
+}
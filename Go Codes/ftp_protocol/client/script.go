@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Script mode
+//
+// The commands in the file given with -script run in order; they are the
+// same as at the prompt (cd, get, put, dir, ...). Blank lines and lines
+// starting with "#" are skipped:
+//
+//	# nightly: fetch the lab outputs
+//	cd /builds/nightly
+//	dir *.tar.gz
+//	get firmware.tar.gz
+//	put report.txt
+//
+// By default the script stops at the first failed command; with -continue
+// the remaining commands run as well. The exit code is for schedulers
+// such as cron.
+const (
+	exitOK         = 0 // All commands succeeded
+	exitFailed     = 1 // At least one command failed
+	exitUsage      = 2 // Bad flags or the script could not be read
+	exitConnection = 3 // Connection, TLS or login failure
+)
+
+// runScript reads the script called name from r, runs it and returns the exit code.
+func runScript(conn *ftpConn, name string, r io.Reader, keepGoing bool) int {
+	code := exitOK
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// Echo the command so it shows in the logs
+		fmt.Println(">", line)
+		err := execute(conn, line)
+		if err == errQuit {
+			return code
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s:%d: %s: %v\n", name, lineNo, line, err)
+			code = exitFailed
+			if !keepGoing {
+				break
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintln(os.Stderr, "Cannot read script:", err)
+		return exitUsage
+	}
+
+	execute(conn, uiQuit)
+	return code
+}
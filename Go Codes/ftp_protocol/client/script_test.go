@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// runClient runs the client binary in dir with args and the script text
+// on stdin, and returns its exit code and output.
+func runClient(t *testing.T, dir, script string, env []string, args ...string) (int, string) {
+	t.Helper()
+	cmd := exec.Command(clientBin, args...)
+	cmd.Dir = dir
+	cmd.Stdin = strings.NewReader(script)
+	cmd.Env = append(os.Environ(), env...)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return 0, out.String()
+	case errors.As(err, &exitErr):
+		return exitErr.ExitCode(), out.String()
+	}
+	t.Fatal(err)
+	return 0, ""
+}
+
+// TestScript runs -script against a real server and checks the
+// documented exit codes.
+func TestScript(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "remote.txt"), []byte("remote"), 0o644)
+	addr := startServer(t, root)
+
+	for _, tc := range []struct {
+		name     string
+		script   string
+		args     []string
+		code     int
+		uploaded bool // local.txt reached the server
+	}{
+		{"success", "# comment\n\ncd /\ndir\nget remote.txt\nput local.txt\n", nil, exitOK, true},
+		{"quit", "get remote.txt\nquit\nput local.txt\n", nil, exitOK, false},
+		{"failure stops", "get missing.txt\nput local.txt\n", nil, exitFailed, false},
+		{"continue", "get missing.txt\nput local.txt\n", []string{"-continue"}, exitFailed, true},
+		{"bad usage", "cd\nput local.txt\n", nil, exitFailed, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			os.Remove(filepath.Join(root, "local.txt"))
+			dir := t.TempDir()
+			os.WriteFile(filepath.Join(dir, "local.txt"), []byte("local"), 0o644)
+
+			args := append(append([]string{"-script", "-"}, tc.args...), addr)
+			code, out := runClient(t, dir, tc.script, nil, args...)
+			if code != tc.code {
+				t.Fatalf("exit code %d, want %d\n%s", code, tc.code, out)
+			}
+			_, err := os.Stat(filepath.Join(root, "local.txt"))
+			if uploaded := err == nil; uploaded != tc.uploaded {
+				t.Errorf("local.txt uploaded: %v, want %v\n%s", uploaded, tc.uploaded, out)
+			}
+			if tc.code == exitFailed && !strings.Contains(out, "-:1: ") {
+				t.Errorf("failure not reported with its line number:\n%s", out)
+			}
+		})
+	}
+
+	// A script file is read the same way
+	dir := t.TempDir()
+	script := filepath.Join(dir, "nightly.ftp")
+	os.WriteFile(script, []byte("get remote.txt\n"), 0o644)
+	if code, out := runClient(t, dir, "", nil, "-script", script, addr); code != exitOK {
+		t.Errorf("script file: exit code %d\n%s", code, out)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "remote.txt")); string(got) != "remote" {
+		t.Errorf("script file fetched %q", got)
+	}
+}
+
+// TestScriptExitCodes checks the codes for usage errors and for failures
+// before the script starts.
+func TestScriptExitCodes(t *testing.T) {
+	root := t.TempDir()
+	hash, err := bcrypt.GenerateFromPassword([]byte("alice-pw"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Mkdir(filepath.Join(root, "alice"), 0o755)
+	users := filepath.Join(t.TempDir(), "users.txt")
+	os.WriteFile(users, []byte(fmt.Sprintf("alice : %s : alice : rw\n", hash)), 0o600)
+	addr := startServer(t, root, "-users", users)
+	dir := t.TempDir()
+
+	for _, tc := range []struct {
+		name string
+		env  []string
+		args []string
+		code int
+	}{
+		{"login", []string{"FTP_PASSWORD=alice-pw"}, []string{"-script", "-", "-user", "alice", addr}, exitOK},
+		{"wrong password", []string{"FTP_PASSWORD=wrong"}, []string{"-script", "-", "-user", "alice", addr}, exitConnection},
+		{"no server", nil, []string{"-script", "-", "-timeout", "5s", freeAddr(t)}, exitConnection},
+		{"missing script", nil, []string{"-script", filepath.Join(dir, "missing.ftp"), addr}, exitUsage},
+		{"no host", nil, []string{"-script", "-"}, exitUsage},
+		{"bad flag", nil, []string{"-script", "-", "-bogus", addr}, exitUsage},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if code, out := runClient(t, dir, "pwd\n", tc.env, tc.args...); code != tc.code {
+				t.Errorf("exit code %d, want %d\n%s", code, tc.code, out)
+			}
+		})
+	}
+}
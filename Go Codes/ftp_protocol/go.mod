@@ -0,0 +1,5 @@
+module ftp_protocol
+
+go 1.25.3
+
+require golang.org/x/crypto v0.46.0
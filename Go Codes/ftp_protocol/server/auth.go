@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Users file format
+//
+// One account per line, fields separated by ":"; blank lines and lines
+// starting with "#" are ignored:
+//
+//	# name  : bcrypt hash                  : root      : permission
+//	alice   : $2a$10$Nf3...                : alice     : rw
+//	lab     : $2a$10$9aQ...                : firmware  : ro
+//
+// The root is the directory the user sees as "/". Relative roots are
+// resolved against the server's -root directory. Permission is "rw"
+// (read-write) or "ro" (read-only: listing, navigation, RETR, SIZE, HASH).
+//
+// Hashes can be created with:
+//
+//	go run ./server -hash-password
+
+// Permission controls which commands a user may run.
+type Permission int
+
+const (
+	ReadOnly Permission = iota
+	ReadWrite
+)
+
+// User is one entry of the users file.
+type User struct {
+	Name string
+	Hash []byte
+	Root string
+	Perm Permission
+}
+
+// Users maps user names to accounts.
+type Users map[string]*User
+
+// errLoginFailed is deliberately vague so that clients cannot tell an
+// unknown user from a wrong password.
+var errLoginFailed = errors.New("login incorrect")
+
+// dummyHash is compared against when the user does not exist, so that
+// unknown names take as long to reject as wrong passwords.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+// LoadUsers parses a users file. Relative user roots are joined to base.
+func LoadUsers(path, base string) (Users, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	users := make(Users)
+	scanner := bufio.NewScanner(f)
+
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// bcrypt hashes contain "$" but never ":", so a plain split is safe
+		fields := strings.Split(line, ":")
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("%s:%d: expected name:hash:root:perm", path, lineNo)
+		}
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+
+		u := &User{Name: fields[0], Hash: []byte(fields[1]), Root: fields[2]}
+		if _, err := bcrypt.Cost(u.Hash); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, lineNo, err)
+		}
+
+		switch fields[3] {
+		case "rw":
+			u.Perm = ReadWrite
+		case "ro":
+			u.Perm = ReadOnly
+		default:
+			return nil, fmt.Errorf("%s:%d: permission must be rw or ro", path, lineNo)
+		}
+
+		if !filepath.IsAbs(u.Root) {
+			u.Root = filepath.Join(base, u.Root)
+		}
+		if _, dup := users[u.Name]; dup {
+			return nil, fmt.Errorf("%s:%d: duplicate user %q", path, lineNo, u.Name)
+		}
+		users[u.Name] = u
+	}
+
+	return users, scanner.Err()
+}
+
+// Authenticate checks a name and password against the users file.
+func (us Users) Authenticate(name, password string) (*User, error) {
+	u, ok := us[name]
+	if !ok {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, errLoginFailed
+	}
+
+	if err := bcrypt.CompareHashAndPassword(u.Hash, []byte(password)); err != nil {
+		return nil, errLoginFailed
+	}
+	return u, nil
+}
+
+// hashPassword reads a password from stdin and prints its bcrypt hash.
+func hashPassword() {
+	fmt.Fprint(os.Stderr, "Password: ")
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		checkError(err)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(strings.TrimRight(line, "\r\n")), bcrypt.DefaultCost)
+	checkError(err)
+	fmt.Println(string(hash))
+}
+
+// userName handles USER: it remembers the name until PASS arrives.
+func userName(c *client, name string) {
+	if c.srv.users == nil {
+		c.reply(StatusLoggedIn, "No login required")
+		return
+	}
+	if c.user != nil {
+		c.reply(StatusBadSequence, "Already logged in as %s", c.user.Name)
+		return
+	}
+
+	c.userName = name
+	c.reply(StatusNeedPassword, "Password required for %s", name)
+}
+
+// login handles PASS: it checks the password and opens the user's root.
+func login(c *client, password string) {
+	if c.srv.users == nil {
+		c.reply(StatusLoggedIn, "No login required")
+		return
+	}
+	if c.userName == "" || c.user != nil {
+		c.reply(StatusBadSequence, "Send USER first")
+		return
+	}
+
+	name := c.userName
+	c.userName = ""
+
+	u, err := c.srv.users.Authenticate(name, password)
+	if err != nil {
+		log.Printf("Login failed for %q from %s", name, c.conn.RemoteAddr())
+		c.reply(StatusNotLoggedIn, "%v", err)
+		return
+	}
+
+	sandbox, err := OpenSandbox(u.Root)
+	if err != nil {
+		log.Printf("Cannot open root %s for %s: %v", u.Root, u.Name, err)
+		c.reply(StatusLocalError, "Home directory unavailable")
+		return
+	}
+
+	c.user = u
+	c.perm = u.Perm
+	c.sess = NewSession(sandbox)
+
+	mode := "read-write"
+	if u.Perm == ReadOnly {
+		mode = "read-only"
+	}
+	log.Printf("User %s logged in from %s (%s)", u.Name, c.conn.RemoteAddr(), mode)
+	c.reply(StatusLoggedIn, "User %s logged in, %s access", u.Name, mode)
+}
+
+// logout releases the per-user root opened by login.
+func (c *client) logout() {
+	if c.user != nil && c.sess != nil {
+		c.sess.fs.Close()
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// writeUsers creates a users file below base with the accounts
+// "alice:alice-pw" (rw, root alice) and "lab:lab-pw" (ro, root firmware)
+// and loads it.
+func writeUsers(t *testing.T, base string) Users {
+	t.Helper()
+	var lines []string
+	for _, u := range []struct{ name, root, perm string }{
+		{"alice", "alice", "rw"},
+		{"lab", "firmware", "ro"},
+	} {
+		hash, err := bcrypt.GenerateFromPassword([]byte(u.name+"-pw"), bcrypt.MinCost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, fmt.Sprintf("%s : %s : %s : %s", u.name, hash, u.root, u.perm))
+		if err := os.MkdirAll(filepath.Join(base, u.root), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	file := filepath.Join(base, "users.txt")
+	content := "# name : hash : root : perm\n\n" + strings.Join(lines, "\n") + "\n"
+	if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	users, err := LoadUsers(file, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return users
+}
+
+func TestLoadUsers(t *testing.T) {
+	base := t.TempDir()
+	users := writeUsers(t, base)
+	if len(users) != 2 || users["alice"].Perm != ReadWrite || users["lab"].Perm != ReadOnly {
+		t.Fatalf("loaded %+v", users)
+	}
+	if want := filepath.Join(base, "firmware"); users["lab"].Root != want {
+		t.Errorf("lab root %s, want %s", users["lab"].Root, want)
+	}
+
+	if _, err := users.Authenticate("alice", "alice-pw"); err != nil {
+		t.Errorf("correct password: %v", err)
+	}
+	for _, tc := range []struct{ name, password string }{
+		{"alice", "wrong"},
+		{"alice", ""},
+		{"alice", "lab-pw"},
+		{"mallory", "alice-pw"},
+	} {
+		if _, err := users.Authenticate(tc.name, tc.password); !errors.Is(err, errLoginFailed) {
+			t.Errorf("Authenticate(%q, %q) = %v, want errLoginFailed", tc.name, tc.password, err)
+		}
+	}
+
+	for _, bad := range []string{
+		"alice : nothash : alice : rw",
+		"alice : $2a$04$abc : alice",
+		"alice : " + string(users["alice"].Hash) + " : alice : admin",
+	} {
+		file := filepath.Join(base, "bad.txt")
+		os.WriteFile(file, []byte(bad+"\n"), 0o600)
+		if _, err := LoadUsers(file, base); err == nil {
+			t.Errorf("LoadUsers accepted %q", bad)
+		}
+	}
+}
+
+// startUserServer starts a server with the accounts of writeUsers.
+func startUserServer(t *testing.T) *testServer {
+	t.Helper()
+	base := t.TempDir()
+	users := writeUsers(t, base)
+	ts := startServer(t, func(srv *server) { srv.users = users })
+	ts.root = base
+	return ts
+}
+
+func TestLogin(t *testing.T) {
+	ts := startUserServer(t)
+	text := dial(t, ts.addr, StatusReady)
+
+	// Nothing but the session commands before login
+	command(t, text, PWD, StatusNotLoggedIn, "Please login")
+	command(t, text, "PASS alice-pw", StatusBadSequence, "Send USER first")
+
+	command(t, text, "USER alice", StatusNeedPassword, "")
+	command(t, text, "PASS wrong", StatusNotLoggedIn, "login incorrect")
+	command(t, text, PWD, StatusNotLoggedIn, "Please login")
+
+	// An unknown user gets the same answer as a wrong password
+	command(t, text, "USER mallory", StatusNeedPassword, "")
+	command(t, text, "PASS alice-pw", StatusNotLoggedIn, "login incorrect")
+
+	command(t, text, "USER alice", StatusNeedPassword, "")
+	command(t, text, "PASS alice-pw", StatusLoggedIn, "User alice logged in, read-write access")
+	command(t, text, "USER lab", StatusBadSequence, "Already logged in as alice")
+	command(t, text, PWD, StatusPathCreated, `"/"`)
+}
+
+// TestUserRoots checks that each user sees only their own root and that
+// the read-only account can read but not change anything.
+func TestUserRoots(t *testing.T) {
+	ts := startUserServer(t)
+	mkfile(t, filepath.Join(ts.root, "alice", "a.txt"), "alice")
+	mkfile(t, filepath.Join(ts.root, "firmware", "fw.bin"), "firmware")
+
+	alice := dialFTP(t, ts.addr, "alice", "alice-pw")
+	if code, _ := alice.cmd("SIZE a.txt"); code != StatusFileStatus {
+		t.Errorf("alice SIZE a.txt: %d", code)
+	}
+	for _, p := range []string{"fw.bin", "../firmware/fw.bin", "/firmware/fw.bin"} {
+		if code, _ := alice.cmd("SIZE %s", p); code == StatusFileStatus {
+			t.Errorf("alice sees %s", p)
+		}
+	}
+	code, _ := alice.transfer(alice.epsv(), "STOR new.txt", func(c net.Conn) { c.Write([]byte("new")) })
+	if stored := readFile(t, filepath.Join(ts.root, "alice", "new.txt")); code != StatusTransferComplete || stored != "new" {
+		t.Errorf("alice STOR: %d, stored %q", code, stored)
+	}
+
+	lab := dialFTP(t, ts.addr, "lab", "lab-pw")
+	var got []byte
+	code, _ = lab.transfer(lab.epsv(), "RETR fw.bin", func(c net.Conn) { got, _ = io.ReadAll(c) })
+	if code != StatusTransferComplete || string(got) != "firmware" {
+		t.Errorf("lab RETR: %d, %q", code, got)
+	}
+	if code, _ := lab.cmd("SIZE a.txt"); code == StatusFileStatus {
+		t.Error("lab sees alice's file")
+	}
+	for _, line := range []string{"STOR x.bin", "PUT x.bin", "ALLO 10", "DELE fw.bin", "DEL fw.bin", "RMD /", "MKD new", "MKDIR new", "RNFR fw.bin", "RNTO x.bin", "RENAME fw.bin x.bin"} {
+		if code, msg := lab.cmd("%s", line); code != StatusFileUnavailable || !strings.Contains(msg, "read-only") {
+			t.Errorf("read-only %s: %d %s", line, code, msg)
+		}
+	}
+	if entries, _ := os.ReadDir(filepath.Join(ts.root, "firmware")); len(entries) != 1 {
+		t.Errorf("read-only root changed: %v", entries)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+// Data connections
+//
+// Like RFC 959 FTP, commands and replies travel on the control connection
+// while file contents and listings use a separate data connection that is
+// opened for a single transfer and closed afterwards. The end of a listing
+// or download is simply the end of the data stream.
+//
+// Only passive mode is supported: the server listens, the client dials.
+//
+//	Control connection                     Data connection
+//
+//	C: EPSV
+//	S: 229 Entering Extended Passive Mode (|||50123|)
+//	                                       C: connect to server:50123
+//	C: RETR image.bin
+//	S: 150 Opening data connection for image.bin (1048576 bytes)
+//	                                       S: <file bytes>, then close
+//	S: 226 Transfer complete, sha256 <hex>
+//
+// PASV does the same for IPv4 clients with the classic
+// "227 Entering Passive Mode (h1,h2,h3,h4,p1,p2)" reply. The listener
+// accepts one connection, only from the control connection's IP address,
+// so another host cannot steal the transfer.
+//
+// With TLS enabled, "PBSZ 0" followed by "PROT P" makes the data
+// connections use TLS too (RFC 4217); "PROT C" switches back to plaintext.
+
+// dataTimeout bounds how long the server waits for the client to dial in.
+const dataTimeout = 10 * time.Second
+
+// errNoPassive is returned when a transfer is requested without PASV/EPSV.
+var errNoPassive = errors.New("use PASV or EPSV first")
+
+// passive handles PASV and EPSV: it opens a one-shot listener for the next
+// transfer and tells the client where to connect.
+//
+// Parameters:
+//   - c: Client connection
+//   - extended: true for EPSV (RFC 2428), false for PASV
+func passive(c *client, extended bool) {
+	c.closePassive()
+
+	local, ok := c.conn.LocalAddr().(*net.TCPAddr)
+	if !ok {
+		c.reply(StatusCannotOpenData, "Control connection is not TCP")
+		return
+	}
+	if !extended && local.IP.To4() == nil {
+		c.reply(StatusNotImplementedParam, "PASV needs IPv4, use EPSV")
+		return
+	}
+
+	// Listen on the address the client already reached us on
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: local.IP})
+	if err != nil {
+		c.replyError(PASV, err)
+		return
+	}
+	c.pasv = ln
+
+	port := ln.Addr().(*net.TCPAddr).Port
+	if extended {
+		c.reply(StatusExtendedPassive, "Entering Extended Passive Mode (|||%d|)", port)
+		return
+	}
+
+	ip := local.IP.To4()
+	c.reply(StatusPassive, "Entering Passive Mode (%d,%d,%d,%d,%d,%d)",
+		ip[0], ip[1], ip[2], ip[3], port>>8, port&0xff)
+}
+
+// openData accepts the client's data connection on the passive listener
+// and announces the transfer with a 150 reply built from format and args.
+// With PROT P the TLS handshake follows the 150 reply, since clients only
+// start it once they know the transfer was accepted. The listener is
+// closed afterwards; every transfer needs a new PASV/EPSV.
+func (c *client) openData(format string, args ...any) (net.Conn, error) {
+	ln := c.pasv
+	if ln == nil {
+		return nil, errNoPassive
+	}
+	defer c.closePassive()
+
+	ln.SetDeadline(time.Now().Add(dataTimeout))
+	want := c.conn.RemoteAddr().(*net.TCPAddr).IP
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		// Reject connections from anyone but the control client
+		got := conn.RemoteAddr().(*net.TCPAddr).IP
+		if !got.Equal(want) {
+			log.Printf("Rejected data connection from %s (expected %s)", got, want)
+			conn.Close()
+			continue
+		}
+
+		c.reply(StatusTransferStarting, format, args...)
+		if c.srv.idleTimeout > 0 {
+			conn = idleConn{conn, c.srv.idleTimeout}
+		}
+		if !c.protect {
+			return conn, nil
+		}
+
+		tlsConn := tls.Server(conn, c.srv.tlsConfig)
+		tlsConn.SetDeadline(time.Now().Add(dataTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		tlsConn.SetDeadline(time.Time{})
+		return tlsConn, nil
+	}
+}
+
+// closePassive drops a pending passive listener, if any.
+func (c *client) closePassive() {
+	if c.pasv != nil {
+		c.pasv.Close()
+		c.pasv = nil
+	}
+}
+
+// protectionBufferSize handles PBSZ.
+func protectionBufferSize(c *client) {
+	if !c.secure {
+		c.reply(StatusBadSequence, "PBSZ requires AUTH TLS first")
+		return
+	}
+
+	// TLS has no protection buffer; any value is answered with PBSZ=0
+	c.pbsz = true
+	c.reply(StatusOK, "PBSZ=0")
+}
+
+// protection handles PROT: C (clear) or P (private, TLS) data connections.
+func protection(c *client, arg string) {
+	if !c.pbsz {
+		c.reply(StatusBadSequence, "PROT requires PBSZ first")
+		return
+	}
+
+	switch strings.ToUpper(arg) {
+	case "C":
+		c.protect = false
+		c.reply(StatusOK, "Data connections are clear")
+	case "P":
+		c.protect = true
+		c.reply(StatusOK, "Data connections are protected")
+	default:
+		c.reply(StatusNotImplementedParam, "PROT %s not supported, use C or P", arg)
+	}
+}
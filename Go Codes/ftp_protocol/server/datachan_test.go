@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ftpClient is the part of a net/ftp style client the tests need: it
+// speaks only standard RFC 959 commands and parses the PASV and EPSV
+// replies the way FTP clients do.
+type ftpClient struct {
+	t    *testing.T
+	conn net.Conn
+	text *textproto.Conn
+}
+
+// dialFTP connects, reads the greeting and logs in as name.
+func dialFTP(t *testing.T, addr, name, password string) *ftpClient {
+	t.Helper()
+	conn, text := dialConn(t, addr, StatusReady)
+	fc := &ftpClient{t: t, conn: conn, text: text}
+
+	code, _ := fc.cmd("USER %s", name)
+	if code == StatusNeedPassword {
+		code, _ = fc.cmd("PASS %s", password)
+	}
+	if code != StatusLoggedIn {
+		t.Fatalf("login as %s: %d", name, code)
+	}
+	return fc
+}
+
+// cmd sends a command and returns the reply, which may span several lines.
+func (fc *ftpClient) cmd(format string, args ...any) (int, string) {
+	fc.t.Helper()
+	id, err := fc.text.Cmd(format, args...)
+	if err != nil {
+		fc.t.Fatal(err)
+	}
+	fc.text.StartResponse(id)
+	defer fc.text.EndResponse(id)
+	code, msg, err := fc.text.ReadResponse(0)
+	if err != nil {
+		fc.t.Fatal(err)
+	}
+	return code, msg
+}
+
+// pasv opens a data connection with PASV.
+func (fc *ftpClient) pasv() net.Conn {
+	fc.t.Helper()
+	code, msg := fc.cmd("PASV")
+	if code != StatusPassive {
+		fc.t.Fatalf("PASV: %d %s", code, msg)
+	}
+	start, end := strings.Index(msg, "("), strings.LastIndex(msg, ")")
+	var h1, h2, h3, h4, p1, p2 int
+	if _, err := fmt.Sscanf(msg[start+1:end], "%d,%d,%d,%d,%d,%d", &h1, &h2, &h3, &h4, &p1, &p2); err != nil {
+		fc.t.Fatalf("PASV reply %q: %v", msg, err)
+	}
+	return fc.dialData(fmt.Sprintf("%d.%d.%d.%d:%d", h1, h2, h3, h4, p1<<8|p2))
+}
+
+// epsv opens a data connection with EPSV, on the control connection's host.
+func (fc *ftpClient) epsv() net.Conn {
+	fc.t.Helper()
+	code, msg := fc.cmd("EPSV")
+	if code != StatusExtendedPassive {
+		fc.t.Fatalf("EPSV: %d %s", code, msg)
+	}
+	start, end := strings.Index(msg, "(|||"), strings.LastIndex(msg, "|)")
+	if start < 0 || end < start {
+		fc.t.Fatalf("EPSV reply %q", msg)
+	}
+	host, _, _ := net.SplitHostPort(fc.conn.RemoteAddr().String())
+	return fc.dialData(net.JoinHostPort(host, msg[start+4:end]))
+}
+
+// dialData connects to the announced data port.
+func (fc *ftpClient) dialData(addr string) net.Conn {
+	fc.t.Helper()
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		fc.t.Fatal(err)
+	}
+	fc.t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// transfer runs a command that uses the data connection: it checks the
+// 150 reply, hands the data connection to use, closes it and returns the
+// final reply.
+func (fc *ftpClient) transfer(data net.Conn, line string, use func(net.Conn)) (int, string) {
+	fc.t.Helper()
+	if code, msg := fc.cmd("%s", line); code != StatusTransferStarting {
+		fc.t.Fatalf("%s: %d %s", line, code, msg)
+	}
+	use(data)
+	data.Close()
+	code, msg, err := fc.text.ReadResponse(0)
+	if err != nil {
+		fc.t.Fatal(err)
+	}
+	return code, msg
+}
+
+// TestFTPClient drives the server the way a standard FTP client does:
+// PASV and EPSV data connections for LIST, NLST, MLSD, RETR and STOR.
+func TestFTPClient(t *testing.T) {
+	ts := startServer(t, nil)
+	mkfile(t, filepath.Join(ts.root, "docs", "readme.txt"), "hello\r\nworld\n")
+	mkfile(t, filepath.Join(ts.root, "b.txt"), "bb")
+	fc := dialFTP(t, ts.addr, "anonymous", "guest")
+
+	if code, _ := fc.cmd("TYPE I"); code != StatusOK {
+		t.Errorf("TYPE I: %d", code)
+	}
+	if code, msg := fc.cmd("SYST"); code != StatusSystemType || !strings.HasPrefix(msg, "UNIX") {
+		t.Errorf("SYST: %d %s", code, msg)
+	}
+
+	var listing []byte
+	code, msg := fc.transfer(fc.pasv(), "LIST", func(c net.Conn) { listing, _ = io.ReadAll(c) })
+	if code != StatusTransferComplete || msg != "Transfer complete, 2 entries" {
+		t.Errorf("LIST: %d %s", code, msg)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(listing), "\r\n"), "\r\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "-") || !strings.HasSuffix(lines[0], " b.txt") ||
+		!strings.HasPrefix(lines[1], "d") || !strings.HasSuffix(lines[1], " docs") {
+		t.Errorf("LIST returned %q", listing)
+	}
+
+	fc.transfer(fc.epsv(), "NLST docs", func(c net.Conn) { listing, _ = io.ReadAll(c) })
+	if string(listing) != "readme.txt\r\n" {
+		t.Errorf("NLST returned %q", listing)
+	}
+	fc.transfer(fc.epsv(), "MLSD docs", func(c net.Conn) { listing, _ = io.ReadAll(c) })
+	if !strings.HasPrefix(string(listing), "type=file;size=13;") || !strings.HasSuffix(string(listing), "; readme.txt\r\n") {
+		t.Errorf("MLSD returned %q", listing)
+	}
+
+	var got []byte
+	code, msg = fc.transfer(fc.pasv(), "RETR docs/readme.txt", func(c net.Conn) { got, _ = io.ReadAll(c) })
+	if string(got) != "hello\r\nworld\n" || msg != "Transfer complete, sha256 "+sha256Hex(got) {
+		t.Errorf("RETR: %q, %d %s", got, code, msg)
+	}
+
+	upload := []byte(strings.Repeat("0123456789", 10000))
+	code, msg = fc.transfer(fc.epsv(), "STOR docs/up.bin", func(c net.Conn) { c.Write(upload) })
+	if code != StatusTransferComplete || msg != "Transfer complete, sha256 "+sha256Hex(upload) {
+		t.Errorf("STOR: %d %s", code, msg)
+	}
+	if stored := readFile(t, filepath.Join(ts.root, "docs", "up.bin")); stored != string(upload) {
+		t.Errorf("stored %d bytes, want %d", len(stored), len(upload))
+	}
+
+	// Every transfer needs its own PASV or EPSV
+	if code, _ := fc.cmd("RETR b.txt"); code != StatusCannotOpenData {
+		t.Errorf("RETR without PASV: %d, want %d", code, StatusCannotOpenData)
+	}
+	if code, _ := fc.cmd("QUIT"); code != StatusClosing {
+		t.Errorf("QUIT: %d", code)
+	}
+}
+
+// TestFramingFeat checks the multi-line FEAT reply.
+func TestFramingFeat(t *testing.T) {
+	ts := startServer(t, nil)
+	fc := dialFTP(t, ts.addr, "anonymous", "guest")
+
+	code, msg := fc.cmd("FEAT")
+	if code != StatusSystemStatus {
+		t.Fatalf("FEAT: %d", code)
+	}
+	lines := strings.Split(msg, "\n")
+	if lines[0] != "Features:" || lines[len(lines)-1] != "End" {
+		t.Errorf("FEAT reply %q", msg)
+	}
+	var got []string
+	for _, l := range lines[1 : len(lines)-1] {
+		got = append(got, strings.TrimPrefix(l, " "))
+	}
+	if !slices.Equal(got, features) {
+		t.Errorf("FEAT lists %q, want %q", got, features)
+	}
+
+	// The next reply is read from where FEAT ended
+	if code, _ := fc.cmd("NOOP"); code != StatusOK {
+		t.Errorf("NOOP after FEAT: %d", code)
+	}
+}
+
+// TestFramingSplit sends commands split across writes, pipelined in one
+// write and terminated with a bare LF; each gets exactly one reply.
+func TestFramingSplit(t *testing.T) {
+	ts := startServer(t, nil)
+	conn, text := dialConn(t, ts.addr, StatusReady)
+
+	for _, part := range []string{"MK", "D sp", "lit\r", "\n"} {
+		if _, err := conn.Write([]byte(part)); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	expect(t, text, StatusPathCreated, `"/split" created`)
+
+	if _, err := conn.Write([]byte("CWD split\r\nPWD\r\nNOOP\nCDUP\r\n\r\nPWD\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, text, StatusFileActionOK, "Directory changed to /split")
+	expect(t, text, StatusPathCreated, `"/split"`)
+	expect(t, text, StatusOK, "OK")
+	expect(t, text, StatusFileActionOK, "Directory changed to /")
+	expect(t, text, StatusPathCreated, `"/"`)
+
+	// The empty line got no reply: nothing else is waiting
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if line, err := text.ReadLine(); err == nil {
+		t.Errorf("unexpected reply %q", line)
+	}
+}
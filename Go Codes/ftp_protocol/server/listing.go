@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Directory listings
+//
+// Three commands list a directory over the data connection:
+//
+//	NLST   names only, one per line
+//	LIST   "ls -l" style lines for humans and classic FTP clients
+//	MLSD   machine readable facts (RFC 3659), one entry per line
+//
+// An MLSD line is a list of "fact=value;" pairs, a space and the name:
+//
+//	type=file;size=1048576;modify=20250114093015;UNIX.mode=0644; image.bin
+//	type=dir;size=4096;modify=20250110120000;UNIX.mode=0755; firmware
+//
+// The modify time is UTC in YYYYMMDDHHMMSS form. "MLST <path>" returns the
+// facts of a single entry on the control connection instead.
+//
+// All three accept "ls" style options before the path:
+//
+//	-S  sort by size, largest first
+//	-t  sort by modification time, newest first
+//	-r  reverse the order
+//
+// Without -S or -t entries are sorted by name. The last path element may
+// be a glob pattern (path.Match syntax), e.g. "LIST -S logs/*.txt".
+// Other options such as "-la" are accepted and ignored.
+
+// listOptions is the parsed argument of LIST, NLST and MLSD.
+type listOptions struct {
+	dir     string // Directory to list
+	pattern string // Glob on the entry names, "" for all
+	sortBy  byte   // 'n' name, 'S' size, 't' time
+	reverse bool
+}
+
+// parseListArgs splits options and path of a listing command. The
+// options come first; the rest of the line is the path, spaces included.
+func parseListArgs(arg string) (listOptions, error) {
+	opts := listOptions{dir: ".", sortBy: 'n'}
+
+	for {
+		arg = strings.TrimLeft(arg, " ")
+		f, rest, _ := strings.Cut(arg, " ")
+		if !strings.HasPrefix(f, "-") || f == "-" {
+			break
+		}
+		for _, flag := range f[1:] {
+			switch flag {
+			case 'S', 't':
+				opts.sortBy = byte(flag)
+			case 'r':
+				opts.reverse = true
+			}
+		}
+		arg = rest
+	}
+	if arg == "" {
+		return opts, nil
+	}
+
+	// A glob in the last element filters the entries of its directory
+	dir, base := path.Split(arg)
+	if strings.ContainsAny(base, "*?[") {
+		if _, err := path.Match(base, ""); err != nil {
+			return opts, fmt.Errorf("invalid pattern %q", base)
+		}
+		opts.pattern = base
+		if dir != "" {
+			opts.dir = dir
+		}
+		return opts, nil
+	}
+
+	opts.dir = arg
+	return opts, nil
+}
+
+// readListing reads, filters and sorts the entries of opts.dir.
+func readListing(sess *Session, opts listOptions) ([]fs.FileInfo, error) {
+	info, err := sess.Stat(opts.dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, &fs.PathError{Op: "list", Path: opts.dir, Err: errNotDir}
+	}
+
+	dir, err := sess.Open(opts.dir)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	all, err := dir.Readdir(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := all[:0]
+	for _, e := range all {
+		if opts.pattern != "" {
+			if ok, _ := path.Match(opts.pattern, e.Name()); !ok {
+				continue
+			}
+		}
+		entries = append(entries, e)
+	}
+
+	slices.SortFunc(entries, func(a, b fs.FileInfo) int {
+		var n int
+		switch opts.sortBy {
+		case 'S':
+			n = cmp.Compare(b.Size(), a.Size())
+		case 't':
+			n = b.ModTime().Compare(a.ModTime())
+		}
+		if n == 0 {
+			n = strings.Compare(a.Name(), b.Name())
+		}
+		if opts.reverse {
+			n = -n
+		}
+		return n
+	})
+
+	return entries, nil
+}
+
+// entryType returns the RFC 3659 type fact.
+func entryType(info fs.FileInfo) string {
+	switch {
+	case info.IsDir():
+		return "dir"
+	case info.Mode()&fs.ModeSymlink != 0:
+		return "OS.unix=symlink"
+	case info.Mode().IsRegular():
+		return "file"
+	}
+	return "OS.unix=special"
+}
+
+// mlsxFacts formats one entry as an MLSD/MLST line.
+func mlsxFacts(info fs.FileInfo, name string) string {
+	return fmt.Sprintf("type=%s;size=%d;modify=%s;UNIX.mode=%04o; %s",
+		entryType(info), info.Size(), info.ModTime().UTC().Format("20060102150405"),
+		info.Mode().Perm(), name)
+}
+
+// lsLine formats one entry like "ls -l". Recent files show the time,
+// older ones the year, as ls does.
+func lsLine(info fs.FileInfo, now time.Time) string {
+	stamp := "Jan _2 15:04"
+	if mod := info.ModTime(); now.Sub(mod) > 180*24*time.Hour || mod.After(now) {
+		stamp = "Jan _2  2006"
+	}
+
+	return fmt.Sprintf("%s 1 ftp ftp %12d %s %s",
+		info.Mode().String(), info.Size(), info.ModTime().Format(stamp), info.Name())
+}
+
+// listDirectory handles LIST, NLST and MLSD. The listing is sent over the
+// data connection, one CRLF terminated line per entry, and ends when the
+// data connection is closed; the 226 reply on the control connection
+// confirms it.
+//
+// Parameters:
+//   - c: Client connection and session
+//   - command: LIST, NLST or MLSD, selects the line format
+//   - arg: Options and path, see parseListArgs
+func listDirectory(c *client, command, arg string) {
+	opts, err := parseListArgs(arg)
+	if err != nil {
+		c.reply(StatusArgumentError, "%v", err)
+		return
+	}
+
+	entries, err := readListing(c.sess, opts)
+	if err != nil {
+		c.replyError(command, err)
+		return
+	}
+
+	virtual, _, _ := c.sess.Resolve(opts.dir)
+	data, err := c.openData("Opening data connection for %s", virtual)
+	if err != nil {
+		c.reply(StatusCannotOpenData, "Cannot open data connection: %v", err)
+		return
+	}
+
+	now := time.Now()
+	w := bufio.NewWriter(data)
+	for _, info := range entries {
+		switch command {
+		case MLSD:
+			fmt.Fprintf(w, "%s\r\n", mlsxFacts(info, info.Name()))
+		case LIST:
+			fmt.Fprintf(w, "%s\r\n", lsLine(info, now))
+		default:
+			fmt.Fprintf(w, "%s\r\n", info.Name())
+		}
+	}
+	err = w.Flush()
+	if closeErr := data.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		c.reply(StatusTransferAborted, "Listing aborted: %v", err)
+		return
+	}
+
+	c.reply(StatusTransferComplete, "Transfer complete, %d entries", len(entries))
+}
+
+// listEntry handles MLST: the facts of one file or directory, sent as a
+// multi-line reply on the control connection.
+//
+// Parameters:
+//   - c: Client connection and session
+//   - p: Path to describe, the working directory if empty
+func listEntry(c *client, p string) {
+	if p == "" {
+		p = "."
+	}
+
+	info, err := c.sess.Stat(p)
+	if err != nil {
+		c.replyError(MLST, err)
+		return
+	}
+
+	virtual, _, _ := c.sess.Resolve(p)
+	c.replyLines(StatusFileActionOK, "Listing "+virtual, []string{mlsxFacts(info, virtual)}, "End")
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseListArgs(t *testing.T) {
+	for _, tc := range []struct {
+		arg  string
+		want listOptions // zero when the argument must be rejected
+	}{
+		{"", listOptions{dir: ".", sortBy: 'n'}},
+		{"-la", listOptions{dir: ".", sortBy: 'n'}},
+		{"-S -r", listOptions{dir: ".", sortBy: 'S', reverse: true}},
+		{"-lt docs", listOptions{dir: "docs", sortBy: 't'}},
+		{"docs/*.txt", listOptions{dir: "docs/", pattern: "*.txt", sortBy: 'n'}},
+		{"-Sr *.bin", listOptions{dir: ".", pattern: "*.bin", sortBy: 'S', reverse: true}},
+		{"my  docs", listOptions{dir: "my  docs", sortBy: 'n'}},
+		{"-t my docs/a?c", listOptions{dir: "my docs/", pattern: "a?c", sortBy: 't'}},
+		{"docs -S", listOptions{dir: "docs -S", sortBy: 'n'}},
+		{"-", listOptions{dir: "-", sortBy: 'n'}},
+		{"docs/[", listOptions{}},
+	} {
+		got, err := parseListArgs(tc.arg)
+		if tc.want == (listOptions{}) {
+			if err == nil {
+				t.Errorf("parseListArgs(%q) = %+v, want an error", tc.arg, got)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("parseListArgs(%q) = %+v, %v, want %+v", tc.arg, got, err, tc.want)
+		}
+	}
+}
+
+// listingTree creates files/ below root with entries of distinct sizes
+// and modification times: b.bin is the largest and newest, c.txt the
+// smallest, a.txt the oldest.
+func listingTree(t *testing.T, root string) {
+	t.Helper()
+	now := time.Now()
+	for _, f := range []struct {
+		name string
+		size int
+		age  time.Duration
+	}{
+		{"a.txt", 3, 3 * time.Hour},
+		{"b.bin", 10, time.Hour},
+		{"c.txt", 1, 2 * time.Hour},
+	} {
+		name := filepath.Join(root, "files", f.name)
+		mkfile(t, name, strings.Repeat("x", f.size))
+		if err := os.Chtimes(name, now, now.Add(-f.age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReadListing(t *testing.T) {
+	root := t.TempDir()
+	listingTree(t, root)
+	sess := newTestSession(t, root)
+
+	for _, tc := range []struct {
+		arg  string
+		want []string
+	}{
+		{"files", []string{"a.txt", "b.bin", "c.txt"}},
+		{"-r files", []string{"c.txt", "b.bin", "a.txt"}},
+		{"-S files", []string{"b.bin", "a.txt", "c.txt"}},
+		{"-Sr files", []string{"c.txt", "a.txt", "b.bin"}},
+		{"-t files", []string{"b.bin", "c.txt", "a.txt"}},
+		{"-l -t -r files", []string{"a.txt", "c.txt", "b.bin"}},
+		{"files/*.txt", []string{"a.txt", "c.txt"}},
+		{"-S files/*.txt", []string{"a.txt", "c.txt"}},
+		{"files/?.bin", []string{"b.bin"}},
+		{"files/*.none", nil},
+	} {
+		opts, err := parseListArgs(tc.arg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries, err := readListing(sess, opts)
+		if err != nil {
+			t.Errorf("%q: %v", tc.arg, err)
+			continue
+		}
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		if !slices.Equal(names, tc.want) {
+			t.Errorf("%q lists %q, want %q", tc.arg, names, tc.want)
+		}
+	}
+
+	for arg, want := range map[string]error{
+		"files/a.txt": errNotDir,
+		"missing":     os.ErrNotExist,
+		"../":         ErrOutsideRoot,
+	} {
+		opts, _ := parseListArgs(arg)
+		if _, err := readListing(sess, opts); !errors.Is(err, want) {
+			t.Errorf("%q: %v, want %v", arg, err, want)
+		}
+	}
+}
+
+func TestListingLines(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "my file.txt")
+	mkfile(t, name, "abc")
+	if err := os.Chmod(name, 0o640); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.Local)
+
+	for _, tc := range []struct {
+		mod   time.Time
+		stamp string
+	}{
+		{now.Add(-24 * time.Hour), "May 31 12:00"},
+		{time.Date(2024, 11, 3, 8, 30, 0, 0, time.Local), "Nov  3  2024"},
+		{now.Add(time.Hour), "Jun  1  2025"}, // in the future
+	} {
+		if err := os.Chtimes(name, tc.mod, tc.mod); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "-rw-r----- 1 ftp ftp            3 " + tc.stamp + " my file.txt"
+		if got := lsLine(info, now); got != want {
+			t.Errorf("lsLine =\n %q, want\n %q", got, want)
+		}
+		want = "type=file;size=3;modify=" + tc.mod.UTC().Format("20060102150405") + ";UNIX.mode=0640; my file.txt"
+		if got := mlsxFacts(info, info.Name()); got != want {
+			t.Errorf("mlsxFacts = %q, want %q", got, want)
+		}
+	}
+}
+
+// TestListCommands runs the listing options over a real data connection.
+func TestListCommands(t *testing.T) {
+	ts := startServer(t, nil)
+	listingTree(t, ts.root)
+	mkfile(t, filepath.Join(ts.root, "my  docs", "inside.txt"), "x")
+	fc := dialFTP(t, ts.addr, "anonymous", "guest")
+
+	list := func(line string) []string {
+		t.Helper()
+		var data []byte
+		code, msg := fc.transfer(fc.epsv(), line, func(c net.Conn) { data, _ = io.ReadAll(c) })
+		if code != StatusTransferComplete {
+			t.Errorf("%s: %d %s", line, code, msg)
+		}
+		return strings.Split(strings.TrimSuffix(string(data), "\r\n"), "\r\n")
+	}
+
+	if got := list("NLST -S files"); !slices.Equal(got, []string{"b.bin", "a.txt", "c.txt"}) {
+		t.Errorf("NLST -S lists %q", got)
+	}
+	if got := list("NLST -t -r files/*.txt"); !slices.Equal(got, []string{"a.txt", "c.txt"}) {
+		t.Errorf("NLST -t -r lists %q", got)
+	}
+	lines := list("LIST -lSr files")
+	if len(lines) != 3 || !strings.HasSuffix(lines[0], " c.txt") || !strings.HasSuffix(lines[2], " b.bin") {
+		t.Errorf("LIST -lSr lists %q", lines)
+	}
+	lines = list("MLSD -t files/*.bin")
+	if len(lines) != 1 || !strings.HasPrefix(lines[0], "type=file;size=10;") || !strings.HasSuffix(lines[0], "; b.bin") {
+		t.Errorf("MLSD lists %q", lines)
+	}
+	if got := list("NLST my  docs"); !slices.Equal(got, []string{"inside.txt"}) {
+		t.Errorf("NLST of a name with spaces lists %q", got)
+	}
+
+	code, msg := fc.cmd("MLST files/a.txt")
+	if lines := strings.Split(msg, "\n"); code != StatusFileActionOK || len(lines) != 3 ||
+		lines[0] != "Listing /files/a.txt" || !strings.HasSuffix(lines[1], "; /files/a.txt") || lines[2] != "End" {
+		t.Errorf("MLST: %d %q", code, msg)
+	}
+	if code, _ := fc.cmd("LIST files/["); code != StatusArgumentError {
+		t.Errorf("LIST with a bad pattern: %d", code)
+	}
+	if code, _ := fc.cmd("NLST files/a.txt"); code != StatusFileUnavailable {
+		t.Errorf("NLST of a file: %d", code)
+	}
+}
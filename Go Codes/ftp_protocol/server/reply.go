@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"net/textproto"
+	"strings"
+)
+
+// Reply codes, following the FTP (RFC 959) numbering scheme:
+//
+//	1xx  positive preliminary - another reply follows (transfer started)
+//	2xx  positive completion  - the command succeeded
+//	4xx  transient failure    - the command may succeed if retried
+//	5xx  permanent failure    - the command will not succeed as sent
+const (
+	StatusTransferStarting    = 150 // Data connection is being used
+	StatusOK                  = 200 // Command okay
+	StatusSystemStatus        = 211 // System status (FEAT)
+	StatusFileStatus          = 213 // File status (SIZE, HASH)
+	StatusSystemType          = 215 // System type (SYST)
+	StatusReady               = 220 // Service ready for new user
+	StatusClosing             = 221 // Closing control connection
+	StatusTransferComplete    = 226 // Transfer finished, checksum attached
+	StatusPassive             = 227 // Entering passive mode (PASV)
+	StatusExtendedPassive     = 229 // Entering extended passive mode (EPSV)
+	StatusLoggedIn            = 230 // User logged in
+	StatusAuthOK              = 234 // AUTH TLS accepted, handshake follows
+	StatusFileActionOK        = 250 // Requested file action completed
+	StatusPathCreated         = 257 // Path name reply (PWD, MKDIR)
+	StatusNeedPassword        = 331 // User name okay, need password
+	StatusPendingInfo         = 350 // Waiting for the next command (REST)
+	StatusServiceUnavailable  = 421 // Server is shutting the connection down
+	StatusCannotOpenData      = 425 // No data connection
+	StatusTransferAborted     = 426 // Data connection broke during transfer
+	StatusLocalError          = 451 // Server side I/O error
+	StatusSyntaxError         = 500 // Unknown command
+	StatusArgumentError       = 501 // Wrong number or format of arguments
+	StatusNotImplemented      = 502 // Command not enabled on this server
+	StatusBadSequence         = 503 // Command out of order (PASS before USER)
+	StatusNotImplementedParam = 504 // Unsupported parameter (AUTH SSL)
+	StatusNotLoggedIn         = 530 // Login required or failed
+	StatusFileUnavailable     = 550 // File not found, not a directory, ...
+	StatusNameNotAllowed      = 553 // Path escapes the root
+)
+
+// client bundles everything a command handler needs for one connection.
+type client struct {
+	srv  *server         // Shared server configuration
+	raw  net.Conn        // Accepted connection, for shutdown
+	conn net.Conn        // Current connection (TLS after AUTH), for addresses and deadlines
+	text *textproto.Conn // Line oriented reader/writer over conn
+	sess *Session        // Working directory inside the root, nil until login
+
+	secure   bool       // Control connection runs over TLS
+	userName string     // Name from USER, waiting for PASS
+	user     *User      // Logged in account, nil for open access
+	perm     Permission // What the session may do
+
+	pasv    *net.TCPListener // Pending passive listener (PASV/EPSV)
+	pbsz    bool             // PBSZ received, PROT allowed
+	protect bool             // PROT P: data connections use TLS
+
+	restart    int64  // Offset armed by REST for the next RETR or STOR
+	allocate   int64  // Size announced by ALLO, -1 if none
+	renameFrom string // Source path armed by RNFR
+}
+
+// newClient wraps an accepted connection. Without a users file the
+// session starts immediately in the shared root with full access.
+func newClient(srv *server, conn net.Conn) *client {
+	c := &client{
+		srv:      srv,
+		raw:      conn,
+		conn:     conn,
+		text:     textproto.NewConn(conn),
+		allocate: -1,
+	}
+
+	if _, ok := conn.(*tls.Conn); ok {
+		c.secure = true
+	}
+	if srv.users == nil {
+		c.sess = NewSession(srv.sandbox)
+		c.perm = ReadWrite
+	}
+
+	return c
+}
+
+// reply sends a single line reply: "<code> <text>\r\n".
+func (c *client) reply(code int, format string, args ...any) error {
+	return c.text.PrintfLine("%d %s", code, fmt.Sprintf(format, args...))
+}
+
+// replyLines sends a multi-line reply:
+//
+//	<code>-<first>
+//	 <line>
+//	 ...
+//	<code> <last>
+//
+// Body lines are indented by one space, as RFC 959 requires, so a line
+// that happens to start with digits can never be mistaken for the end of
+// the reply. textproto.Reader.ReadResponse understands this format.
+func (c *client) replyLines(code int, first string, lines []string, last string) error {
+	w := c.text.Writer.W
+
+	fmt.Fprintf(w, "%d-%s\r\n", code, first)
+	for _, line := range lines {
+		fmt.Fprintf(w, " %s\r\n", line)
+	}
+	fmt.Fprintf(w, "%d %s\r\n", code, last)
+
+	return w.Flush()
+}
+
+// replyError logs a failed command and maps the error to a reply code.
+func (c *client) replyError(command string, err error) {
+	log.Printf("%s error from %s: %v", command, c.conn.RemoteAddr(), err)
+
+	code := StatusLocalError
+	switch {
+	case errors.Is(err, ErrOutsideRoot):
+		code = StatusNameNotAllowed
+	case errors.Is(err, fs.ErrNotExist),
+		errors.Is(err, fs.ErrExist),
+		errors.Is(err, fs.ErrPermission),
+		errors.Is(err, errNotDir),
+		errors.Is(err, errIsDir):
+		code = StatusFileUnavailable
+	}
+
+	c.reply(code, "%s", oneLine(err.Error()))
+}
+
+// oneLine keeps error text from breaking the reply framing.
+func oneLine(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+)
+
+// ErrOutsideRoot is returned for paths that would leave the session root.
+var ErrOutsideRoot = errors.New("path escapes the server root")
+
+// Path kind errors wrapped in *fs.PathError by the session helpers.
+var (
+	errNotDir = errors.New("not a directory")
+	errIsDir  = errors.New("is a directory")
+)
+
+// Sandbox confines file system access to a single root directory.
+//
+// All access goes through an *os.Root, so ".." components and symbolic
+// links that point outside the root are rejected by the operating system
+// layer, not just by string checks.
+type Sandbox struct {
+	root *os.Root
+	dir  string // Real directory backing the root (for logging only)
+}
+
+// OpenSandbox opens dir as the root of a sandbox.
+func OpenSandbox(dir string) (*Sandbox, error) {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Sandbox{root: root, dir: dir}, nil
+}
+
+// Close releases the root directory handle.
+func (s *Sandbox) Close() error {
+	return s.root.Close()
+}
+
+// Session holds the per-client virtual working directory.
+//
+// The working directory is a slash-separated path that always starts with
+// "/", where "/" is the sandbox root. Clients never see real server paths.
+type Session struct {
+	fs  *Sandbox
+	cwd string
+}
+
+// NewSession starts a session at the sandbox root.
+func NewSession(fs *Sandbox) *Session {
+	return &Session{fs: fs, cwd: "/"}
+}
+
+// Pwd returns the virtual working directory.
+func (s *Session) Pwd() string {
+	return s.cwd
+}
+
+// Resolve turns a client supplied path into a virtual absolute path and
+// the relative name used with os.Root.
+//
+// Paths are interpreted relative to the working directory unless they
+// start with "/". A path that climbs above "/" is rejected rather than
+// silently clamped, so "CD ../../etc" fails instead of landing in the root.
+func (s *Session) Resolve(p string) (virtual, name string, err error) {
+	p = strings.ReplaceAll(p, "\\", "/")
+
+	// Join relative to the root (no leading "/"), otherwise path.Clean
+	// would swallow the extra ".." elements instead of exposing them.
+	rel := strings.TrimLeft(p, "/")
+	if !path.IsAbs(p) {
+		rel = path.Join(strings.TrimLeft(s.cwd, "/"), p)
+	}
+	rel = path.Clean(rel)
+
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", "", ErrOutsideRoot
+	}
+	if rel == "" {
+		rel = "."
+	}
+
+	return path.Clean("/" + rel), rel, nil
+}
+
+// Chdir changes the session working directory after checking that the
+// target exists inside the root and is a directory.
+func (s *Session) Chdir(p string) error {
+	virtual, name, err := s.Resolve(p)
+	if err != nil {
+		return err
+	}
+
+	info, err := s.fs.root.Stat(name)
+	if err != nil {
+		return sanitize(err, virtual)
+	}
+	if !info.IsDir() {
+		return &fs.PathError{Op: "cd", Path: virtual, Err: errNotDir}
+	}
+
+	s.cwd = virtual
+	return nil
+}
+
+// Open opens a file or directory relative to the session.
+func (s *Session) Open(p string) (*os.File, error) {
+	virtual, name, err := s.Resolve(p)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := s.fs.root.Open(name)
+	if err != nil {
+		return nil, sanitize(err, virtual)
+	}
+	return f, nil
+}
+
+// sanitize replaces the real path in file system errors with the virtual
+// one so that server paths do not leak to clients.
+func sanitize(err error, virtual string) error {
+	if err == nil {
+		return nil
+	}
+
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		if strings.Contains(pe.Err.Error(), "escapes from parent") {
+			return ErrOutsideRoot
+		}
+		return &fs.PathError{Op: pe.Op, Path: virtual, Err: pe.Err}
+	}
+
+	var le *os.LinkError
+	if errors.As(err, &le) {
+		if strings.Contains(le.Err.Error(), "escapes from parent") {
+			return ErrOutsideRoot
+		}
+		return &fs.PathError{Op: le.Op, Path: virtual, Err: le.Err}
+	}
+
+	return err
+}
+
+// Stat returns file information for a path relative to the session.
+func (s *Session) Stat(p string) (fs.FileInfo, error) {
+	virtual, name, err := s.Resolve(p)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := s.fs.root.Stat(name)
+	if err != nil {
+		return nil, sanitize(err, virtual)
+	}
+	return info, nil
+}
+
+// Create opens a file for writing, creating or truncating it.
+func (s *Session) Create(p string) (*os.File, error) {
+	return s.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+}
+
+// OpenFile is the generalized open call, like os.OpenFile.
+func (s *Session) OpenFile(p string, flag int, perm os.FileMode) (*os.File, error) {
+	virtual, name, err := s.Resolve(p)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := s.fs.root.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, sanitize(err, virtual)
+	}
+	return f, nil
+}
+
+// Remove deletes a file or an empty directory.
+func (s *Session) Remove(p string) error {
+	virtual, name, err := s.Resolve(p)
+	if err != nil {
+		return err
+	}
+	if name == "." {
+		return &fs.PathError{Op: "remove", Path: virtual, Err: fs.ErrPermission}
+	}
+
+	return sanitize(s.fs.root.Remove(name), virtual)
+}
+
+// Mkdir creates a single directory.
+func (s *Session) Mkdir(p string) error {
+	virtual, name, err := s.Resolve(p)
+	if err != nil {
+		return err
+	}
+
+	return sanitize(s.fs.root.Mkdir(name, 0o755), virtual)
+}
+
+// Rename moves a file or directory; both paths must stay inside the root.
+func (s *Session) Rename(from, to string) error {
+	fromVirtual, fromName, err := s.Resolve(from)
+	if err != nil {
+		return err
+	}
+	_, toName, err := s.Resolve(to)
+	if err != nil {
+		return err
+	}
+	if fromName == "." || toName == "." {
+		return &fs.PathError{Op: "rename", Path: fromVirtual, Err: fs.ErrPermission}
+	}
+
+	return sanitize(s.fs.root.Rename(fromName, toName), fromVirtual)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestSession opens dir as a sandbox and starts a session in it.
+func newTestSession(t *testing.T, dir string) *Session {
+	t.Helper()
+	sandbox, err := OpenSandbox(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sandbox.Close() })
+	return NewSession(sandbox)
+}
+
+// mkfile creates a file with the given content, and its parent
+// directories.
+func mkfile(t *testing.T, name, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	sess := newTestSession(t, t.TempDir())
+	for _, tc := range []struct {
+		cwd, p        string
+		virtual, name string // both empty when the path must be rejected
+	}{
+		{"/", "a", "/a", "a"},
+		{"/", "", "/", "."},
+		{"/a/b", "..", "/a", "a"},
+		{"/a/b", "../..", "/", "."},
+		{"/a", "./b/../c", "/a/c", "a/c"},
+		{"/a", `b\c`, "/a/b/c", "a/b/c"},
+		{"/a", "my file.txt", "/a/my file.txt", "a/my file.txt"},
+
+		// Absolute paths start at the root, not at the real "/"
+		{"/a", "/b", "/b", "b"},
+		{"/a", "/etc/passwd", "/etc/passwd", "etc/passwd"},
+		{"/a", "//b", "/b", "b"},
+
+		// Climbing above the root is refused, not clamped
+		{"/", "..", "", ""},
+		{"/", "../etc", "", ""},
+		{"/a", "../..", "", ""},
+		{"/a/b", "../../../etc/passwd", "", ""},
+		{"/", "/../etc", "", ""},
+		{"/a", `..\..\etc`, "", ""},
+	} {
+		sess.cwd = tc.cwd
+		virtual, name, err := sess.Resolve(tc.p)
+		if tc.virtual == "" {
+			if !errors.Is(err, ErrOutsideRoot) {
+				t.Errorf("Resolve(%q) in %s = %q, %q, %v, want ErrOutsideRoot", tc.p, tc.cwd, virtual, name, err)
+			}
+			continue
+		}
+		if err != nil || virtual != tc.virtual || name != tc.name {
+			t.Errorf("Resolve(%q) in %s = %q, %q, %v, want %q, %q", tc.p, tc.cwd, virtual, name, err, tc.virtual, tc.name)
+		}
+	}
+}
+
+// TestSymlinkOutside checks that links leading out of the root can be
+// neither followed nor used as a directory, while links inside it work.
+func TestSymlinkOutside(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "root")
+	outside := filepath.Join(base, "outside")
+	mkfile(t, filepath.Join(outside, "secret.txt"), "secret")
+	mkfile(t, filepath.Join(root, "sub", "inside.txt"), "inside")
+	for link, target := range map[string]string{
+		"out":        outside,
+		"secret":     filepath.Join(outside, "secret.txt"),
+		"sub/up":     filepath.Join("..", ".."),
+		"sub/inlink": "inside.txt",
+	} {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Skipf("cannot create symbolic links: %v", err)
+		}
+	}
+	sess := newTestSession(t, root)
+
+	for _, p := range []string{"secret", "out/secret.txt", "sub/up/outside/secret.txt"} {
+		if f, err := sess.Open(p); !errors.Is(err, ErrOutsideRoot) {
+			if err == nil {
+				f.Close()
+			}
+			t.Errorf("Open(%q) = %v, want ErrOutsideRoot", p, err)
+		}
+		if _, err := sess.Stat(p); !errors.Is(err, ErrOutsideRoot) {
+			t.Errorf("Stat(%q) = %v, want ErrOutsideRoot", p, err)
+		}
+	}
+	for _, p := range []string{"out", "sub/up"} {
+		if err := sess.Chdir(p); !errors.Is(err, ErrOutsideRoot) {
+			t.Errorf("Chdir(%q) = %v, want ErrOutsideRoot", p, err)
+		}
+		if sess.Pwd() != "/" {
+			t.Fatalf("Pwd = %s after a refused Chdir", sess.Pwd())
+		}
+	}
+	if _, err := sess.Create("out/new.txt"); !errors.Is(err, ErrOutsideRoot) {
+		t.Errorf("Create through a link = %v, want ErrOutsideRoot", err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "new.txt")); err == nil {
+		t.Error("file created outside the root")
+	}
+
+	f, err := sess.Open("sub/inlink")
+	if err != nil {
+		t.Fatalf("link inside the root: %v", err)
+	}
+	got, _ := io.ReadAll(f)
+	f.Close()
+	if string(got) != "inside" {
+		t.Errorf("read %q through the link, want inside", got)
+	}
+}
+
+// TestSessionsIsolated gives two sessions different roots, as the users
+// file does, and checks that neither can reach the other's files.
+func TestSessionsIsolated(t *testing.T) {
+	base := t.TempDir()
+	alice := filepath.Join(base, "alice")
+	bob := filepath.Join(base, "bob")
+	mkfile(t, filepath.Join(alice, "a.txt"), "alice")
+	mkfile(t, filepath.Join(bob, "b.txt"), "bob")
+
+	sa := newTestSession(t, alice)
+	sb := newTestSession(t, bob)
+
+	for _, p := range []string{"b.txt", "/b.txt", "../bob/b.txt"} {
+		if _, err := sa.Stat(p); err == nil {
+			t.Errorf("alice sees %q", p)
+		}
+	}
+	for _, p := range []string{"a.txt", "/a.txt", "../alice/a.txt"} {
+		if _, err := sb.Stat(p); err == nil {
+			t.Errorf("bob sees %q", p)
+		}
+	}
+
+	// Working directories are per session as well
+	if err := sa.Mkdir("docs"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sa.Chdir("docs"); err != nil {
+		t.Fatal(err)
+	}
+	if sb.Pwd() != "/" {
+		t.Errorf("bob's Pwd = %s after alice's Chdir", sb.Pwd())
+	}
+	if err := sb.Chdir("docs"); err == nil {
+		t.Error("bob entered alice's directory")
+	}
+
+	// Renames cannot move files across roots either
+	if err := sa.Rename("/a.txt", "../../bob/a.txt"); !errors.Is(err, ErrOutsideRoot) {
+		t.Errorf("Rename out of the root = %v, want ErrOutsideRoot", err)
+	}
+}
@@ -1,74 +1,322 @@
 // Package main implements a simple FTP-like file server using text-based protocol.
 //
-// This server provides basic file system navigation commands similar to FTP:
-//   - DIR: List directory contents
-//   - CD <path>: Change current directory
-//   - PWD: Print working directory
+// The server follows the RFC 959 split between a control connection for
+// commands and a passive data connection per transfer, so standard FTP
+// clients can talk to it. Commands (short aliases in brackets):
+//   - USER <name>, PASS <password>: Log in (when -users is set)
+//   - AUTH TLS, PBSZ 0, PROT C|P: TLS for control and data connections
+//   - PASV, EPSV: Open a passive data connection for the next transfer
+//   - LIST, NLST, MLSD [-S|-t|-r] [path|glob] (DIR = LIST): Directory listing
+//     over the data connection, see listing.go
+//   - MLST [path]: Facts of one entry
+//   - CWD <path> (CD), CDUP, PWD: Navigate
+//   - RETR <path>, GET <path>, GET "<path>" offset [length]: Download (a range
+//     of) a file
+//   - STOR <path> (PUT), ALLO <size>: Upload a file
+//   - REST <offset>: Resume the next RETR or STOR at offset
+//   - SIZE <path>, HASH <path>, HASH "<path>" offset [length]: Size and SHA-256
+//   - DELE <path> (DEL), RMD <path>, MKD <path> (MKDIR)
+//   - RNFR <from> + RNTO <to>, RENAME <from> <to>
+//   - TYPE, SYST, FEAT, NOOP, OPTS, QUIT
+//
+// A path argument is the rest of the line, so it may contain spaces. When
+// more arguments follow the path, as for a range or RENAME, the path is put
+// in double quotes: GET "lab notes.txt" 100, RENAME "a b.txt" c.txt.
+// Unquoted paths still work with RENAME when they contain no spaces.
+//
+// Transfer replies carry a SHA-256 checksum; see transfer.go for the
+// layout and datachan.go for the data connection.
+//
+// Every client gets its own virtual working directory confined to the
+// directory given with -root. "/" in the protocol is that root; ".."
+// traversal above it and symbolic links leading out of it are rejected.
+//
+// At most -max-sessions clients are served at once, idle sessions are
+// closed after -idle-timeout, and SIGINT/SIGTERM let running transfers
+// finish before the server exits (see sessions.go).
+//
+// Security:
+//
+//	-tls off       plaintext (default)
+//	-tls implicit  TLS from the first byte, like FTPS on port 990
+//	-tls explicit  plaintext greeting, client upgrades with AUTH TLS;
+//	               until then only AUTH, FEAT, SYST, OPTS, NOOP and QUIT
+//	               are served, with or without -users
+//
+// A missing -cert/-key pair is generated as a self-signed certificate.
+// With -users the client has to log in with USER/PASS; accounts are
+// checked against bcrypt hashes and each user has its own root directory
+// and read-only or read-write permission (see auth.go). Without -users
+// every client shares -root with read-write access.
 //
 // Protocol Specification:
 //
-//	Client sends: COMMAND [ARGUMENT]\r\n
-//	Server responds: RESULT\r\n (or multiple lines for DIR)
+//	Client sends: COMMAND [ARGUMENT...]\r\n
+//	Server responds: CODE TEXT\r\n
+//
+// Replies use FTP style three digit status codes (see reply.go). Replies
+// that carry several lines (FEAT) use the RFC 959 multi-line form, which
+// textproto.Reader.ReadResponse parses directly.
 //
 // Example Session:
 //
+//	Server: 220 FTP-style server ready
 //	Client: PWD
-//	Server: C:\Users\example
-//	Client: DIR
-//	Server: file1.txt
-//	        file2.go
-//	        subdir
-//
-//	Client: CD subdir
-//	Server: OK
+//	Server: 257 "/"
+//	Client: CWD subdir
+//	Server: 250 Directory changed to /subdir
+//	Client: CWD ../../etc
+//	Server: 553 path escapes the server root
+//	Client: EPSV
+//	Server: 229 Entering Extended Passive Mode (|||50123|)
+//	Client: LIST
+//	Server: 150 Opening data connection for /subdir
+//	        (entries arrive on the data connection, which then closes)
+//	Server: 226 Transfer complete, 3 entries
+//	Client: QUIT
+//	Server: 221 Goodbye
 //
 // This is an educational example demonstrating:
-//   - Text-based protocol parsing
+//   - Text-based protocol parsing with net/textproto
+//   - Binary transfers with integrity checks
 //   - Concurrent client handling with goroutines
 //   - File system operations in Go
 //
 // Usage:
 //
-//	go run server.go
+//	go run . -root /srv/ftp
+//	go run . -root /srv/ftp -tls explicit -users users.txt
+//	go run . -root /srv/ftp -max-sessions 20 -idle-timeout 2m
+//	go run . -root /srv/ftp -listen 127.0.0.1:2121
+//	go run . -hash-password
 //
 // Connect using:
 //
+//	ftp -p localhost 1202
 //	telnet localhost 1202
-//	nc localhost 1202
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
 	"log"
 	"net"
 	"os"
+	"os/signal"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
-// Protocol commands
+// Protocol commands (RFC 959 names)
 const (
-	DIR = "DIR" // List directory contents
-	CD  = "CD"  // Change directory
-	PWD = "PWD" // Print working directory
+	USER = "USER" // Login name
+	PASS = "PASS" // Login password
+	AUTH = "AUTH" // Security mechanism (AUTH TLS)
+	PBSZ = "PBSZ" // Protection buffer size (TLS: always 0)
+	PROT = "PROT" // Data connection protection level
+	PASV = "PASV" // Passive data connection (IPv4)
+	EPSV = "EPSV" // Extended passive data connection
+	LIST = "LIST" // Directory listing
+	NLST = "NLST" // Name list
+	MLSD = "MLSD" // Machine readable listing (RFC 3659)
+	MLST = "MLST" // Machine readable facts of one entry
+	CWD  = "CWD"  // Change working directory
+	CDUP = "CDUP" // Change to parent directory
+	PWD  = "PWD"  // Print working directory
+	RETR = "RETR" // Download a file
+	STOR = "STOR" // Upload a file
+	ALLO = "ALLO" // Announce upload size
+	REST = "REST" // Set restart offset for the next transfer
+	SIZE = "SIZE" // File size
+	HASH = "HASH" // SHA-256 of a file range
+	DELE = "DELE" // Delete a file
+	RMD  = "RMD"  // Remove a directory
+	MKD  = "MKD"  // Create a directory
+	RNFR = "RNFR" // Rename from
+	RNTO = "RNTO" // Rename to
+	TYPE = "TYPE" // Representation type (always binary)
+	SYST = "SYST" // System type
+	FEAT = "FEAT" // Feature list
+	OPTS = "OPTS" // Options (OPTS UTF8 ON)
+	NOOP = "NOOP" // Keep-alive
+	QUIT = "QUIT" // Close the connection
+
+	// Short commands of the original protocol
+	DIR    = "DIR"    // = LIST
+	CD     = "CD"     // = CWD
+	GET    = "GET"    // = RETR, with optional offset and length
+	PUT    = "PUT"    // = STOR
+	DEL    = "DEL"    // = DELE
+	MKDIR  = "MKDIR"  // = MKD
+	RENAME = "RENAME" // = RNFR + RNTO
 )
 
+// aliases maps the short commands to their RFC 959 equivalent.
+var aliases = map[string]string{
+	DIR:   LIST,
+	CD:    CWD,
+	PUT:   STOR,
+	DEL:   DELE,
+	MKDIR: MKD,
+}
+
+// features is the FEAT reply body.
+var features = []string{
+	"AUTH TLS",
+	"EPSV",
+	"HASH",
+	"MLST type*;size*;modify*;UNIX.mode*;",
+	"PASV",
+	"PBSZ",
+	"PROT",
+	"REST STREAM",
+	"SIZE",
+	"UTF8",
+}
+
+// preLogin lists the commands allowed before USER/PASS succeeded.
+var preLogin = map[string]bool{
+	USER: true, PASS: true, AUTH: true, PBSZ: true, PROT: true,
+	FEAT: true, SYST: true, OPTS: true, NOOP: true, QUIT: true,
+}
+
+// plaintextCommands are the only commands served before AUTH TLS when
+// the server runs with -tls explicit.
+var plaintextCommands = map[string]bool{
+	AUTH: true, FEAT: true, SYST: true, OPTS: true, NOOP: true, QUIT: true,
+}
+
+// transferSetup commands prepare the next transfer and keep a pending
+// REST offset or ALLO size armed; any other command clears them.
+var transferSetup = map[string]bool{
+	REST: true,
+	ALLO: true,
+	PASV: true,
+	EPSV: true,
+	TYPE: true,
+}
+
+// writeCommands modify the file system and are refused for read-only users.
+var writeCommands = map[string]bool{
+	STOR:   true,
+	ALLO:   true,
+	DELE:   true,
+	RMD:    true,
+	MKD:    true,
+	RNFR:   true,
+	RNTO:   true,
+	RENAME: true,
+}
+
+// server holds the configuration shared by all connections.
+type server struct {
+	sandbox   *Sandbox    // Shared root when no users file is configured
+	users     Users       // Accounts from -users, nil for open access
+	tlsConfig *tls.Config // nil when -tls is off
+	tlsMode   string      // tlsOff, tlsImplicit or tlsExplicit
+
+	maxSessions int           // 0 for unlimited
+	idleTimeout time.Duration // 0 to disable
+
+	mu       sync.Mutex           // Guards clients and closing
+	clients  map[*client]struct{} // Active sessions
+	closing  bool                 // Shutdown in progress
+	sessions sync.WaitGroup       // Running handleClient goroutines
+}
+
 func main() {
-	// Bind to all interfaces on port 1202
-	service := "0.0.0.0:1202"
+	listen := flag.String("listen", "0.0.0.0:1202", "address to listen on")
+	rootDir := flag.String("root", ".", "directory exposed to clients as /")
+	usersFile := flag.String("users", "", "users file enabling USER/PASS login (see auth.go)")
+	tlsMode := flag.String("tls", tlsOff, "TLS mode: off, implicit or explicit")
+	certFile := flag.String("cert", "cert.pem", "TLS certificate (PEM)")
+	keyFile := flag.String("key", "key.pem", "TLS private key (PEM)")
+	hashPw := flag.Bool("hash-password", false, "read a password from stdin, print its bcrypt hash and exit")
+	maxSessions := flag.Int("max-sessions", 100, "maximum concurrent sessions, 0 for unlimited")
+	idleTimeout := flag.Duration("idle-timeout", 5*time.Minute, "close sessions idle for this long, 0 to disable")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "how long SIGTERM waits for running transfers")
+	flag.Parse()
+
+	if *hashPw {
+		hashPassword()
+		return
+	}
+
+	// Bind to all interfaces on port 1202 unless -listen says otherwise
+	service := *listen
+
+	srv := &server{
+		tlsMode:     *tlsMode,
+		maxSessions: *maxSessions,
+		idleTimeout: *idleTimeout,
+		clients:     make(map[*client]struct{}),
+	}
+
+	if *usersFile != "" {
+		// Every user gets their own root, opened at login
+		users, err := LoadUsers(*usersFile, *rootDir)
+		checkError(err)
+		srv.users = users
+		log.Printf("Loaded %d users from %s", len(users), *usersFile)
+	} else {
+		// Open the shared root once; sessions only hold a virtual path into it
+		sandbox, err := OpenSandbox(*rootDir)
+		checkError(err)
+		defer sandbox.Close()
+		srv.sandbox = sandbox
+	}
+
+	switch *tlsMode {
+	case tlsOff:
+	case tlsImplicit, tlsExplicit:
+		cfg, err := loadTLSConfig(*certFile, *keyFile, true)
+		checkError(err)
+		srv.tlsConfig = cfg
+	default:
+		log.Fatalf("Fatal error: unknown TLS mode %q", *tlsMode)
+	}
 
 	// Resolve the TCP address
 	tcpAddr, err := net.ResolveTCPAddr("tcp", service)
 	checkError(err)
 
 	// Create TCP listener
-	listener, err := net.ListenTCP("tcp", tcpAddr)
+	var listener net.Listener
+	listener, err = net.ListenTCP("tcp", tcpAddr)
 	checkError(err)
 
-	log.Printf("FTP Server started on %s", service)
-	log.Println("Available commands: DIR, CD <path>, PWD")
+	// Implicit TLS: every accepted connection is a *tls.Conn already
+	if *tlsMode == tlsImplicit {
+		listener = tls.NewListener(listener, srv.tlsConfig)
+	}
+
+	log.Printf("FTP Server started on %s (root %s, tls %s)", listener.Addr(), *rootDir, *tlsMode)
+	log.Println("Data connections: passive only (PASV, EPSV)")
+	log.Printf("Limits: %d sessions, idle timeout %s", *maxSessions, *idleTimeout)
+
+	// SIGINT/SIGTERM close the listener, which ends the accept loop
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	srv.serve(listener)
+	srv.shutdown(*shutdownTimeout)
+}
 
-	// Main accept loop
+// serve is the main accept loop. It returns once the listener is closed.
+func (srv *server) serve(listener net.Listener) {
 	for {
 		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
 		if err != nil {
 			log.Printf("Accept error: %v", err)
 			continue
@@ -76,8 +324,13 @@ func main() {
 
 		log.Printf("New connection from %s", conn.RemoteAddr())
 
+		c, ok := srv.admit(conn)
+		if !ok {
+			continue
+		}
+
 		// Handle each client in a separate goroutine
-		go handleClient(conn)
+		go handleClient(c)
 	}
 }
 
@@ -85,125 +338,251 @@ func main() {
 // It reads commands, parses them, and dispatches to appropriate handlers.
 //
 // Parameters:
-//   - conn: The client connection
+//   - c: The client connection and its session
 //
-// The function runs until the client disconnects or an error occurs.
-func handleClient(conn net.Conn) {
-	defer conn.Close()
+// The function runs until the client disconnects, sends QUIT or an error
+// occurs.
+func handleClient(c *client) {
+	defer c.srv.release(c)
+	defer func() { c.text.Close() }()
+	defer c.logout()
+	defer c.closePassive()
 
-	var buf [512]byte
+	remote := c.conn.RemoteAddr()
+	c.reply(StatusReady, "FTP-style server ready")
 
 	for {
-		// Read command from client
-		n, err := conn.Read(buf[0:])
+		if !c.srv.awaitCommand(c) {
+			c.reply(StatusServiceUnavailable, "Server shutting down")
+			log.Printf("Closed %s for shutdown", remote)
+			return
+		}
+
+		// textproto reads exactly one CRLF (or LF) terminated line,
+		// however the bytes were split or merged on the wire
+		input, err := c.text.ReadLine()
+		if err != nil && isTimeout(err) {
+			// Either the idle deadline or the one set by shutdown expired
+			c.conn.SetWriteDeadline(time.Now().Add(dataTimeout))
+			if c.srv.isClosing() {
+				c.reply(StatusServiceUnavailable, "Server shutting down")
+				log.Printf("Closed %s for shutdown", remote)
+			} else {
+				c.reply(StatusServiceUnavailable, "Idle timeout, closing connection")
+				log.Printf("Client %s idle for %s, closed", remote, c.srv.idleTimeout)
+			}
+			return
+		}
 		if err != nil {
-			log.Printf("Client %s disconnected", conn.RemoteAddr())
+			log.Printf("Client %s disconnected", remote)
 			return
 		}
 
-		// Parse command: split into command and arguments
-		input := strings.TrimSpace(string(buf[0:n]))
-		parts := strings.Fields(input)
+		// Parse command: the verb, the raw argument (paths may contain
+		// spaces) and the argument split into fields
+		verb, arg, _ := strings.Cut(strings.TrimSpace(input), " ")
+		if verb == "" {
+			continue
+		}
+		arg = strings.TrimSpace(arg)
+		args := strings.Fields(arg)
 
-		if len(parts) == 0 {
+		command := strings.ToUpper(verb)
+		if rfc, ok := aliases[command]; ok {
+			command = rfc
+		}
+
+		if command == PASS {
+			log.Printf("Received from %s: PASS ****", remote)
+		} else {
+			log.Printf("Received from %s: %s", remote, input)
+		}
+
+		// Explicit TLS is required, not offered: no credentials, paths or
+		// file contents cross the network before the upgrade
+		if c.srv.tlsMode == tlsExplicit && !c.secure && !plaintextCommands[command] {
+			c.reply(StatusNotLoggedIn, "Use AUTH TLS first, this server requires TLS")
+			continue
+		}
+
+		// Until login only the session commands are available
+		if c.sess == nil && !preLogin[command] {
+			c.reply(StatusNotLoggedIn, "Please login with USER and PASS")
+			continue
+		}
+		if writeCommands[command] && c.perm != ReadWrite {
+			c.reply(StatusFileUnavailable, "Permission denied: read-only account")
 			continue
 		}
 
-		command := strings.ToUpper(parts[0])
-		log.Printf("Received from %s: %s", conn.RemoteAddr(), input)
+		// Commands that need an argument
+		switch command {
+		case USER, AUTH, PROT, CWD, RETR, STOR, ALLO, REST, SIZE, HASH, DELE, RMD, MKD, RNFR, RNTO, TYPE, GET:
+			if arg == "" {
+				c.reply(StatusArgumentError, "%s requires an argument", command)
+				continue
+			}
+		}
 
 		// Dispatch command to appropriate handler
 		switch command {
-		case CD:
-			if len(parts) < 2 {
-				sendResponse(conn, "ERROR: CD requires a path argument")
+		case AUTH:
+			startTLS(c, c.srv.tlsConfig, strings.ToUpper(arg))
+
+		case PBSZ:
+			protectionBufferSize(c)
+
+		case PROT:
+			protection(c, arg)
+
+		case USER:
+			userName(c, arg)
+
+		case PASS:
+			login(c, arg)
+
+		case PASV, EPSV:
+			passive(c, command == EPSV)
+
+		case LIST, NLST, MLSD:
+			listDirectory(c, command, arg)
+
+		case MLST:
+			listEntry(c, arg)
+
+		case CWD:
+			changeDirectory(c, arg)
+
+		case CDUP:
+			changeDirectory(c, "..")
+
+		case PWD:
+			printWorkingDirectory(c)
+
+		case RETR:
+			sendFile(c, arg, c.restart, -1)
+
+		case GET:
+			path, rng, err := pathArgs(arg)
+			if err != nil || len(rng) > 2 {
+				c.reply(StatusArgumentError, `usage: GET <path> or GET "<path>" offset [length]`)
+				break
+			}
+			offset, length, err := parseRange(rng)
+			if err != nil {
+				c.reply(StatusArgumentError, "%v", err)
+				break
+			}
+			if len(rng) == 0 {
+				offset = c.restart
+			}
+			sendFile(c, path, offset, length)
+
+		case STOR:
+			receiveFile(c, arg, c.restart, c.allocate)
+
+		case ALLO:
+			allocate(c, args[0])
+
+		case REST:
+			restart(c, arg)
+
+		case SIZE:
+			fileSize(c, arg)
+
+		case HASH:
+			path, rng, err := pathArgs(arg)
+			if err != nil || len(rng) > 2 {
+				c.reply(StatusArgumentError, `usage: HASH <path> or HASH "<path>" offset [length]`)
+				break
+			}
+			offset, length, err := parseRange(rng)
+			if err != nil {
+				c.reply(StatusArgumentError, "%v", err)
+				break
+			}
+			fileHash(c, path, offset, length)
+
+		case DELE, RMD:
+			deleteFile(c, arg)
+
+		case MKD:
+			makeDirectory(c, arg)
+
+		case RNFR:
+			renameFrom(c, arg)
+
+		case RNTO:
+			renameTo(c, arg)
+
+		case RENAME:
+			if paths, err := splitQuoted(arg); err != nil || len(paths) != 2 {
+				c.reply(StatusArgumentError, "usage: RENAME <from> <to>, quote paths with spaces")
 			} else {
-				changeDirectory(conn, parts[1])
+				renameFile(c, paths[0], paths[1])
 			}
 
-		case DIR:
-			listDirectory(conn)
+		case TYPE:
+			// Everything is transferred as binary; "A" is accepted so that
+			// clients which insist on ASCII listings still work
+			c.reply(StatusOK, "Type set to %s", strings.ToUpper(args[0]))
 
-		case PWD:
-			printWorkingDirectory(conn)
+		case SYST:
+			c.reply(StatusSystemType, "UNIX Type: L8")
+
+		case FEAT:
+			c.replyLines(StatusSystemStatus, "Features:", features, "End")
+
+		case OPTS:
+			c.reply(StatusOK, "Always in UTF8 mode")
+
+		case NOOP:
+			c.reply(StatusOK, "OK")
+
+		case QUIT:
+			c.reply(StatusClosing, "Goodbye")
+			log.Printf("Client %s quit", remote)
+			return
 
 		default:
-			log.Printf("Unknown command from %s: %s", conn.RemoteAddr(), command)
-			sendResponse(conn, "ERROR: Unknown command. Use DIR, CD, or PWD")
+			log.Printf("Unknown command from %s: %s", remote, command)
+			c.reply(StatusSyntaxError, "Unknown command %q", command)
 		}
-	}
-}
 
-// changeDirectory changes the server's current working directory.
-//
-// Parameters:
-//   - conn: Client connection for response
-//   - path: Target directory path
-//
-// Responds with "OK" on success or "ERROR" on failure.
-func changeDirectory(conn net.Conn, path string) {
-	err := os.Chdir(path)
-	if err != nil {
-		log.Printf("CD error: %v", err)
-		sendResponse(conn, "ERROR: "+err.Error())
-		return
+		// A restart offset and an ALLO size only apply to the next transfer
+		if !transferSetup[command] {
+			c.restart = 0
+			c.allocate = -1
+		}
+		if command != RNFR {
+			c.renameFrom = ""
+		}
 	}
-	sendResponse(conn, "OK")
 }
 
-// printWorkingDirectory sends the current working directory to the client.
+// changeDirectory changes the session's working directory.
+// Only the session is affected; other clients keep their own directory.
 //
 // Parameters:
-//   - conn: Client connection for response
-func printWorkingDirectory(conn net.Conn) {
-	wd, err := os.Getwd()
-	if err != nil {
-		log.Printf("PWD error: %v", err)
-		sendResponse(conn, "ERROR: "+err.Error())
-		return
-	}
-	sendResponse(conn, wd)
-}
-
-// listDirectory sends the contents of the current directory to the client.
-// Each entry is sent on a separate line, followed by an empty line.
+//   - c: Client connection and session
+//   - path: Target directory path
 //
-// Parameters:
-//   - conn: Client connection for response
-func listDirectory(conn net.Conn) {
-	// Open current directory
-	dir, err := os.Open(".")
-	if err != nil {
-		log.Printf("DIR error: %v", err)
-		sendResponse(conn, "ERROR: "+err.Error())
+// Responds with 250 on success or 550/553 on failure.
+func changeDirectory(c *client, path string) {
+	if err := c.sess.Chdir(path); err != nil {
+		c.replyError(CWD, err)
 		return
 	}
-	defer dir.Close()
-
-	// Read all directory entries
-	entries, err := dir.Readdirnames(-1)
-	if err != nil {
-		log.Printf("Readdirnames error: %v", err)
-		sendResponse(conn, "ERROR: "+err.Error())
-		return
-	}
-
-	// Send each entry on its own line
-	for _, name := range entries {
-		conn.Write([]byte(name + "\r\n"))
-	}
-
-	// Send empty line to indicate end of listing
-	conn.Write([]byte("\r\n"))
+	c.reply(StatusFileActionOK, "Directory changed to %s", c.sess.Pwd())
 }
 
-// sendResponse sends a text response to the client with CRLF line ending.
+// printWorkingDirectory sends the session's virtual working directory
+// as a quoted 257 reply.
 //
 // Parameters:
-//   - conn: Client connection
-//   - msg: Message to send
-func sendResponse(conn net.Conn, msg string) {
-	conn.Write([]byte(msg + "\r\n"))
+//   - c: Client connection and session
+func printWorkingDirectory(c *client) {
+	c.reply(StatusPathCreated, "%q", c.sess.Pwd())
 }
 
 // checkError handles fatal errors during server initialization.
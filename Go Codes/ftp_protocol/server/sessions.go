@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/textproto"
+	"os"
+	"time"
+)
+
+// Session limits and shutdown
+//
+// The server tracks every control connection so that it can enforce
+// three limits:
+//
+//	-max-sessions      connections beyond the limit get
+//	                   "421 Too many connections" and are closed
+//	-idle-timeout      a session that sends no command for this long gets
+//	                   "421 Idle timeout" and is closed; a data connection
+//	                   that makes no progress for this long is aborted (426)
+//	-shutdown-timeout  how long SIGINT/SIGTERM waits for running transfers
+//
+// Shutdown stops accepting, then interrupts sessions that are waiting for
+// a command with "421 Server shutting down". A session in the middle of a
+// transfer finishes it, gets its 226 reply and is closed before the next
+// command. Sessions still busy after -shutdown-timeout are cut off.
+
+// awaitCommand prepares the control connection for reading the next
+// command. It returns false once the server is shutting down.
+//
+// The idle deadline is set under the server lock, so a shutdown either
+// happens before (and the session sees closing) or after (and its past
+// deadline overrides this one).
+func (srv *server) awaitCommand(c *client) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if srv.closing {
+		return false
+	}
+
+	var deadline time.Time
+	if srv.idleTimeout > 0 {
+		deadline = time.Now().Add(srv.idleTimeout)
+	}
+	c.conn.SetReadDeadline(deadline)
+	return true
+}
+
+// isClosing reports whether shutdown has started.
+func (srv *server) isClosing() bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.closing
+}
+
+// admit registers a new connection, or refuses it with 421 when the
+// session limit is reached or the server is shutting down.
+func (srv *server) admit(conn net.Conn) (*client, bool) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	reason := ""
+	switch {
+	case srv.closing:
+		reason = "Server shutting down"
+	case srv.maxSessions > 0 && len(srv.clients) >= srv.maxSessions:
+		reason = "Too many connections, try again later"
+	}
+	if reason != "" {
+		log.Printf("Refused %s: %s (%d sessions)", conn.RemoteAddr(), reason, len(srv.clients))
+		go refuse(conn, reason)
+		return nil, false
+	}
+
+	c := newClient(srv, conn)
+	srv.clients[c] = struct{}{}
+	srv.sessions.Add(1)
+	return c, true
+}
+
+// refuse sends a 421 greeting and closes the connection. It runs in its
+// own goroutine so that a slow client (or TLS handshake) cannot stall the
+// accept loop.
+func refuse(conn net.Conn, reason string) {
+	conn.SetDeadline(time.Now().Add(dataTimeout))
+	textproto.NewConn(conn).PrintfLine("%d %s", StatusServiceUnavailable, reason)
+	conn.Close()
+}
+
+// release removes a finished session.
+func (srv *server) release(c *client) {
+	srv.mu.Lock()
+	delete(srv.clients, c)
+	srv.mu.Unlock()
+	srv.sessions.Done()
+}
+
+// shutdown refuses new sessions, wakes up idle ones and waits up to
+// timeout for the busy ones to finish their transfer.
+func (srv *server) shutdown(timeout time.Duration) {
+	srv.mu.Lock()
+	srv.closing = true
+	log.Printf("Shutting down, draining %d sessions (up to %s)", len(srv.clients), timeout)
+	for c := range srv.clients {
+		// A deadline in the past makes a pending ReadLine return now;
+		// running transfers use the data connection and are not affected
+		c.raw.SetReadDeadline(time.Unix(1, 0))
+	}
+	srv.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		srv.sessions.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Println("All sessions closed")
+	case <-time.After(timeout):
+		srv.mu.Lock()
+		log.Printf("Shutdown timeout, cutting off %d sessions", len(srv.clients))
+		for c := range srv.clients {
+			c.raw.Close()
+		}
+		srv.mu.Unlock()
+	}
+}
+
+// isTimeout reports whether err is a deadline expiry.
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, os.ErrDeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// idleConn refreshes the deadline before every read and write, so a
+// transfer may take as long as it needs but must not stall for longer
+// than timeout.
+type idleConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c idleConn) Read(b []byte) (int, error) {
+	c.Conn.SetDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Read(b)
+}
+
+func (c idleConn) Write(b []byte) (int, error) {
+	c.Conn.SetDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Write(b)
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testServer is a server running on a loopback port, sharing one root
+// without login.
+type testServer struct {
+	*server
+	addr     string
+	root     string
+	listener net.Listener
+}
+
+// startServer starts a server with the given limits; the accept loop and
+// all sessions are stopped when the test ends.
+func startServer(t *testing.T, configure func(srv *server)) *testServer {
+	t.Helper()
+	root := t.TempDir()
+	sandbox, err := OpenSandbox(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &server{
+		sandbox: sandbox,
+		tlsMode: tlsOff,
+		clients: make(map[*client]struct{}),
+	}
+	if configure != nil {
+		configure(srv)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		srv.serve(listener)
+	}()
+	t.Cleanup(func() {
+		listener.Close()
+		<-done
+		srv.shutdown(time.Second)
+		sandbox.Close()
+	})
+	return &testServer{server: srv, addr: listener.Addr().String(), root: root, listener: listener}
+}
+
+// sessionCount returns the number of registered sessions.
+func (ts *testServer) sessionCount() int {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return len(ts.clients)
+}
+
+// dial connects to addr and checks the greeting code.
+func dial(t *testing.T, addr string, greeting int) *textproto.Conn {
+	t.Helper()
+	_, text := dialConn(t, addr, greeting)
+	return text
+}
+
+// dialConn is dial that also returns the connection, for AUTH TLS.
+func dialConn(t *testing.T, addr string, greeting int) (net.Conn, *textproto.Conn) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	text := textproto.NewConn(conn)
+	t.Cleanup(func() { text.Close() })
+	expect(t, text, greeting, "")
+	return conn, text
+}
+
+// expect reads one reply and checks its code and the start of its text.
+func expect(t *testing.T, text *textproto.Conn, code int, prefix string) string {
+	t.Helper()
+	got, msg, err := text.ReadResponse(0)
+	if err != nil {
+		t.Fatalf("reading reply, want %d %s: %v", code, prefix, err)
+	}
+	if got != code || !strings.HasPrefix(msg, prefix) {
+		t.Fatalf("got %d %s, want %d %s", got, msg, code, prefix)
+	}
+	return msg
+}
+
+// command sends a command and checks its reply.
+func command(t *testing.T, text *textproto.Conn, line string, code int, prefix string) string {
+	t.Helper()
+	if err := text.PrintfLine("%s", line); err != nil {
+		t.Fatal(err)
+	}
+	return expect(t, text, code, prefix)
+}
+
+// expectClosed checks that the server has closed the connection.
+func expectClosed(t *testing.T, text *textproto.Conn) {
+	t.Helper()
+	if line, err := text.ReadLine(); err == nil {
+		t.Fatalf("got %q, want the connection closed", line)
+	}
+}
+
+// openData sends EPSV and connects to the announced port.
+func openData(t *testing.T, text *textproto.Conn) net.Conn {
+	t.Helper()
+	msg := command(t, text, EPSV, StatusExtendedPassive, "")
+	_, port, _ := strings.Cut(strings.TrimSuffix(msg, "|)"), "(|||")
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// writeBigFile stores a random file larger than what the loopback socket
+// buffers hold, so that a transfer of it stalls while the client does not
+// read. It returns the file's SHA-256.
+func writeBigFile(t *testing.T, dir, name string) string {
+	t.Helper()
+	data := make([]byte, 32<<20)
+	rand.Read(data)
+	if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestMaxSessions(t *testing.T) {
+	ts := startServer(t, func(srv *server) { srv.maxSessions = 2 })
+
+	first := dial(t, ts.addr, StatusReady)
+	dial(t, ts.addr, StatusReady)
+
+	refused := dial(t, ts.addr, StatusServiceUnavailable)
+	expectClosed(t, refused)
+
+	// A session that ends makes room for the next one
+	command(t, first, QUIT, StatusClosing, "")
+	expectClosed(t, first)
+	for deadline := time.Now().Add(5 * time.Second); ts.sessionCount() >= 2; {
+		if time.Now().After(deadline) {
+			t.Fatal("session was not released after QUIT")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	next := dial(t, ts.addr, StatusReady)
+	command(t, next, NOOP, StatusOK, "")
+}
+
+func TestIdleTimeout(t *testing.T) {
+	ts := startServer(t, func(srv *server) { srv.idleTimeout = 300 * time.Millisecond })
+
+	idle := dial(t, ts.addr, StatusReady)
+	start := time.Now()
+	expect(t, idle, StatusServiceUnavailable, "Idle timeout")
+	if waited := time.Since(start); waited < 250*time.Millisecond {
+		t.Errorf("closed after %s, before the idle timeout", waited)
+	}
+	expectClosed(t, idle)
+
+	// Every command restarts the timer
+	busy := dial(t, ts.addr, StatusReady)
+	for i := 0; i < 6; i++ {
+		time.Sleep(100 * time.Millisecond)
+		command(t, busy, NOOP, StatusOK, "")
+	}
+	expect(t, busy, StatusServiceUnavailable, "Idle timeout")
+}
+
+// TestIdleTransfer stalls a download: the data connection makes no
+// progress for longer than the idle timeout and is aborted.
+func TestIdleTransfer(t *testing.T) {
+	ts := startServer(t, func(srv *server) { srv.idleTimeout = 300 * time.Millisecond })
+	writeBigFile(t, ts.root, "big.bin")
+
+	text := dial(t, ts.addr, StatusReady)
+	openData(t, text)
+	command(t, text, "RETR big.bin", StatusTransferStarting, "")
+	expect(t, text, StatusTransferAborted, "")
+	command(t, text, NOOP, StatusOK, "")
+}
+
+// TestShutdownDrain shuts the server down with one idle session and one
+// in the middle of a download. The idle one is closed at once; the
+// download completes with its 226 before that session is closed too.
+func TestShutdownDrain(t *testing.T) {
+	ts := startServer(t, nil)
+	sum := writeBigFile(t, ts.root, "big.bin")
+
+	idle := dial(t, ts.addr, StatusReady)
+	busy := dial(t, ts.addr, StatusReady)
+	data := openData(t, busy)
+	command(t, busy, "RETR big.bin", StatusTransferStarting, "")
+
+	ts.listener.Close()
+	done := make(chan struct{})
+	go func() {
+		ts.shutdown(10 * time.Second)
+		close(done)
+	}()
+
+	expect(t, idle, StatusServiceUnavailable, "Server shutting down")
+	expectClosed(t, idle)
+	if conn, err := net.Dial("tcp", ts.addr); err == nil {
+		conn.Close()
+		t.Error("new connection accepted during shutdown")
+	}
+
+	select {
+	case <-done:
+		t.Fatal("shutdown returned while a transfer was running")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, data); err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != sum {
+		t.Errorf("received sha256 %s, want %s", got, sum)
+	}
+	expect(t, busy, StatusTransferComplete, "Transfer complete, sha256 "+sum)
+	expect(t, busy, StatusServiceUnavailable, "Server shutting down")
+	expectClosed(t, busy)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown did not return after the last session closed")
+	}
+}
+
+// TestShutdownTimeout cuts off a session whose transfer does not finish
+// within the shutdown timeout.
+func TestShutdownTimeout(t *testing.T) {
+	ts := startServer(t, nil)
+	writeBigFile(t, ts.root, "big.bin")
+
+	busy := dial(t, ts.addr, StatusReady)
+	data := openData(t, busy)
+	command(t, busy, "RETR big.bin", StatusTransferStarting, "")
+
+	ts.listener.Close()
+	start := time.Now()
+	ts.shutdown(300 * time.Millisecond)
+	if took := time.Since(start); took > 3*time.Second {
+		t.Errorf("shutdown took %s with a 300ms timeout", took)
+	}
+	expectClosed(t, busy)
+
+	// The stalled transfer fails once its data connection goes away
+	data.Close()
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"math/big"
+	"net"
+	"net/textproto"
+	"os"
+	"time"
+)
+
+// TLS modes selected with -tls.
+const (
+	tlsOff      = "off"      // Plaintext only
+	tlsImplicit = "implicit" // TLS handshake right after TCP connect
+	tlsExplicit = "explicit" // Plaintext greeting, then AUTH TLS upgrade
+)
+
+// loadTLSConfig loads the server certificate and key. When the files do
+// not exist and generate is set, a self-signed pair is created first.
+func loadTLSConfig(certFile, keyFile string, generate bool) (*tls.Config, error) {
+	_, err := os.Stat(certFile)
+	if errors.Is(err, fs.ErrNotExist) && generate {
+		log.Printf("Generating self-signed certificate %s", certFile)
+		if err := generateCertificate(certFile, keyFile); err != nil {
+			return nil, err
+		}
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// generateCertificate writes a self-signed certificate and its RSA key
+// as PEM files. The template mirrors x509/genx509cert: one year validity,
+// self-signed CA, usable for "localhost" and the machine's host name.
+func generateCertificate(certFile, keyFile string) error {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return err
+	}
+
+	hosts := []string{"localhost"}
+	if name, err := os.Hostname(); err == nil {
+		hosts = append(hosts, name)
+	}
+
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(now.UnixNano()),
+
+		Subject: pkix.Name{
+			CommonName:   hosts[len(hosts)-1],
+			Organization: []string{"FTP-style server"},
+		},
+
+		NotBefore: now,
+		NotAfter:  now.Add(365 * 24 * time.Hour),
+
+		KeyUsage: x509.KeyUsageCertSign |
+			x509.KeyUsageKeyEncipherment |
+			x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+
+		DNSNames:    hosts,
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+
+	// Self-signed certificate (template signs itself)
+	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+
+	if err := writePEM(certFile, "CERTIFICATE", derBytes, 0o644); err != nil {
+		return err
+	}
+	return writePEM(keyFile, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key), 0o600)
+}
+
+// writePEM stores one PEM block in a new file.
+func writePEM(name, blockType string, der []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+
+	if err := pem.Encode(f, &pem.Block{Type: blockType, Bytes: der}); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// startTLS handles AUTH TLS: it answers 234 on the plaintext connection
+// and then runs the TLS handshake on the same socket (RFC 4217).
+//
+// Parameters:
+//   - c: Client connection to upgrade
+//   - cfg: Server TLS configuration, nil if TLS is disabled
+//   - arg: Mechanism requested by the client, must be "TLS"
+func startTLS(c *client, cfg *tls.Config, arg string) {
+	switch {
+	case cfg == nil:
+		c.reply(StatusNotImplemented, "TLS is not enabled on this server")
+		return
+	case c.secure:
+		c.reply(StatusBadSequence, "Connection is already protected")
+		return
+	case arg != "TLS" && arg != "TLS-C":
+		c.reply(StatusNotImplementedParam, "Unsupported mechanism %q, use AUTH TLS", arg)
+		return
+	}
+
+	// The client must not send anything until it has seen 234, so the
+	// textproto reader holds no buffered plaintext at this point
+	c.reply(StatusAuthOK, "Starting TLS handshake")
+
+	tlsConn := tls.Server(c.conn, cfg)
+	if err := tlsConn.Handshake(); err != nil {
+		log.Printf("TLS handshake with %s failed: %v", c.conn.RemoteAddr(), err)
+		c.conn.Close()
+		return
+	}
+
+	c.conn = tlsConn
+	c.text = textproto.NewConn(tlsConn)
+	c.secure = true
+	log.Printf("Client %s upgraded to %s", c.conn.RemoteAddr(), tlsVersion(tlsConn))
+}
+
+// tlsVersion returns a printable protocol version of a finished handshake.
+func tlsVersion(conn *tls.Conn) string {
+	return fmt.Sprintf("TLS (%s)", tls.VersionName(conn.ConnectionState().Version))
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/textproto"
+	"path/filepath"
+	"testing"
+)
+
+// TestExplicitTLSRequired runs -tls explicit without -users. Nothing but
+// the session commands may run before AUTH TLS; afterwards the anonymous
+// session works as usual.
+func TestExplicitTLSRequired(t *testing.T) {
+	dir := t.TempDir()
+	cfg, err := loadTLSConfig(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := startServer(t, func(srv *server) {
+		srv.tlsMode = tlsExplicit
+		srv.tlsConfig = cfg
+	})
+
+	conn, text := dialConn(t, ts.addr, StatusReady)
+	for _, line := range []string{"USER anonymous", "PASS x", "PWD", "CWD /", "EPSV", "PASV", "LIST", "RETR a", "STOR a", "MKD a", "PBSZ 0", "PROT P"} {
+		command(t, text, line, StatusNotLoggedIn, "Use AUTH TLS first")
+	}
+	command(t, text, FEAT, StatusSystemStatus, "")
+	command(t, text, SYST, StatusSystemType, "")
+	command(t, text, NOOP, StatusOK, "")
+
+	command(t, text, "AUTH TLS", StatusAuthOK, "")
+	secure := textproto.NewConn(tls.Client(conn, &tls.Config{InsecureSkipVerify: true}))
+	command(t, secure, PWD, StatusPathCreated, `"/"`)
+	command(t, secure, "MKD sub", StatusPathCreated, "")
+	command(t, secure, "CWD sub", StatusFileActionOK, "")
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// File transfers
+//
+// RETR (alias GET) and STOR (alias PUT) move file contents over a data
+// connection (see datachan.go). The 226 reply that closes a transfer
+// carries the SHA-256 of the bytes that crossed the data connection, so
+// both sides can verify the file without a second pass:
+//
+//	C: EPSV                             C: EPSV
+//	S: 229 ... (|||port|)               S: 229 ... (|||port|)
+//	C: RETR <path>                      C: ALLO <size>         (optional)
+//	S: 150 ... (<size> bytes)           S: 200 ...
+//	   data: <size bytes>, close        C: STOR <path>
+//	S: 226 ..., sha256 <hex>            S: 150 ...
+//	                                       data: <bytes>, close
+//	                                    S: 226 ..., sha256 <hex>
+//
+// Uploads are written to "<path>.part" and renamed into place when the
+// data connection closes. Since a broken connection also looks like a
+// normal close, a client that knows the size announces it with ALLO; a
+// shorter upload then keeps its ".part" file and fails with 426 instead
+// of replacing a good file.
+//
+// Resuming and range reads
+//
+// "REST <offset>" arms a restart offset for the next RETR or STOR (350
+// reply). PASV, EPSV, ALLO and TYPE may come in between; any other command
+// clears it. GET can also take the range
+// directly as `GET "<path>" offset [length]`; the path is quoted since
+// an unquoted one runs to the end of the line. For a ranged download the
+// size and checksum in the replies cover only the bytes that were sent.
+//
+// An interrupted upload leaves its ".part" file behind. "SIZE <path>.part"
+// tells the client how much arrived, and "REST <n>" followed by
+// "STOR <path>" appends to it. The checksum of a resumed STOR covers the
+// whole file, so the server re-hashes the kept prefix.
+//
+// `HASH "<path>" offset [length]` returns the SHA-256 of a range without
+// transferring it, which lets a client check that its partial copy
+// matches the server before resuming; "HASH <path>" hashes the whole file.
+
+// partSuffix marks an upload that has not been verified yet.
+const partSuffix = ".part"
+
+// pathArgs splits the argument of GET and HASH into the path and the
+// arguments after it. An unquoted argument is a path as a whole, like the
+// argument of RETR; a quoted path may be followed by further arguments.
+func pathArgs(arg string) (path string, rest []string, err error) {
+	if !strings.HasPrefix(arg, `"`) {
+		return arg, nil, nil
+	}
+	args, err := splitQuoted(arg)
+	if err != nil || len(args) == 0 {
+		return "", nil, errors.New("bad quoting")
+	}
+	return args[0], args[1:], nil
+}
+
+// splitQuoted splits arg at spaces; spaces inside double quotes do not
+// split and the quotes are dropped ("a b" c -> [a b] [c]).
+func splitQuoted(arg string) ([]string, error) {
+	var (
+		args    []string
+		field   strings.Builder
+		inField bool
+		inQuote bool
+	)
+	for _, r := range arg {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+			inField = true
+		case !inQuote && (r == ' ' || r == '\t'):
+			if inField {
+				args = append(args, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			field.WriteRune(r)
+			inField = true
+		}
+	}
+	if inQuote {
+		return nil, errors.New("unterminated quote")
+	}
+	if inField {
+		args = append(args, field.String())
+	}
+	return args, nil
+}
+
+// parseRange reads the optional "[offset [length]]" arguments shared by
+// GET and HASH. A missing length means "to the end of the file"
+// and is returned as -1.
+func parseRange(args []string) (offset, length int64, err error) {
+	length = -1
+
+	if len(args) > 0 {
+		if offset, err = strconv.ParseInt(args[0], 10, 64); err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("invalid offset %q", args[0])
+		}
+	}
+	if len(args) > 1 {
+		if length, err = strconv.ParseInt(args[1], 10, 64); err != nil || length < 0 {
+			return 0, 0, fmt.Errorf("invalid length %q", args[1])
+		}
+	}
+
+	return offset, length, nil
+}
+
+// openRange opens a regular file and positions it at offset. It returns
+// the number of bytes available in the requested range.
+func openRange(c *client, command, path string, offset, length int64) (*os.File, int64, bool) {
+	info, err := c.sess.Stat(path)
+	if err != nil {
+		c.replyError(command, err)
+		return nil, 0, false
+	}
+	if info.IsDir() {
+		c.replyError(command, &fs.PathError{Op: strings.ToLower(command), Path: path, Err: errIsDir})
+		return nil, 0, false
+	}
+	if offset > info.Size() {
+		c.reply(StatusArgumentError, "offset %d beyond end of file (%d bytes)", offset, info.Size())
+		return nil, 0, false
+	}
+
+	n := info.Size() - offset
+	if length >= 0 && length < n {
+		n = length
+	}
+
+	f, err := c.sess.Open(path)
+	if err != nil {
+		c.replyError(command, err)
+		return nil, 0, false
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		c.replyError(command, err)
+		return nil, 0, false
+	}
+
+	return f, n, true
+}
+
+// sendFile handles RETR and GET: it streams the file (or a range of it)
+// over the data connection and reports the checksum of the bytes sent.
+//
+// Parameters:
+//   - c: Client connection and session
+//   - path: File to send
+//   - offset: First byte to send (from the arguments or REST)
+//   - length: Number of bytes to send, -1 for the rest of the file
+func sendFile(c *client, path string, offset, length int64) {
+	f, n, ok := openRange(c, RETR, path, offset, length)
+	if !ok {
+		return
+	}
+	defer f.Close()
+
+	data, err := c.openData("Opening data connection for %s (%d bytes)", path, n)
+	if err != nil {
+		c.reply(StatusCannotOpenData, "Cannot open data connection: %v", err)
+		return
+	}
+
+	// Hash while sending so the file is read only once
+	h := sha256.New()
+	sent, err := io.CopyN(io.MultiWriter(data, h), f, n)
+	if closeErr := data.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Printf("RETR %s aborted after %d bytes: %v", path, sent, err)
+		c.reply(StatusTransferAborted, "Transfer aborted after %d bytes", sent)
+		return
+	}
+
+	c.reply(StatusTransferComplete, "Transfer complete, sha256 %s", hex.EncodeToString(h.Sum(nil)))
+	log.Printf("RETR %s: %d bytes from offset %d sent to %s", path, sent, offset, c.conn.RemoteAddr())
+}
+
+// receiveFile handles STOR and PUT: it stores the bytes of the data
+// connection and reports their checksum.
+//
+// With a restart offset the bytes are appended to the existing ".part"
+// file at that offset and the checksum covers the complete file.
+//
+// Parameters:
+//   - c: Client connection and session
+//   - path: Destination file
+//   - offset: Restart offset from REST, 0 for a fresh upload
+//   - expect: Size announced with ALLO, -1 if unknown
+func receiveFile(c *client, path string, offset, expect int64) {
+	part := path + partSuffix
+
+	// Check the restart offset without touching the part file; it is only
+	// opened once the data connection is there, so a STOR that fails
+	// before any data arrives leaves a resumable upload as it was
+	if offset > 0 {
+		info, err := c.sess.Stat(part)
+		if err != nil {
+			c.replyError(STOR, err)
+			return
+		}
+		if info.Size() < offset {
+			c.reply(StatusArgumentError, "restart offset %d beyond partial upload (%d bytes)", offset, info.Size())
+			return
+		}
+	}
+
+	data, err := c.openData("Opening data connection for %s at offset %d", path, offset)
+	if err != nil {
+		c.reply(StatusCannotOpenData, "Cannot open data connection: %v", err)
+		return
+	}
+	defer data.Close()
+
+	flag := os.O_RDWR | os.O_CREATE
+	if offset == 0 {
+		flag |= os.O_TRUNC
+	}
+	f, err := c.sess.OpenFile(part, flag, 0o644)
+	if err != nil {
+		c.replyError(STOR, err)
+		return
+	}
+	defer f.Close()
+
+	// Re-hash the part that is already on disk, then cut off anything
+	// past the offset that may stem from a half written buffer
+	h := sha256.New()
+	if offset > 0 {
+		if have, err := io.CopyN(h, f, offset); err != nil {
+			c.reply(StatusLocalError, "partial upload shrank to %d bytes, restart offset %d", have, offset)
+			return
+		}
+		if err := f.Truncate(offset); err != nil {
+			c.replyError(STOR, err)
+			return
+		}
+	}
+
+	received, err := io.Copy(io.MultiWriter(f, h), data)
+	data.Close()
+	if err == nil && expect >= 0 && received != expect {
+		err = fmt.Errorf("got %d of %d announced bytes", received, expect)
+	}
+	if err != nil {
+		// Keep the part file so the upload can be resumed with REST
+		log.Printf("STOR %s: upload interrupted: %v", path, err)
+		c.reply(StatusTransferAborted, "Transfer aborted: %v; resume with REST %d", err, offset+received)
+		return
+	}
+
+	if err := f.Close(); err != nil {
+		c.sess.Remove(part)
+		c.replyError(STOR, err)
+		return
+	}
+
+	if err := c.sess.Rename(part, path); err != nil {
+		c.sess.Remove(part)
+		c.replyError(STOR, err)
+		return
+	}
+
+	log.Printf("STOR %s: %d bytes at offset %d received from %s", path, received, offset, c.conn.RemoteAddr())
+	c.reply(StatusTransferComplete, "Transfer complete, sha256 %s", hex.EncodeToString(h.Sum(nil)))
+}
+
+// allocate handles ALLO: it records the size of the next upload.
+func allocate(c *client, arg string) {
+	size, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || size < 0 {
+		c.reply(StatusArgumentError, "invalid size %q", arg)
+		return
+	}
+
+	c.allocate = size
+	c.reply(StatusOK, "Expecting %d bytes with the next STOR", size)
+}
+
+// restart handles REST: it arms the offset used by the next RETR or STOR.
+func restart(c *client, arg string) {
+	offset, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || offset < 0 {
+		c.reply(StatusArgumentError, "invalid offset %q", arg)
+		return
+	}
+
+	c.restart = offset
+	c.reply(StatusPendingInfo, "Restarting at %d, send RETR or STOR", offset)
+}
+
+// fileSize handles SIZE.
+func fileSize(c *client, path string) {
+	info, err := c.sess.Stat(path)
+	if err != nil {
+		c.replyError(SIZE, err)
+		return
+	}
+	if info.IsDir() {
+		c.replyError(SIZE, &fs.PathError{Op: "size", Path: path, Err: errIsDir})
+		return
+	}
+	c.reply(StatusFileStatus, "%d", info.Size())
+}
+
+// fileHash handles HASH: the SHA-256 of a file or of a range of it.
+func fileHash(c *client, path string, offset, length int64) {
+	f, n, ok := openRange(c, HASH, path, offset, length)
+	if !ok {
+		return
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.CopyN(h, f, n); err != nil {
+		c.replyError(HASH, err)
+		return
+	}
+	c.reply(StatusFileStatus, "%s", hex.EncodeToString(h.Sum(nil)))
+}
+
+// deleteFile handles DELE and RMD for files and empty directories.
+func deleteFile(c *client, path string) {
+	if err := c.sess.Remove(path); err != nil {
+		c.replyError(DELE, err)
+		return
+	}
+	c.reply(StatusFileActionOK, "Deleted %s", path)
+}
+
+// makeDirectory handles MKD.
+func makeDirectory(c *client, path string) {
+	if err := c.sess.Mkdir(path); err != nil {
+		c.replyError(MKD, err)
+		return
+	}
+	virtual, _, _ := c.sess.Resolve(path)
+	c.reply(StatusPathCreated, "%q created", virtual)
+}
+
+// renameFrom handles RNFR: it remembers the source of the next RNTO.
+func renameFrom(c *client, path string) {
+	if _, err := c.sess.Stat(path); err != nil {
+		c.replyError(RNFR, err)
+		return
+	}
+	c.renameFrom = path
+	c.reply(StatusPendingInfo, "Ready for RNTO")
+}
+
+// renameTo handles RNTO, completing a rename started with RNFR.
+func renameTo(c *client, path string) {
+	from := c.renameFrom
+	c.renameFrom = ""
+
+	if from == "" {
+		c.reply(StatusBadSequence, "Send RNFR first")
+		return
+	}
+	renameFile(c, from, path)
+}
+
+// renameFile handles RENAME and the final step of RNFR/RNTO.
+func renameFile(c *client, from, to string) {
+	if err := c.sess.Rename(from, to); err != nil {
+		c.replyError(RNTO, err)
+		return
+	}
+	c.reply(StatusFileActionOK, "Renamed %s to %s", from, to)
+}
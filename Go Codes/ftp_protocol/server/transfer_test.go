@@ -0,0 +1,270 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// sha256Hex returns the checksum as it appears in 226 replies.
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// retrieve runs a download command such as "RETR <path>" and returns the
+// bytes of the data connection and the 226 reply.
+func retrieve(t *testing.T, text *textproto.Conn, line string) ([]byte, string) {
+	t.Helper()
+	data := openData(t, text)
+	command(t, text, line, StatusTransferStarting, "")
+	got, err := io.ReadAll(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return got, expect(t, text, StatusTransferComplete, "Transfer complete")
+}
+
+// store runs an upload command such as "STOR <path>", sends content and
+// returns the 226 reply.
+func store(t *testing.T, text *textproto.Conn, line string, content []byte) string {
+	t.Helper()
+	data := openData(t, text)
+	command(t, text, line, StatusTransferStarting, "")
+	if _, err := data.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	data.Close()
+	return expect(t, text, StatusTransferComplete, "Transfer complete")
+}
+
+// readFile returns the content of a file below the test root.
+func readFile(t *testing.T, name string) string {
+	t.Helper()
+	b, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// TestTransfer uploads and downloads files with the short commands and
+// their RFC 959 names and checks contents and checksums on both ends.
+func TestTransfer(t *testing.T) {
+	ts := startServer(t, nil)
+	text := dial(t, ts.addr, StatusReady)
+	content := []byte("binary\x00\r\n\xff payload\n")
+
+	msg := store(t, text, "PUT up.bin", content)
+	if want := "Transfer complete, sha256 " + sha256Hex(content); msg != want {
+		t.Errorf("PUT reply %q, want %q", msg, want)
+	}
+	if got := readFile(t, filepath.Join(ts.root, "up.bin")); got != string(content) {
+		t.Errorf("stored %q, want %q", got, content)
+	}
+	if _, err := os.Stat(filepath.Join(ts.root, "up.bin"+partSuffix)); err == nil {
+		t.Error(".part file left after a complete upload")
+	}
+
+	for _, line := range []string{"GET up.bin", "RETR up.bin"} {
+		got, msg := retrieve(t, text, line)
+		if string(got) != string(content) {
+			t.Errorf("%s returned %q, want %q", line, got, content)
+		}
+		if want := "Transfer complete, sha256 " + sha256Hex(content); msg != want {
+			t.Errorf("%s reply %q, want %q", line, msg, want)
+		}
+	}
+
+	// A range covers only the bytes sent, checksum included
+	got, msg := retrieve(t, text, `GET "up.bin" 2 5`)
+	if want := content[2:7]; string(got) != string(want) || msg != "Transfer complete, sha256 "+sha256Hex(want) {
+		t.Errorf("GET range = %q, %q, want %q", got, msg, want)
+	}
+	command(t, text, `GET "up.bin" 100`, StatusArgumentError, "offset 100 beyond end of file")
+	command(t, text, "GET missing.bin", StatusFileUnavailable, "")
+
+	// Announced size that does not arrive: the good file stays
+	openData(t, text).Close()
+	command(t, text, "ALLO 1000", StatusOK, "")
+	command(t, text, "STOR up.bin", StatusTransferStarting, "")
+	expect(t, text, StatusTransferAborted, "Transfer aborted")
+	if got := readFile(t, filepath.Join(ts.root, "up.bin")); got != string(content) {
+		t.Errorf("short upload replaced the file with %q", got)
+	}
+}
+
+// TestPathsWithSpaces uses paths with spaces in every command that takes
+// one; GET and RENAME need quotes only when more arguments follow.
+func TestPathsWithSpaces(t *testing.T) {
+	ts := startServer(t, nil)
+	text := dial(t, ts.addr, StatusReady)
+	content := []byte("lab notes")
+
+	command(t, text, "MKDIR my dir", StatusPathCreated, `"/my dir" created`)
+	command(t, text, "CD my dir", StatusFileActionOK, "Directory changed to /my dir")
+	store(t, text, "PUT lab notes.txt", content)
+	if got := readFile(t, filepath.Join(ts.root, "my dir", "lab notes.txt")); got != string(content) {
+		t.Errorf("stored %q", got)
+	}
+
+	if got, _ := retrieve(t, text, "GET lab notes.txt"); string(got) != string(content) {
+		t.Errorf("GET returned %q", got)
+	}
+	if got, _ := retrieve(t, text, `GET "lab notes.txt" 4`); string(got) != "notes" {
+		t.Errorf("quoted GET with offset returned %q, want notes", got)
+	}
+	command(t, text, `HASH "lab notes.txt" 0 3`, StatusFileStatus, sha256Hex(content[:3]))
+	command(t, text, "HASH lab notes.txt", StatusFileStatus, sha256Hex(content))
+	command(t, text, `GET "lab notes.txt`, StatusArgumentError, "usage")
+
+	command(t, text, `RENAME "lab notes.txt" "old notes.txt"`, StatusFileActionOK, "")
+	command(t, text, "RENAME old notes.txt new.txt", StatusArgumentError, "usage")
+	command(t, text, "RNFR old notes.txt", StatusPendingInfo, "")
+	command(t, text, "RNTO new notes.txt", StatusFileActionOK, "")
+	if _, err := os.Stat(filepath.Join(ts.root, "my dir", "new notes.txt")); err != nil {
+		t.Error(err)
+	}
+
+	command(t, text, "DEL new notes.txt", StatusFileActionOK, "")
+	command(t, text, "CDUP", StatusFileActionOK, "")
+	command(t, text, "RMD my dir", StatusFileActionOK, "")
+	if entries, _ := os.ReadDir(ts.root); len(entries) != 0 {
+		t.Errorf("root not empty after deleting: %v", entries)
+	}
+}
+
+func TestDeleteMkdirRename(t *testing.T) {
+	ts := startServer(t, nil)
+	text := dial(t, ts.addr, StatusReady)
+	mkfile(t, filepath.Join(ts.root, "a.txt"), "a")
+
+	command(t, text, "MKDIR sub", StatusPathCreated, `"/sub" created`)
+	command(t, text, "MKD sub", StatusFileUnavailable, "")
+	command(t, text, "MKDIR ../up", StatusNameNotAllowed, "")
+
+	command(t, text, "RENAME a.txt sub/b.txt", StatusFileActionOK, "Renamed a.txt to sub/b.txt")
+	command(t, text, "RENAME missing.txt c.txt", StatusFileUnavailable, "")
+	command(t, text, "RENAME sub/b.txt ../b.txt", StatusNameNotAllowed, "")
+	command(t, text, "RNTO c.txt", StatusBadSequence, "Send RNFR first")
+	if got := readFile(t, filepath.Join(ts.root, "sub", "b.txt")); got != "a" {
+		t.Errorf("renamed file holds %q", got)
+	}
+
+	command(t, text, "DEL sub", StatusFileUnavailable, "")
+	command(t, text, "DEL sub/b.txt", StatusFileActionOK, "")
+	command(t, text, "DEL sub/b.txt", StatusFileUnavailable, "")
+	command(t, text, "DEL /", StatusFileUnavailable, "")
+	command(t, text, "DEL sub", StatusFileActionOK, "")
+	if _, err := os.Stat(filepath.Join(ts.root, "sub")); err == nil {
+		t.Error("directory still there after DEL")
+	}
+}
+
+// TestStoreWithoutData checks that a STOR that never gets its data
+// connection leaves a partial upload untouched, so it can still be
+// resumed, and does not create an empty one.
+func TestStoreWithoutData(t *testing.T) {
+	ts := startServer(t, nil)
+	text := dial(t, ts.addr, StatusReady)
+	part := filepath.Join(ts.root, "big.bin"+partSuffix)
+	mkfile(t, part, "first half")
+
+	command(t, text, "STOR big.bin", StatusCannotOpenData, "")
+	command(t, text, "REST 5", StatusPendingInfo, "")
+	command(t, text, "STOR big.bin", StatusCannotOpenData, "")
+	if got := readFile(t, part); got != "first half" {
+		t.Errorf(".part file is %q after STOR without PASV, want it unchanged", got)
+	}
+
+	command(t, text, "REST 100", StatusPendingInfo, "")
+	command(t, text, "STOR big.bin", StatusArgumentError, "restart offset 100 beyond partial upload (10 bytes)")
+
+	command(t, text, "STOR other.bin", StatusCannotOpenData, "")
+	if _, err := os.Stat(filepath.Join(ts.root, "other.bin"+partSuffix)); err == nil {
+		t.Error("empty .part file created by a STOR without PASV")
+	}
+}
+
+// TestResumeUpload breaks an upload off halfway, then resumes it the way
+// reput does: SIZE of the ".part" file, HASH of that prefix, REST and STOR
+// of the rest. The final checksum covers the whole file.
+func TestResumeUpload(t *testing.T) {
+	ts := startServer(t, nil)
+	text := dial(t, ts.addr, StatusReady)
+	content := make([]byte, 1<<20)
+	for i := range content {
+		content[i] = byte(i * 7)
+	}
+	half := len(content) / 2
+
+	data := openData(t, text)
+	command(t, text, fmt.Sprintf("ALLO %d", len(content)), StatusOK, "")
+	command(t, text, "STOR fw image.bin", StatusTransferStarting, "")
+	if _, err := data.Write(content[:half]); err != nil {
+		t.Fatal(err)
+	}
+	data.Close()
+	expect(t, text, StatusTransferAborted, fmt.Sprintf("Transfer aborted: got %d of %d announced bytes; resume with REST %d", half, len(content), half))
+	if _, err := os.Stat(filepath.Join(ts.root, "fw image.bin")); err == nil {
+		t.Fatal("interrupted upload was put in place")
+	}
+
+	size := command(t, text, "SIZE fw image.bin"+partSuffix, StatusFileStatus, "")
+	if size != strconv.Itoa(half) {
+		t.Fatalf("SIZE of the part file = %s, want %d", size, half)
+	}
+	command(t, text, fmt.Sprintf(`HASH "fw image.bin%s" 0 %s`, partSuffix, size), StatusFileStatus, sha256Hex(content[:half]))
+
+	data = openData(t, text)
+	command(t, text, "ALLO "+strconv.Itoa(len(content)-half), StatusOK, "")
+	command(t, text, "REST "+size, StatusPendingInfo, "")
+	command(t, text, "STOR fw image.bin", StatusTransferStarting, "Opening data connection for fw image.bin at offset "+size)
+	if _, err := data.Write(content[half:]); err != nil {
+		t.Fatal(err)
+	}
+	data.Close()
+	expect(t, text, StatusTransferComplete, "Transfer complete, sha256 "+sha256Hex(content))
+
+	if got := readFile(t, filepath.Join(ts.root, "fw image.bin")); got != string(content) {
+		t.Error("resumed upload differs from the original")
+	}
+	command(t, text, "HASH fw image.bin", StatusFileStatus, sha256Hex(content))
+	command(t, text, "SIZE fw image.bin"+partSuffix, StatusFileUnavailable, "")
+}
+
+// TestResumeDownload resumes a download with REST and with a GET range.
+func TestResumeDownload(t *testing.T) {
+	ts := startServer(t, nil)
+	text := dial(t, ts.addr, StatusReady)
+	mkfile(t, filepath.Join(ts.root, "log.txt"), "0123456789")
+
+	command(t, text, "REST 4", StatusPendingInfo, "")
+	got, msg := retrieve(t, text, "RETR log.txt")
+	if string(got) != "456789" || msg != "Transfer complete, sha256 "+sha256Hex(got) {
+		t.Errorf("RETR after REST 4 = %q, %s", got, msg)
+	}
+
+	// The offset only applies to the next transfer
+	if got, _ := retrieve(t, text, "RETR log.txt"); string(got) != "0123456789" {
+		t.Errorf("second RETR = %q, want the whole file", got)
+	}
+	command(t, text, "REST 4", StatusPendingInfo, "")
+	command(t, text, NOOP, StatusOK, "")
+	if got, _ := retrieve(t, text, "RETR log.txt"); string(got) != "0123456789" {
+		t.Errorf("RETR after REST, NOOP = %q, want the whole file", got)
+	}
+
+	command(t, text, "REST x", StatusArgumentError, "invalid offset")
+	command(t, text, "REST 11", StatusPendingInfo, "")
+	openData(t, text)
+	command(t, text, "RETR log.txt", StatusArgumentError, "offset 11 beyond end of file")
+	command(t, text, `HASH "log.txt" 8`, StatusFileStatus, sha256Hex([]byte("89")))
+	command(t, text, `HASH "log.txt" 2 x`, StatusArgumentError, "invalid length")
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// Bus is a small in-process publish/subscribe message bus.
+//
+// Messages are published to named topics and fanned out to every
+// subscriber of that topic. Each subscriber owns a buffered channel and a
+// delivery policy that decides what happens when that buffer is full:
+//
+//	┌───────────┐  Publish("devices.up")  ┌─────┐   ┌──────────────┐
+//	│ Publisher │────────────────────────▶│ Bus │──▶│ Subscriber 1 │
+//	└───────────┘                         │     │   └──────────────┘
+//	                                      │     │   ┌──────────────┐
+//	                                      │     │──▶│ Subscriber 2 │
+//	                                      └─────┘   └──────────────┘
+//
+// The bus is generic over the payload type so that every subscriber of a
+// bus receives the same, statically typed message.
+type Bus[T any] struct {
+	mu     sync.RWMutex
+	topics map[string]map[uint64]*Subscription[T]
+	closed atomic.Bool
+	nextID atomic.Uint64
+}
+
+// DeliveryPolicy decides what Publish does when a subscriber's buffer is full.
+type DeliveryPolicy int
+
+const (
+	// Block waits until the subscriber has room or is unsubscribed.
+	Block DeliveryPolicy = iota
+	// Drop discards the message for that subscriber and counts it.
+	Drop
+)
+
+// String returns the policy name used in log output.
+func (p DeliveryPolicy) String() string {
+	switch p {
+	case Block:
+		return "block"
+	case Drop:
+		return "drop"
+	default:
+		return fmt.Sprintf("DeliveryPolicy(%d)", int(p))
+	}
+}
+
+// Message is the envelope delivered to subscribers.
+//
+// ReplyTo and CorrelationID are only set for messages sent with Request;
+// a responder answers them with Bus.Reply.
+type Message[T any] struct {
+	Topic         string
+	Payload       T
+	ReplyTo       string
+	CorrelationID uint64
+}
+
+// Errors returned by the bus.
+var (
+	ErrBusClosed   = errors.New("bus: closed")
+	ErrEmptyTopic  = errors.New("bus: empty topic")
+	ErrNoReplyTo   = errors.New("bus: message has no reply topic")
+	ErrNoResponder = errors.New("bus: no subscribers for request topic")
+)
+
+// inboxPrefix is the topic namespace used for request/reply inboxes.
+const inboxPrefix = "_inbox."
+
+// Subscription is a single subscriber's view of a topic.
+type Subscription[T any] struct {
+	// C delivers messages in publish order. It is closed after
+	// Unsubscribe or Bus.Close.
+	C <-chan Message[T]
+
+	bus     *Bus[T]
+	id      uint64
+	topic   string
+	policy  DeliveryPolicy
+	ch      chan Message[T]
+	done    chan struct{}
+	sending sync.RWMutex // held by senders, taken exclusively to close ch
+	stopped sync.Once
+	removed sync.Once
+	dropped atomic.Uint64
+}
+
+// NewBus returns an empty, running bus.
+func NewBus[T any]() *Bus[T] {
+	return &Bus[T]{topics: make(map[string]map[uint64]*Subscription[T])}
+}
+
+// Subscribe registers a new subscriber on topic with the given buffer size
+// and delivery policy.
+func (b *Bus[T]) Subscribe(topic string, buffer int, policy DeliveryPolicy) (*Subscription[T], error) {
+	if topic == "" {
+		return nil, ErrEmptyTopic
+	}
+	if buffer < 0 {
+		buffer = 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed.Load() {
+		return nil, ErrBusClosed
+	}
+
+	ch := make(chan Message[T], buffer)
+	sub := &Subscription[T]{
+		C:      ch,
+		bus:    b,
+		id:     b.nextID.Add(1),
+		topic:  topic,
+		policy: policy,
+		ch:     ch,
+		done:   make(chan struct{}),
+	}
+
+	subs, ok := b.topics[topic]
+	if !ok {
+		subs = make(map[uint64]*Subscription[T])
+		b.topics[topic] = subs
+	}
+	subs[sub.id] = sub
+
+	return sub, nil
+}
+
+// Publish delivers payload to every current subscriber of topic and
+// returns how many subscribers received it.
+func (b *Bus[T]) Publish(topic string, payload T) (int, error) {
+	if topic == "" {
+		return 0, ErrEmptyTopic
+	}
+	return b.publish(Message[T]{Topic: topic, Payload: payload})
+}
+
+// publish fans msg out to the topic's subscribers.
+//
+// The subscribers are copied under the read lock and the sends happen
+// after it is released, so a publisher blocked on a slow consumer never
+// holds up Subscribe, Unsubscribe, Close or other publishers such as
+// Reply. Each send holds the subscription's own lock instead, which keeps
+// its channel open until the send is over.
+func (b *Bus[T]) publish(msg Message[T]) (int, error) {
+	b.mu.RLock()
+	if b.closed.Load() {
+		b.mu.RUnlock()
+		return 0, ErrBusClosed
+	}
+	subs := make([]*Subscription[T], 0, len(b.topics[msg.Topic]))
+	for _, sub := range b.topics[msg.Topic] {
+		subs = append(subs, sub)
+	}
+	b.mu.RUnlock()
+
+	delivered := 0
+	for _, sub := range subs {
+		if sub.deliver(msg) {
+			delivered++
+		}
+	}
+
+	return delivered, nil
+}
+
+// deliver hands msg to the subscriber according to its policy. It gives
+// up once the subscription is stopped; done is closed before ch, so a
+// sender that sees done open may still send.
+func (s *Subscription[T]) deliver(msg Message[T]) bool {
+	s.sending.RLock()
+	defer s.sending.RUnlock()
+
+	select {
+	case <-s.done:
+		return false
+	default:
+	}
+
+	switch s.policy {
+	case Drop:
+		select {
+		case s.ch <- msg:
+			return true
+		case <-s.done:
+			return false
+		default:
+			s.dropped.Add(1)
+			return false
+		}
+	default:
+		select {
+		case s.ch <- msg:
+			return true
+		case <-s.done:
+			return false
+		}
+	}
+}
+
+// Topic returns the topic the subscription listens on.
+func (s *Subscription[T]) Topic() string {
+	return s.topic
+}
+
+// Dropped returns how many messages were discarded because the buffer
+// was full. It is always zero for the Block policy.
+func (s *Subscription[T]) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// stop releases any publisher blocked on this subscription.
+func (s *Subscription[T]) stop() {
+	s.stopped.Do(func() { close(s.done) })
+}
+
+// Unsubscribe removes the subscription from the bus and closes C.
+// It is safe to call more than once.
+//
+// Stopping first releases publishers blocked on this subscription, so
+// closing C only waits for sends that are already finishing.
+func (s *Subscription[T]) Unsubscribe() {
+	s.stop()
+
+	s.removed.Do(func() {
+		b := s.bus
+		b.mu.Lock()
+		if subs, ok := b.topics[s.topic]; ok {
+			delete(subs, s.id)
+			if len(subs) == 0 {
+				delete(b.topics, s.topic)
+			}
+		}
+		b.mu.Unlock()
+
+		s.sending.Lock()
+		close(s.ch)
+		s.sending.Unlock()
+	})
+}
+
+// Request publishes payload on topic and waits for the first reply.
+//
+// A private inbox topic is subscribed for the duration of the call and
+// the outgoing message carries its name in ReplyTo together with a
+// CorrelationID. Replies with a different CorrelationID are ignored.
+func (b *Bus[T]) Request(ctx context.Context, topic string, payload T) (T, error) {
+	var zero T
+
+	if topic == "" {
+		return zero, ErrEmptyTopic
+	}
+
+	id := b.nextID.Add(1)
+	inbox, err := b.Subscribe(fmt.Sprintf("%s%d", inboxPrefix, id), 1, Block)
+	if err != nil {
+		return zero, err
+	}
+	defer inbox.Unsubscribe()
+
+	n, err := b.publish(Message[T]{
+		Topic:         topic,
+		Payload:       payload,
+		ReplyTo:       inbox.Topic(),
+		CorrelationID: id,
+	})
+	if err != nil {
+		return zero, err
+	}
+	if n == 0 {
+		return zero, ErrNoResponder
+	}
+
+	for {
+		select {
+		case reply, ok := <-inbox.C:
+			if !ok {
+				return zero, ErrBusClosed
+			}
+			if reply.CorrelationID == id {
+				return reply.Payload, nil
+			}
+		case <-ctx.Done():
+			return zero, ctx.Err()
+		}
+	}
+}
+
+// Reply answers a message received from Request.
+func (b *Bus[T]) Reply(req Message[T], payload T) error {
+	if req.ReplyTo == "" {
+		return ErrNoReplyTo
+	}
+
+	_, err := b.publish(Message[T]{
+		Topic:         req.ReplyTo,
+		Payload:       payload,
+		CorrelationID: req.CorrelationID,
+	})
+	return err
+}
+
+// Close shuts the bus down. Pending publishers are released, every
+// subscription channel is closed and further calls return ErrBusClosed.
+func (b *Bus[T]) Close() {
+	if b.closed.Swap(true) {
+		return
+	}
+
+	// Wake every blocked publisher before closing any channel.
+	b.mu.RLock()
+	var subs []*Subscription[T]
+	for _, topic := range b.topics {
+		for _, sub := range topic {
+			subs = append(subs, sub)
+		}
+	}
+	b.mu.RUnlock()
+
+	for _, sub := range subs {
+		sub.stop()
+	}
+	for _, sub := range subs {
+		sub.Unsubscribe()
+	}
+}
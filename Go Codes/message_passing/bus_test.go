@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// Run with: go test *.go
+
+// published runs Publish in a goroutine and returns its result on a channel,
+// for publishes that are expected to block.
+func published(bus *Bus[string], topic, payload string) <-chan error {
+	result := make(chan error, 1)
+	go func() {
+		_, err := bus.Publish(topic, payload)
+		result <- err
+	}()
+	return result
+}
+
+// blocked checks that result stays empty for a while.
+func blocked(t *testing.T, result <-chan error) {
+	t.Helper()
+	select {
+	case err := <-result:
+		t.Fatalf("publish returned %v while the subscriber was full", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// returned waits for the result of a blocked call.
+func returned(t *testing.T, result <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(2 * time.Second):
+		t.Fatal("call still blocked")
+		return nil
+	}
+}
+
+func TestBlock(t *testing.T) {
+	bus := NewBus[string]()
+	defer bus.Close()
+	sub, err := bus.Subscribe("events", 1, Block)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := bus.Publish("events", "a"); n != 1 || err != nil {
+		t.Fatalf("Publish = %d, %v, want 1, nil", n, err)
+	}
+	result := published(bus, "events", "b")
+	blocked(t, result)
+
+	if msg := <-sub.C; msg.Payload != "a" {
+		t.Errorf("got %q, want a", msg.Payload)
+	}
+	if err := returned(t, result); err != nil {
+		t.Fatal(err)
+	}
+	if msg := <-sub.C; msg.Payload != "b" {
+		t.Errorf("got %q, want b", msg.Payload)
+	}
+	if sub.Dropped() != 0 {
+		t.Errorf("Dropped = %d with the Block policy", sub.Dropped())
+	}
+
+	// Unsubscribing releases a publisher blocked on that subscriber
+	bus.Publish("events", "c")
+	result = published(bus, "events", "d")
+	blocked(t, result)
+	sub.Unsubscribe()
+	if err := returned(t, result); err != nil {
+		t.Fatal(err)
+	}
+	if msg, ok := <-sub.C; !ok || msg.Payload != "c" {
+		t.Errorf("got %q, %v, want the buffered c", msg.Payload, ok)
+	}
+	if _, ok := <-sub.C; ok {
+		t.Error("C still open after Unsubscribe")
+	}
+}
+
+func TestDrop(t *testing.T) {
+	bus := NewBus[string]()
+	defer bus.Close()
+	sub, err := bus.Subscribe("events", 1, Drop)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, want := range []int{1, 0, 0} {
+		if n, err := bus.Publish("events", "x"); n != want || err != nil {
+			t.Fatalf("Publish #%d = %d, %v, want %d, nil", i, n, err, want)
+		}
+	}
+	if sub.Dropped() != 2 {
+		t.Errorf("Dropped = %d, want 2", sub.Dropped())
+	}
+	<-sub.C
+	if n, _ := bus.Publish("events", "y"); n != 1 {
+		t.Errorf("Publish after reading = %d, want 1", n)
+	}
+}
+
+func TestRequestReply(t *testing.T) {
+	bus := NewBus[string]()
+	defer bus.Close()
+	responder, err := bus.Subscribe("version", 1, Block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for req := range responder.C {
+			bus.Reply(req, req.Payload+" 1.0")
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if got, err := bus.Request(ctx, "version", "r1"); got != "r1 1.0" || err != nil {
+		t.Errorf("Request = %q, %v, want r1 1.0", got, err)
+	}
+
+	if _, err := bus.Request(ctx, "nobody", "r1"); !errors.Is(err, ErrNoResponder) {
+		t.Errorf("Request without subscribers = %v, want ErrNoResponder", err)
+	}
+	if err := bus.Reply(Message[string]{Topic: "version"}, "x"); !errors.Is(err, ErrNoReplyTo) {
+		t.Errorf("Reply without ReplyTo = %v, want ErrNoReplyTo", err)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	bus := NewBus[string]()
+	defer bus.Close()
+	silent, err := bus.Subscribe("version", 1, Block)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := bus.Request(ctx, "version", "r1"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Request = %v, want DeadlineExceeded", err)
+	}
+
+	// A late reply goes to an inbox that no longer exists
+	req := <-silent.C
+	if err := bus.Reply(req, "late"); err != nil {
+		t.Errorf("late Reply = %v", err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	bus := NewBus[string]()
+	slow, err := bus.Subscribe("events", 0, Block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := published(bus, "events", "a")
+	blocked(t, result)
+
+	bus.Close()
+	if err := returned(t, result); err != nil {
+		t.Errorf("blocked Publish = %v, want it released", err)
+	}
+	if _, ok := <-slow.C; ok {
+		t.Error("C still open after Close")
+	}
+	if _, err := bus.Publish("events", "b"); !errors.Is(err, ErrBusClosed) {
+		t.Errorf("Publish after Close = %v, want ErrBusClosed", err)
+	}
+	if _, err := bus.Subscribe("events", 1, Block); !errors.Is(err, ErrBusClosed) {
+		t.Errorf("Subscribe after Close = %v, want ErrBusClosed", err)
+	}
+	bus.Close()
+	slow.Unsubscribe()
+}
+
+// TestBlockedPublish checks that a publisher stuck on a slow consumer does
+// not hold up the rest of the bus: an Unsubscribe on another topic and a
+// Request/Reply both go through while it waits.
+func TestBlockedPublish(t *testing.T) {
+	bus := NewBus[string]()
+	defer bus.Close()
+	slow, err := bus.Subscribe("events", 0, Block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slow.Unsubscribe()
+	other, err := bus.Subscribe("other", 1, Block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	responder, err := bus.Subscribe("version", 1, Block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for req := range responder.C {
+			bus.Reply(req, "1.0")
+		}
+	}()
+
+	result := published(bus, "events", "a")
+	blocked(t, result)
+
+	unsubscribed := make(chan error, 1)
+	go func() {
+		other.Unsubscribe()
+		unsubscribed <- nil
+	}()
+	returned(t, unsubscribed)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, err := bus.Request(ctx, "version", "r1"); err != nil {
+		t.Errorf("Request while a publish is blocked = %v", err)
+	}
+
+	<-slow.C
+	if err := returned(t, result); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -12,6 +15,73 @@ func worker(messages chan string) { // Message değişkeni üzerinden string mes
 	}
 }
 
+// busDemo aynı fikri topic'ler ve birden fazla abone ile gösterir
+func busDemo() error {
+	bus := NewBus[string]()
+
+	// Hata ile dönülse bile Close kanalları kapatır, goroutine'ler beklenir
+	var wg sync.WaitGroup
+	defer func() {
+		bus.Close()
+		wg.Wait()
+	}()
+
+	// Aynı topic'e iki abone: biri bekler (Block), diğeri doluysa mesajı atar (Drop)
+	logger, err := bus.Subscribe("device.events", 8, Block)
+	if err != nil {
+		return err
+	}
+	metrics, err := bus.Subscribe("device.events", 1, Drop)
+	if err != nil {
+		return err
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for msg := range logger.C {
+			fmt.Println("[logger]", msg.Topic, msg.Payload)
+		}
+	}()
+
+	// İstek/cevap: "device.version" topic'ine gelen isteklere cevap veren servis
+	responder, err := bus.Subscribe("device.version", 4, Block)
+	if err != nil {
+		return err
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for req := range responder.C {
+			if err := bus.Reply(req, strings.ToUpper(req.Payload)+" -> 17.9.4"); err != nil {
+				fmt.Println("Reply error:", err)
+			}
+		}
+	}()
+
+	for _, ev := range []string{"R1 up", "R2 up", "R3 down"} {
+		if _, err := bus.Publish("device.events", ev); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	version, err := bus.Request(ctx, "device.version", "r1")
+	if err != nil {
+		fmt.Println("Request error:", err)
+	} else {
+		fmt.Println("[request]", version)
+	}
+
+	// metrics hiç okumadığı için tek mesajlık buffer dolu, geri kalanlar atıldı
+	fmt.Printf("[metrics] policy=%s dropped=%d\n", Drop, metrics.Dropped())
+
+	bus.Close() // Tüm abonelik kanalları kapanır, goroutine'ler biter
+	return nil
+}
+
 func main() {
 	messages := make(chan string) // Yeni kanal oluştudum bu kanal string türünde veri taşır | goroutine’ler arası veri boru hattı
 	go worker(messages)           // go parametresiyle fonksiyon ayrı bir goroutine oalrak çalışmaya başlar arka planda eşzamanlı çalışır
@@ -23,4 +93,8 @@ func main() {
 	close(messages) // Kanalımızı kapatırız
 
 	time.Sleep(time.Second)
+
+	if err := busDemo(); err != nil {
+		fmt.Println("Bus error:", err)
+	}
 }
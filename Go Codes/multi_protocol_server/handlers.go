@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Person gob_echo istemcisinin gönderdiği tiptir; gob alanları adlarıyla
+// eşlediği için alan adları istemcidekiyle aynı olmalıdır
+type Person struct {
+	Name  Name
+	Email []Email
+}
+
+type Name struct {
+	Family   string
+	Personal string
+}
+
+type Email struct {
+	Kind    string
+	Address string
+}
+
+// handleGobClient gelen her Person'ı geri gönderir
+func handleGobClient(conn net.Conn) {
+	defer conn.Close()
+	decoder := gob.NewDecoder(conn)
+	encoder := gob.NewEncoder(conn)
+
+	for {
+		var person Person
+		if err := decoder.Decode(&person); err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Println("Gob decode error:", err)
+			}
+			return
+		}
+		log.Printf("Gob received: %+v", person)
+
+		if err := encoder.Encode(person); err != nil {
+			log.Println("Gob encode error:", err)
+			return
+		}
+	}
+}
+
+// newHTTPHandler HTTP (ve TLS üzerinden HTTP) isteklerini karşılar
+func newHTTPHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintln(w, "multi_protocol_server: this port speaks HTTP/1.1, TLS, gob and line echo")
+		fmt.Fprintln(w, "try /echo?msg=hello")
+	})
+
+	mux.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+		message := r.URL.Query().Get("msg")
+		if r.Method == http.MethodPost {
+			body, err := io.ReadAll(io.LimitReader(r.Body, 64*1024))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			message = string(body)
+		}
+		message = strings.TrimSpace(message)
+		log.Printf("HTTP Received : %s", message)
+		fmt.Fprintf(w, "HTTP ECHO %s\n", message)
+	})
+
+	return mux
+}
@@ -1,6 +1,20 @@
+// TCP ve UDP :1200'de dinler. TCP portu ilk baytlara bakıp HTTP/1.1,
+// TLS, gob ve satır echo'yu ayırt eder (bkz. mux.go). Çalıştırmak için:
+//
+//	go run multiprotocolserver.go mux.go tls.go handlers.go
+//	go run multiprotocolserver.go mux.go tls.go handlers.go -sni http.lab=http,gob.lab=gob,echo.lab=echo
+//
+// Denemek için:
+//
+//	telnet localhost 1200                                   # satır echo
+//	curl localhost:1200/echo?msg=merhaba                     # HTTP
+//	curl -k https://localhost:1200/                          # TLS, içi koklanır
+//	openssl s_client -connect localhost:1200 -servername echo.lab  # TLS, SNI ile echo
+//	go run ../gob_echo/gob_echo_client/client.go localhost:1200     # gob
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"net"
@@ -10,11 +24,23 @@ import (
 
 func main() {
 
+	sni := flag.String("sni", "", "TLS routes by SNI, e.g. http.lab=http,gob.lab=gob,echo.lab=echo")
+	certFile := flag.String("cert", "", "TLS certificate file (a self-signed one is generated if empty)")
+	keyFile := flag.String("key", "", "TLS key file")
+	flag.Parse()
+
 	tcpService := ":1200"
 	udpService := ":1200"
 
+	sniRoutes, err := parseSNIRoutes(*sni)
+	checkError(err)
+
 	// TCP listener başlat
-	go startTCPServer(tcpService)
+	listener, err := net.Listen("tcp", tcpService)
+	checkError(err)
+	rt, err := newRouter(listener.Addr(), sniRoutes, *certFile, *keyFile)
+	checkError(err)
+	go startTCPServer(listener, rt)
 
 	// UDP listener başlat
 	go startUDPServer(udpService)
@@ -24,10 +50,8 @@ func main() {
 	}
 }
 
-func startTCPServer(service string) {
-	listener, err := net.Listen("tcp", service)
-	checkError(err)
-	log.Printf("TCP Server listening on %s\n", service)
+func startTCPServer(listener net.Listener, rt *router) {
+	log.Printf("TCP Server listening on %s (http, tls, gob, echo)\n", listener.Addr())
 
 	for {
 		conn, err := listener.Accept()
@@ -35,7 +59,7 @@ func startTCPServer(service string) {
 			log.Println("TCP Accept error:", err)
 			continue
 		}
-		go handleTCPClient(conn)
+		go routeTCPClient(conn, rt)
 	}
 }
 
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"log"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tek port, birden çok protokol: TCP bağlantısının ilk baytlarına bakılır
+// (sniffing) ve bağlantı uygun işleyiciye verilir:
+//
+//	0x16 0x03 ...                  TLS, işleyici SNI'ye göre seçilir (-sni)
+//	"GET / HTTP/1.1" gibi satır    HTTP/1.1
+//	gob tip tanımı                 gob echo (gob_echo istemcisi)
+//	diğer her şey                  satır echo (eski davranış)
+//
+// TLS bağlantısında SNI için -sni ile bir yol verilmemişse çözülen akış
+// aynı kurallarla yeniden koklanır; "curl -k https://localhost:1200"
+// böylece HTTP'ye düşer.
+
+// Protokol adları, -sni yollarında da bunlar kullanılır
+const (
+	protoTLS  = "tls"
+	protoHTTP = "http"
+	protoGob  = "gob"
+	protoEcho = "echo"
+)
+
+// sniffTimeout ilk bayttan sonra protokolün belli olması için beklenen süre
+const sniffTimeout = time.Second
+
+// maxRequestLine HTTP istek satırı için bakılan en fazla bayt
+const maxRequestLine = 4096
+
+var httpMethods = []string{"GET", "HEAD", "POST", "PUT", "DELETE", "OPTIONS", "PATCH", "CONNECT", "TRACE"}
+
+// peekedConn koklanan baytları kaybetmemek için okumayı bufio.Reader'dan yapar
+type peekedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *peekedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// routeTCPClient bağlantının protokolünü bulur ve işleyicisine verir
+func routeTCPClient(conn net.Conn, rt *router) {
+	br := bufio.NewReaderSize(conn, maxRequestLine)
+	proto, err := sniff(conn, br, true)
+	if err != nil {
+		log.Println("TCP Read error:", err)
+		conn.Close()
+		return
+	}
+	log.Printf("TCP %s: %s", conn.RemoteAddr(), proto)
+	rt.serve(proto, &peekedConn{Conn: conn, r: br})
+}
+
+// sniff akışın başına bakıp protokol adını döndürür. Baytlar br'de
+// kalır, işleyici onları yeniden okur. withTLS false ise (TLS'in içi)
+// TLS aranmaz.
+func sniff(conn net.Conn, br *bufio.Reader, withTLS bool) (string, error) {
+	// İlk bayt için süre sınırı yok: telnet kullanıcısı hemen yazmayabilir
+	if _, err := br.Peek(1); err != nil {
+		return "", err
+	}
+	conn.SetReadDeadline(time.Now().Add(sniffTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	// ClientHello ya da gob başlığı birkaç okumaya bölünüp gelebilir;
+	// gelenler bunlardan birinin başı olabiliyorsa eksik baytlar beklenir
+	head, _ := br.Peek(br.Buffered())
+	need := gobNeed(head)
+	if withTLS && head[0] == 0x16 {
+		need = 3
+	}
+	if len(head) < need {
+		head, _ = br.Peek(need) // süre dolarsa gelenlerle karar verilir
+	}
+	switch {
+	case withTLS && isTLS(head):
+		return protoTLS, nil
+	case isGob(head):
+		return protoGob, nil
+	case head[0] >= 'A' && head[0] <= 'Z' && isHTTP(br):
+		return protoHTTP, nil
+	}
+	return protoEcho, nil
+}
+
+// isTLS TLS el sıkışma kaydının başlığını tanır: tip 0x16, sürüm 3.x
+func isTLS(b []byte) bool {
+	return len(b) >= 3 && b[0] == 0x16 && b[1] == 0x03 && b[2] <= 0x04
+}
+
+// isGob gob akışının başını tanır. Akış mesaj uzunluğuyla başlar, ilk
+// mesaj da bir tip tanımıdır, yani tip kimliği negatiftir. Kullanıcı
+// tiplerinin kimliği 64'ten başlar: -64 0x7F, -65 ve ötesi 0xFF 0x81..
+// ya da 0xFE .. diye kodlanır. Düz metinde bu baytlar geçmez.
+func isGob(b []byte) bool {
+	if len(b) == 0 {
+		return false
+	}
+
+	// Uzunluk 0x7F'e kadar tek bayt, büyükse önce bayt sayısı (-n) gelir
+	skip := 1
+	switch {
+	case b[0] >= 0xF8:
+		skip += int(-int8(b[0]))
+	case b[0] >= 0x80:
+		return false
+	}
+	if len(b) < skip+3 {
+		return false
+	}
+
+	id := b[skip:]
+	switch id[0] {
+	case 0x7F:
+		return true
+	case 0xFF:
+		return id[1] >= 0x81 && id[1]&1 == 1
+	case 0xFE:
+		return id[2]&1 == 1
+	}
+	return false
+}
+
+// gobNeed isGob'un karar vermek için kaç bayta baktığını döndürür. b'nin
+// gelmiş kısmı bir gob akışının başı olamıyorsa 0 döner, beklemeye gerek
+// kalmaz; düz metin çoğunlukla ikinci baytında elenir.
+func gobNeed(b []byte) int {
+	if len(b) == 0 {
+		return 1
+	}
+	skip := 1
+	switch {
+	case b[0] >= 0xF8:
+		skip += int(-int8(b[0]))
+	case b[0] >= 0x80:
+		return 0
+	}
+	if len(b) > skip && b[skip] != 0x7F && b[skip] != 0xFF && b[skip] != 0xFE {
+		return 0
+	}
+	return skip + 3
+}
+
+// isHTTP ilk satırın "METHOD hedef HTTP/1.x" olup olmadığına bakar.
+// Satır sonu gelene kadar (en fazla sniffTimeout) bekler.
+func isHTTP(br *bufio.Reader) bool {
+	for {
+		b, _ := br.Peek(br.Buffered())
+		if i := bytes.IndexByte(b, '\n'); i >= 0 {
+			fields := strings.Fields(string(b[:i]))
+			return len(fields) == 3 &&
+				slices.Contains(httpMethods, fields[0]) &&
+				strings.HasPrefix(fields[2], "HTTP/1.")
+		}
+		if len(b) == maxRequestLine {
+			return false // satır çok uzun
+		}
+		if _, err := br.Peek(len(b) + 1); err != nil {
+			return false // süre doldu
+		}
+	}
+}
+
+// connListener koklanmış bağlantıları http.Server'a net.Listener gibi verir
+type connListener struct {
+	conns chan net.Conn
+	addr  net.Addr
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newConnListener(addr net.Addr) *connListener {
+	return &connListener{conns: make(chan net.Conn), addr: addr, done: make(chan struct{})}
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *connListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.addr
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/gob"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// go.mod olmadığı için testler dosya listesiyle çalışır:
+//
+//	go test *.go
+
+// testPerson gob testlerinde gönderilen değerdir
+var testPerson = Person{
+	Name:  Name{Family: "Newmarch", Personal: "Jan"},
+	Email: []Email{{Kind: "home", Address: "jan@newmarch.name"}},
+}
+
+// gobStream testPerson'ın gob akışının başıdır: uzunluk ve tip tanımı
+func gobStream(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(testPerson); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestIsTLS(t *testing.T) {
+	for _, tc := range []struct {
+		in   []byte
+		want bool
+	}{
+		{[]byte{0x16, 0x03, 0x01, 0x02, 0x00}, true},
+		{[]byte{0x16, 0x03, 0x04}, true},
+		{[]byte{0x16, 0x03, 0x05}, false},
+		{[]byte{0x16, 0x03}, false},
+		{[]byte{0x16}, false},
+		{[]byte{0x17, 0x03, 0x03}, false},
+		{[]byte("GET / HTTP/1.1\r\n"), false},
+		{nil, false},
+	} {
+		if got := isTLS(tc.in); got != tc.want {
+			t.Errorf("isTLS(% x) = %v, beklenen %v", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestIsGob(t *testing.T) {
+	stream := gobStream(t)
+	for _, tc := range []struct {
+		name string
+		in   []byte
+		want bool
+	}{
+		{"gerçek akış", stream, true},
+		{"id -64", []byte{0x05, 0x7F, 0x03, 0x01}, true},
+		{"id -65", []byte{0x05, 0xFF, 0x81, 0x03}, true},
+		{"id -65 pozitif", []byte{0x05, 0xFF, 0x82, 0x03}, false},
+		{"iki baytlık id", []byte{0x05, 0xFE, 0x01, 0x01}, true},
+		{"uzun mesaj", []byte{0xFE, 0x01, 0x00, 0xFF, 0x81, 0x03}, true},
+		{"eksik", []byte{0x05, 0x7F}, false},
+		{"metin", []byte("hello\n"), false},
+		{"uzunluk değil", []byte{0x85, 0x7F, 0x03, 0x01}, false},
+		{"boş", nil, false},
+	} {
+		if got := isGob(tc.in); got != tc.want {
+			t.Errorf("%s: isGob(% x) = %v, beklenen %v", tc.name, tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestGobNeed(t *testing.T) {
+	for _, tc := range []struct {
+		in   []byte
+		want int
+	}{
+		{nil, 1},
+		{[]byte{0x05}, 4},
+		{[]byte{0x05, 0x7F}, 4},
+		{[]byte{0xFE}, 6},
+		{[]byte{0xFE, 0x01, 0x00, 0xFF}, 6},
+		{[]byte("he"), 0}, // metin ikinci baytta elenir
+		{[]byte{0x85}, 0},
+	} {
+		if got := gobNeed(tc.in); got != tc.want {
+			t.Errorf("gobNeed(% x) = %d, beklenen %d", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestIsHTTP(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want bool
+	}{
+		{"GET / HTTP/1.1\r\nHost: x\r\n\r\n", true},
+		{"POST /echo HTTP/1.0\n", true},
+		{"OPTIONS * HTTP/1.1\r\n", true},
+		{"GET /\r\n", false},
+		{"FETCH / HTTP/1.1\r\n", false},
+		{"GET / HTTP/2\r\n", false},
+		{"GET  /a b  HTTP/1.1\r\n", false},
+		{"GET / HTTP/1.1", false}, // satır sonu hiç gelmedi
+		{"GET /" + strings.Repeat("a", maxRequestLine) + " HTTP/1.1\r\n", false},
+	} {
+		br := bufio.NewReaderSize(strings.NewReader(tc.in), maxRequestLine)
+		br.Peek(1)
+		if got := isHTTP(br); got != tc.want {
+			t.Errorf("isHTTP(%.40q) = %v, beklenen %v", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestParseSNIRoutes(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want map[string]string // nil ise hata beklenir
+	}{
+		{"", map[string]string{}},
+		{"http.lab=http", map[string]string{"http.lab": "http"}},
+		{"HTTP.lab=http, gob.lab=gob ,echo.lab=echo", map[string]string{"http.lab": "http", "gob.lab": "gob", "echo.lab": "echo"}},
+		{"http.lab", nil},
+		{"=http", nil},
+		{"a.lab=tls", nil},
+		{"a.lab=ftp", nil},
+		{"a.lab=http,", nil},
+	} {
+		got, err := parseSNIRoutes(tc.in)
+		if tc.want == nil {
+			if err == nil {
+				t.Errorf("parseSNIRoutes(%q) = %v, hata bekleniyordu", tc.in, got)
+			}
+			continue
+		}
+		if err != nil || !maps.Equal(got, tc.want) {
+			t.Errorf("parseSNIRoutes(%q) = %v, %v; beklenen %v", tc.in, got, err, tc.want)
+		}
+	}
+}
+
+// TestSniffSplit ilk baytları ayrı yazılarak gelen akışları koklar
+func TestSniffSplit(t *testing.T) {
+	stream := gobStream(t)
+	hello := []byte{0x16, 0x03, 0x01, 0x00, 0x05}
+	for _, tc := range []struct {
+		name    string
+		parts   [][]byte
+		withTLS bool
+		want    string
+	}{
+		{"tls", [][]byte{hello[:1], hello[1:]}, true, protoTLS},
+		{"tls iki bayt", [][]byte{hello[:2], hello[2:]}, true, protoTLS},
+		{"tls içinde 0x16", [][]byte{hello[:1], hello[1:]}, false, protoEcho},
+		{"gob", [][]byte{stream[:1], stream[1:]}, true, protoGob},
+		{"gob iki bayt", [][]byte{stream[:2], stream[2:]}, true, protoGob},
+		{"http", [][]byte{[]byte("GE"), []byte("T / HTTP/1.1\r\n")}, true, protoHTTP},
+		{"echo", [][]byte{[]byte("h"), []byte("ello\n")}, true, protoEcho},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer server.Close()
+			defer client.Close()
+			go func() {
+				for _, p := range tc.parts {
+					client.Write(p)
+					time.Sleep(50 * time.Millisecond)
+				}
+			}()
+
+			br := bufio.NewReaderSize(server, maxRequestLine)
+			got, err := sniff(server, br, tc.withTLS)
+			if err != nil || got != tc.want {
+				t.Errorf("sniff = %q, %v; beklenen %q", got, err, tc.want)
+			}
+		})
+	}
+}
+
+// startServer sunucuyu loopback'te başlatır ve adresini döndürür
+func startServer(t *testing.T, sni string) string {
+	t.Helper()
+	routes, err := parseSNIRoutes(sni)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	rt, err := newRouter(listener.Addr(), routes, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { rt.httpConns.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go routeTCPClient(conn, rt)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// echoLine bir satır gönderir ve satır echo yanıtını okur
+func echoLine(t *testing.T, conn net.Conn, line string) string {
+	t.Helper()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte(line + "\n")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 512)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
+
+// gobRoundTrip testPerson'ı gönderir ve geri geleni karşılaştırır
+func gobRoundTrip(t *testing.T, conn net.Conn) {
+	t.Helper()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := gob.NewEncoder(conn).Encode(testPerson); err != nil {
+		t.Fatal(err)
+	}
+	var got Person
+	if err := gob.NewDecoder(conn).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != testPerson.Name || len(got.Email) != 1 || got.Email[0] != testPerson.Email[0] {
+		t.Errorf("gob echo %+v", got)
+	}
+}
+
+// httpGet adrese GET gönderir ve gövdeyi döndürür
+func httpGet(t *testing.T, client *http.Client, url string) string {
+	t.Helper()
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+// TestProtocols her protokolü aynı port üzerinden dener
+func TestProtocols(t *testing.T) {
+	addr := startServer(t, "echo.lab=echo,gob.lab=gob")
+	insecure := &tls.Config{InsecureSkipVerify: true}
+
+	t.Run("echo", func(t *testing.T) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if got := echoLine(t, conn, "merhaba"); got != "TCP ECHO merhaba" {
+			t.Errorf("yanıt %q", got)
+		}
+	})
+
+	t.Run("http", func(t *testing.T) {
+		if got := httpGet(t, http.DefaultClient, "http://"+addr+"/echo?msg=merhaba"); got != "HTTP ECHO merhaba\n" {
+			t.Errorf("yanıt %q", got)
+		}
+	})
+
+	t.Run("gob", func(t *testing.T) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		gobRoundTrip(t, conn)
+	})
+
+	t.Run("tls", func(t *testing.T) {
+		// SNI için yol yok: çözülen akış koklanır ve HTTP'ye düşer
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: insecure}}
+		defer client.CloseIdleConnections()
+		if got := httpGet(t, client, "https://"+addr+"/echo?msg=gizli"); got != "HTTP ECHO gizli\n" {
+			t.Errorf("yanıt %q", got)
+		}
+	})
+
+	t.Run("tls sni", func(t *testing.T) {
+		config := insecure.Clone()
+		config.ServerName = "echo.lab"
+		conn, err := tls.Dial("tcp", addr, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if got := echoLine(t, conn, "merhaba"); got != "TCP ECHO merhaba" {
+			t.Errorf("yanıt %q", got)
+		}
+
+		config.ServerName = "gob.lab"
+		gobConn, err := tls.Dial("tcp", addr, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer gobConn.Close()
+		gobRoundTrip(t, gobConn)
+	})
+}
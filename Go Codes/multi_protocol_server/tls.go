@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// handshakeTimeout TLS el sıkışması için verilen süre
+const handshakeTimeout = 10 * time.Second
+
+// router koklanan bağlantıları işleyicilere dağıtır
+type router struct {
+	httpConns *connListener     // HTTP bağlantıları tek bir http.Server'a gider
+	tlsConfig *tls.Config       // TLS burada sonlanır
+	sniRoutes map[string]string // SNI -> protokol
+}
+
+// newRouter HTTP sunucusunu başlatır ve TLS ayarlarını hazırlar.
+// certFile boşsa SNI adları için kendinden imzalı sertifika üretilir.
+func newRouter(addr net.Addr, sniRoutes map[string]string, certFile, keyFile string) (*router, error) {
+	var cert tls.Certificate
+	var err error
+	if certFile != "" {
+		cert, err = tls.LoadX509KeyPair(certFile, keyFile)
+	} else {
+		cert, err = selfSignedCert(sniRoutes)
+		log.Println("TLS: using a self-signed certificate")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rt := &router{
+		httpConns: newConnListener(addr),
+		tlsConfig: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			NextProtos:   []string{"http/1.1"}, // HTTP/2 yok
+		},
+		sniRoutes: sniRoutes,
+	}
+	server := &http.Server{Handler: newHTTPHandler(), ReadHeaderTimeout: 10 * time.Second}
+	go server.Serve(rt.httpConns)
+	return rt, nil
+}
+
+// serve bağlantıyı protokolün işleyicisine verir
+func (rt *router) serve(proto string, conn net.Conn) {
+	switch proto {
+	case protoTLS:
+		rt.serveTLS(conn)
+	case protoHTTP:
+		rt.httpConns.conns <- conn
+	case protoGob:
+		handleGobClient(conn)
+	default:
+		handleTCPClient(conn)
+	}
+}
+
+// serveTLS TLS'i sonlandırır, işleyiciyi SNI'ye göre, SNI için yol
+// yoksa çözülen akışı koklayarak seçer
+func (rt *router) serveTLS(conn net.Conn) {
+	tlsConn := tls.Server(conn, rt.tlsConfig)
+	tlsConn.SetDeadline(time.Now().Add(handshakeTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		log.Printf("TLS handshake error from %s: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	tlsConn.SetDeadline(time.Time{})
+
+	name := tlsConn.ConnectionState().ServerName
+	if proto, ok := rt.sniRoutes[strings.ToLower(name)]; ok {
+		log.Printf("TLS %s: SNI %q -> %s", conn.RemoteAddr(), name, proto)
+		rt.serve(proto, tlsConn)
+		return
+	}
+
+	br := bufio.NewReaderSize(tlsConn, maxRequestLine)
+	proto, err := sniff(tlsConn, br, false)
+	if err != nil {
+		log.Println("TLS Read error:", err)
+		tlsConn.Close()
+		return
+	}
+	log.Printf("TLS %s: SNI %q, sniffed %s", conn.RemoteAddr(), name, proto)
+	rt.serve(proto, &peekedConn{Conn: tlsConn, r: br})
+}
+
+// parseSNIRoutes "-sni" değerini çözer: "http.lab=http,gob.lab=gob"
+func parseSNIRoutes(s string) (map[string]string, error) {
+	routes := make(map[string]string)
+	if s == "" {
+		return routes, nil
+	}
+	for _, route := range strings.Split(s, ",") {
+		name, proto, ok := strings.Cut(strings.TrimSpace(route), "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid SNI route %q, want name=protocol", route)
+		}
+		if proto != protoHTTP && proto != protoGob && proto != protoEcho {
+			return nil, fmt.Errorf("invalid protocol %q for %s, want http, gob or echo", proto, name)
+		}
+		routes[strings.ToLower(name)] = proto
+	}
+	return routes, nil
+}
+
+// selfSignedCert localhost ve SNI adları için bir yıllık sertifika üretir
+func selfSignedCert(sniRoutes map[string]string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	names := []string{"localhost"}
+	for name := range sniRoutes {
+		names = append(names, name)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "multi_protocol_server"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     names,
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
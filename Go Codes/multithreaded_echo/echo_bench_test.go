@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Benchmarks of the connection handling modes over loopback.
+//
+//	go test -run - -bench . -benchtime 20000x
+//
+// Each benchmark starts the server in-process and runs a number of
+// concurrent clients against it; an operation is one echo round trip.
+// Besides ns/op they report the 99th percentile latency of a round trip.
+// Thousands of clients need thousands of descriptors on both ends; raise
+// the limit with ulimit -n if the large sizes are skipped.
+//
+// Results on a one CPU Linux VM, clients and server sharing the CPU
+// (ns/op, p99 latency; B/conn for connected clients):
+//
+//	                     clients  goroutine              pool          epoll
+//	EchoMessages              10  12.9µs 0.18ms 3.3KB    -             21.0µs 0.33ms ~0
+//	                         100  14.0µs 2.1ms  4.1KB    -             12.0µs 2.2ms  0.7KB
+//	                        1000  22.6µs 33ms   3.2KB    -             20.5µs 46ms   1.1KB
+//	                        5000  28.7µs 156ms  3.0KB    -             25.4µs 184ms  0.9KB
+//	EchoConnections           10  70µs   1.7ms           72µs  1.8ms   86µs   2.1ms
+//	                         100  79µs   16ms            79µs  15ms    93µs   21ms
+//	                        1000  114µs  153ms           105µs 128ms   166µs  222ms
+//	                        4000  118µs  580ms           111µs 528ms   146µs  700ms
+//
+// With one CPU the modes are close on throughput; epoll mode's gain is
+// memory, about a third of goroutine mode per connected client. It also
+// pays the extra dup and epoll_ctl calls for every new connection, which
+// shows with short-lived clients.
+
+// The benchmarks measure serving, not logging. Servers of earlier runs
+// may still be closing connections, so quiet is set once, before any.
+func init() {
+	quiet = true
+}
+
+// benchPayload is what every client sends, one line of 64 bytes.
+var benchPayload = append(bytes.Repeat([]byte("x"), 63), '\n')
+
+// benchMode starts the server of one mode on a listener. serve must
+// return once the listener is closed.
+type benchMode struct {
+	name  string
+	serve func(listener *net.TCPListener)
+}
+
+var (
+	goroutineMode = benchMode{"goroutine", func(l *net.TCPListener) { serveGoroutines(l) }}
+	poolMode      = benchMode{"pool", func(l *net.TCPListener) { servePool(l, 64, 128, rejectBlock) }}
+	epollMode     = benchMode{"epoll", func(l *net.TCPListener) { serveEpoll(l, runtime.NumCPU()) }}
+)
+
+// BenchmarkEchoMessages keeps every client connected and has it send
+// messages back to back. It also reports the memory, heap and goroutine
+// stacks of both ends, that one more connected client costs.
+//
+// Pool mode is left out: a worker serves one client until it disconnects,
+// so with more clients than workers the others would never be served.
+func BenchmarkEchoMessages(b *testing.B) {
+	for _, mode := range []benchMode{goroutineMode, epollMode} {
+		for _, clients := range []int{10, 100, 1000, 5000} {
+			b.Run(fmt.Sprintf("%s/clients=%d", mode.name, clients), func(b *testing.B) {
+				addr := startBenchServer(b, mode)
+				accepted := stats.accepted.Load()
+				before := memInUse()
+
+				conns := make([]net.Conn, clients)
+				for i := range conns {
+					conn, err := net.Dial("tcp", addr)
+					if err != nil {
+						b.Skipf("dial: %v (raise ulimit -n for %d clients)", err, clients)
+					}
+					defer conn.Close()
+					conns[i] = conn
+				}
+				for stats.accepted.Load() < accepted+int64(clients) {
+					time.Sleep(time.Millisecond)
+				}
+				perConn := (memInUse() - before) / int64(clients)
+
+				runClients(b, clients, func(i int) error {
+					return roundTrip(conns[i])
+				})
+				b.ReportMetric(float64(perConn), "B/conn")
+			})
+		}
+	}
+}
+
+// BenchmarkEchoConnections has every client open a new connection for
+// each message, as short-lived clients such as health checks do. This is
+// the load the pool's bounded workers and queue are meant for.
+func BenchmarkEchoConnections(b *testing.B) {
+	for _, mode := range []benchMode{goroutineMode, poolMode, epollMode} {
+		for _, clients := range []int{10, 100, 1000, 4000} {
+			b.Run(fmt.Sprintf("%s/clients=%d", mode.name, clients), func(b *testing.B) {
+				addr := startBenchServer(b, mode)
+
+				runClients(b, clients, func(int) error {
+					conn, err := net.Dial("tcp", addr)
+					if err != nil {
+						return err
+					}
+					// Reset instead of closing gracefully, so the client
+					// ports do not pile up in TIME_WAIT
+					defer conn.(*net.TCPConn).SetLinger(0)
+					defer conn.Close()
+					return roundTrip(conn)
+				})
+			})
+		}
+	}
+}
+
+// startBenchServer runs mode on a loopback port until the test or
+// benchmark finishes and returns the address.
+func startBenchServer(tb testing.TB, mode benchMode) string {
+	if mode.name == "epoll" && runtime.GOOS != "linux" {
+		tb.Skip("epoll mode is only available on Linux")
+	}
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		mode.serve(listener)
+	}()
+	tb.Cleanup(func() {
+		listener.Close()
+		<-done
+	})
+	return listener.Addr().String()
+}
+
+// runClients runs b.N operations spread over the given number of
+// concurrent clients. op gets the client's index.
+func runClients(b *testing.B, clients int, op func(client int) error) {
+	var remaining atomic.Int64
+	remaining.Store(int64(b.N))
+	latencies := make([][]time.Duration, clients)
+
+	b.ResetTimer()
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Go(func() {
+			for remaining.Add(-1) >= 0 {
+				start := time.Now()
+				if err := op(i); err != nil {
+					b.Error(err)
+					return
+				}
+				latencies[i] = append(latencies[i], time.Since(start))
+			}
+		})
+	}
+	wg.Wait()
+	b.StopTimer()
+
+	all := slices.Concat(latencies...)
+	if len(all) > 0 {
+		slices.Sort(all)
+		p99 := all[len(all)*99/100]
+		b.ReportMetric(float64(p99.Microseconds())/1000, "p99-ms")
+	}
+}
+
+// memInUse returns the heap and stack memory in use after a collection.
+func memInUse() int64 {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return int64(m.HeapInuse + m.StackInuse)
+}
+
+// roundTrip sends benchPayload and waits for it to come back.
+func roundTrip(conn net.Conn) error {
+	if _, err := conn.Write(benchPayload); err != nil {
+		return err
+	}
+	reply := make([]byte, len(benchPayload))
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if !bytes.Equal(reply, benchPayload) {
+		return fmt.Errorf("reply %q, want %q", reply, benchPayload)
+	}
+	return nil
+}
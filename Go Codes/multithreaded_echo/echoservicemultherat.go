@@ -16,9 +16,54 @@
 //	│  Client N   │────▶│             │────▶│ Goroutine N │
 //	└─────────────┘     └─────────────┘     └─────────────┘
 //
+// Pool mode:
+//
+// Goroutine-per-connection has no upper bound. With -mode pool a fixed
+// number of workers serves the connections instead; accepted connections
+// wait in a bounded queue until a worker is free:
+//
+//	┌──────────┐     ┌───────────────┐     ┌──────────┐
+//	│ Listener │────▶│ Accept queue  │────▶│ Worker 1 │
+//	│ (Accept) │     │ (-queue deep) │     │   ...    │
+//	└──────────┘     └───────────────┘     │ Worker N │
+//	      │                                └──────────┘
+//	      ▼  queue full
+//	  -reject policy: close | busy | block
+//
+// A worker serves one connection until it closes, so at most -workers
+// clients are echoed at the same time.
+//
+// Epoll mode (Linux only):
+//
+// Both modes above park one goroutine, and its stack, per connected
+// client. With -mode epoll a few event loops serve all clients instead;
+// each loop waits on its own epoll instance and echoes whichever sockets
+// are ready, using one shared read buffer:
+//
+//	┌──────────┐  round-robin  ┌──────────────┐
+//	│ Listener │──────────────▶│ Event loop 1 │──▶ epoll_wait ──▶ read/write
+//	│ (Accept) │               │     ...      │
+//	└──────────┘               │ Event loop N │
+//	                           └──────────────┘
+//
+// An idle connection then costs a descriptor and a small struct. See
+// epoll_linux.go.
+//
+// To compare the modes, start the server in each mode with -quiet and
+// run the load generator against it:
+//
+//	go run ./loadgen -c 10,100,1000,5000 -n 200
+//
+// The benchmarks in echo_bench_test.go do the same with the server
+// in-process:
+//
+//	go test -run - -bench . -benchtime 20000x
+//
 // Usage:
 //
-//	go run echoservicemultherat.go
+//	go run .
+//	go run . -mode pool -workers 64 -queue 256 -reject busy
+//	go run . -mode epoll -loops 4 -quiet
 //
 // Then connect using:
 //
@@ -29,23 +74,65 @@
 //	nc localhost 1201
 //
 // The server echoes back any text sent to it.
+//
+// With -admin the server also keeps per-connection and global counters
+// available over HTTP and can force-close a client, see stats.go:
+//
+//	go run . -admin 127.0.0.1:8081
+//	curl localhost:8081/connections
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"runtime"
 )
 
+// Rejection policies for a full accept queue in pool mode.
+const (
+	rejectClose = "close" // Close the new connection immediately
+	rejectBusy  = "busy"  // Send busyMessage, then close
+	rejectBlock = "block" // Stop accepting until the queue has room
+)
+
+// busyMessage is sent to rejected clients with -reject busy.
+const busyMessage = "server busy, try again later\n"
+
+// quiet suppresses per-connection and per-message logging (-quiet), which
+// otherwise dominates the cost under load.
+var quiet bool
+
 // main initializes the TCP listener and starts accepting connections.
 // It binds to port 1201 on all available network interfaces.
 func main() {
 	// Define the service address (empty host = all interfaces)
-	service := ":1201"
+	service := flag.String("addr", ":1201", "address to listen on")
+	mode := flag.String("mode", "goroutine", "connection handling: goroutine (one per connection), pool or epoll")
+	workers := flag.Int("workers", 64, "pool mode: number of worker goroutines")
+	queueDepth := flag.Int("queue", 128, "pool mode: accepted connections that may wait for a worker")
+	reject := flag.String("reject", rejectBusy, "pool mode: policy when the queue is full: close, busy or block")
+	loops := flag.Int("loops", runtime.NumCPU(), "epoll mode: number of event loops")
+	admin := flag.String("admin", "", "address for the admin HTTP endpoint, e.g. 127.0.0.1:8081 (disabled if empty)")
+	flag.BoolVar(&quiet, "quiet", false, "do not log connections and messages")
+	flag.Parse()
+
+	if *mode != "goroutine" && *mode != "pool" && *mode != "epoll" {
+		log.Fatalf("Fatal error: unknown mode %q", *mode)
+	}
+	if *reject != rejectClose && *reject != rejectBusy && *reject != rejectBlock {
+		log.Fatalf("Fatal error: unknown reject policy %q", *reject)
+	}
+	if *workers < 1 || *queueDepth < 0 || *loops < 1 {
+		log.Fatalf("Fatal error: -workers and -loops must be at least 1 and -queue at least 0")
+	}
 
 	// Resolve the TCP address
 	// This converts the string address to a TCPAddr structure
-	tcpAddr, err := net.ResolveTCPAddr("tcp", service)
+	tcpAddr, err := net.ResolveTCPAddr("tcp", *service)
 	checkError(err)
 
 	// Create a TCP listener bound to the resolved address
@@ -53,20 +140,41 @@ func main() {
 	listener, err := net.ListenTCP("tcp", tcpAddr)
 	checkError(err)
 
-	log.Printf("Multithreaded Echo Server started on %s", service)
+	log.Printf("Multithreaded Echo Server started on %s (%s mode)", *service, *mode)
+	if *admin != "" {
+		go serveAdmin(*admin, *mode)
+	}
+	if *mode == "pool" {
+		log.Printf("Pool: %d workers, queue depth %d, reject policy %s", *workers, *queueDepth, *reject)
+		servePool(listener, *workers, *queueDepth, *reject)
+		return
+	}
+	if *mode == "epoll" {
+		log.Printf("Epoll: %d event loops", *loops)
+		checkError(serveEpoll(listener, *loops))
+		return
+	}
 	log.Println("Waiting for connections...")
+	serveGoroutines(listener)
+}
 
-	// Main accept loop - runs indefinitely
+// serveGoroutines is the accept loop of goroutine mode: every connection
+// gets its own goroutine. It returns once the listener is closed.
+func serveGoroutines(listener net.Listener) {
 	for {
 		// Accept blocks until a new connection arrives
 		// When a client connects, Accept returns a new Conn
 		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
 		if err != nil {
 			log.Printf("Accept error: %v", err)
+			stats.errors.Add(1)
 			continue
 		}
 
-		log.Printf("New connection from: %s", conn.RemoteAddr())
+		logf("New connection from: %s", conn.RemoteAddr())
 
 		// Spawn a new goroutine to handle this connection
 		// This allows the main loop to immediately accept new connections
@@ -83,9 +191,12 @@ func main() {
 //
 // The function runs in its own goroutine and handles all I/O for one client.
 // When the client disconnects or an error occurs, the function returns and
-// the goroutine terminates.
+// the goroutine terminates. The connection is registered in stats for as
+// long as it is served; the admin endpoint closes it by closing conn.
 func handleClient(conn net.Conn) {
 	defer conn.Close()
+	st := stats.track(conn.RemoteAddr().String(), func() { conn.Close() })
+	defer stats.untrack(st)
 
 	// Buffer for reading data (512 bytes is typical for simple protocols)
 	var buf [512]byte
@@ -95,20 +206,96 @@ func handleClient(conn net.Conn) {
 		// n contains the number of bytes read
 		n, err := conn.Read(buf[0:])
 		if err != nil {
-			log.Printf("Client %s disconnected", conn.RemoteAddr())
+			// EOF is a normal disconnect, a forced close is counted separately
+			if !errors.Is(err, io.EOF) && !st.closed.Load() {
+				stats.errors.Add(1)
+			}
 			return
 		}
+		st.messages.Add(1)
+		st.bytesIn.Add(int64(n))
 
 		// Log received data
-		received := string(buf[0:n])
-		fmt.Printf("Received from %s: %s", conn.RemoteAddr(), received)
+		if !quiet {
+			received := string(buf[0:n])
+			fmt.Printf("Received from %s: %s", conn.RemoteAddr(), received)
+		}
 
 		// Echo the data back to the client
 		_, err = conn.Write(buf[0:n])
 		if err != nil {
-			log.Printf("Write error to %s: %v", conn.RemoteAddr(), err)
+			if !st.closed.Load() {
+				log.Printf("Write error to %s: %v", conn.RemoteAddr(), err)
+				stats.errors.Add(1)
+			}
 			return
 		}
+		st.bytesOut.Add(int64(n))
+	}
+}
+
+// servePool accepts connections and hands them to a fixed set of workers
+// through a bounded queue.
+//
+// Parameters:
+//   - listener: The listening socket
+//   - workers: Number of worker goroutines, the maximum of clients served at once
+//   - depth: Capacity of the accept queue in front of the workers
+//   - reject: What to do with a new connection when the queue is full
+//
+// Each worker takes one connection from the queue and runs handleClient
+// for it, so a slow client occupies its worker until it disconnects.
+// With -reject block the accept loop waits for room instead; further
+// clients then queue up in the kernel's listen backlog.
+//
+// servePool returns once the listener is closed; the workers exit after
+// serving the connections still queued.
+func servePool(listener net.Listener, workers, depth int, reject string) {
+	queue := make(chan net.Conn, depth)
+	defer close(queue)
+	for i := 0; i < workers; i++ {
+		go func() {
+			for conn := range queue {
+				handleClient(conn)
+			}
+		}()
+	}
+
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			log.Printf("Accept error: %v", err)
+			stats.errors.Add(1)
+			continue
+		}
+
+		if reject == rejectBlock {
+			queue <- conn
+			continue
+		}
+
+		select {
+		case queue <- conn:
+			logf("New connection from: %s (queued)", conn.RemoteAddr())
+		default:
+			// Queue full: refuse without blocking the accept loop
+			logf("Rejected %s: queue full", conn.RemoteAddr())
+			stats.rejected.Add(1)
+			if reject == rejectBusy {
+				conn.Write([]byte(busyMessage))
+			}
+			conn.Close()
+		}
+	}
+}
+
+// logf logs unless -quiet is set.
+func logf(format string, args ...any) {
+	if !quiet {
+		log.Printf(format, args...)
 	}
 }
 
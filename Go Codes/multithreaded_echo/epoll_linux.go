@@ -0,0 +1,376 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net"
+	"slices"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// readBufferSize is the size of an event loop's read buffer and of the
+// pooled buffers that hold unsent replies.
+const readBufferSize = 16 * 1024
+
+// maxEvents is how many ready connections one epoll_wait call returns.
+const maxEvents = 256
+
+// bufferPool holds reply buffers for connections whose socket send
+// buffer is full. Such connections are rare, so the buffers are only
+// borrowed until the reply has been written.
+var bufferPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, readBufferSize)
+		return &b
+	},
+}
+
+// epollConn is the state of one connection owned by an event loop.
+// An idle connection is just this struct; it has no goroutine and no
+// buffer of its own.
+type epollConn struct {
+	fd      int
+	gen     int32 // Tells this connection from earlier ones that had fd
+	remote  string
+	pending []byte  // Unsent part of the last reply, nil if none
+	buf     *[]byte // Pooled buffer backing pending
+	stats   *connStats
+
+	mu     sync.Mutex // Guards fd against shutdown after close
+	closed bool
+}
+
+// eventLoop waits on its own epoll instance and serves every connection
+// registered there.
+//
+// Connections are found by descriptor, and a closed descriptor number is
+// soon reused for a new connection. Every event therefore also carries
+// the generation of the connection it was registered for, and an event
+// whose generation does not match the connection now holding its
+// descriptor is dropped.
+type eventLoop struct {
+	epfd  int
+	wake  int        // eventfd that stop writes to, registered with epfd
+	mu    sync.Mutex // Guards conns and gen, which the accept loop changes
+	conns map[int]*epollConn
+	gen   int32 // Generation of the last connection added
+}
+
+// serveEpoll accepts connections and spreads them round-robin over a
+// fixed number of event loops.
+//
+// Parameters:
+//   - listener: The listening socket
+//   - loops: Number of event loops, each running in its own goroutine
+//
+// Accepting still goes through the Go listener. Each accepted socket is
+// then duplicated out of the net.Conn and handed to an event loop, which
+// reads and echoes it with non-blocking system calls. Memory per
+// connection stays constant no matter how many clients are connected,
+// instead of growing with one goroutine stack per client.
+//
+// serveEpoll returns nil once the listener is closed. Before it returns
+// the event loops close their connections and epoll instances and exit,
+// so nothing is left blocked in epoll_wait.
+func serveEpoll(listener *net.TCPListener, loops int) error {
+	eventLoops := make([]*eventLoop, 0, loops)
+	var wg sync.WaitGroup
+	defer func() {
+		for _, l := range eventLoops {
+			l.stop()
+		}
+		wg.Wait()
+	}()
+	for range loops {
+		l, err := newEventLoop()
+		if err != nil {
+			return err
+		}
+		eventLoops = append(eventLoops, l)
+		wg.Go(l.run)
+	}
+
+	for next := 0; ; next = (next + 1) % loops {
+		conn, err := listener.AcceptTCP()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			log.Printf("Accept error: %v", err)
+			stats.errors.Add(1)
+			continue
+		}
+
+		logf("New connection from: %s", conn.RemoteAddr())
+		if err := eventLoops[next].add(conn); err != nil {
+			log.Printf("Cannot register %s: %v", conn.RemoteAddr(), err)
+			stats.errors.Add(1)
+		}
+	}
+}
+
+// newEventLoop creates an epoll instance and the eventfd that wakes its
+// loop for stop.
+func newEventLoop() (*eventLoop, error) {
+	epfd, err := unix.EpollCreate1(unix.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("epoll_create1: %w", err)
+	}
+	wake, err := unix.Eventfd(0, unix.EFD_CLOEXEC|unix.EFD_NONBLOCK)
+	if err != nil {
+		unix.Close(epfd)
+		return nil, fmt.Errorf("eventfd: %w", err)
+	}
+	ev := unix.EpollEvent{Events: unix.EPOLLIN, Fd: int32(wake)}
+	if err := unix.EpollCtl(epfd, unix.EPOLL_CTL_ADD, wake, &ev); err != nil {
+		unix.Close(wake)
+		unix.Close(epfd)
+		return nil, fmt.Errorf("epoll_ctl: %w", err)
+	}
+	return &eventLoop{epfd: epfd, wake: wake, conns: make(map[int]*epollConn)}, nil
+}
+
+// stop wakes the loop, which then closes everything and returns. It must
+// not be called before the accept loop stopped adding connections.
+func (l *eventLoop) stop() {
+	var one [8]byte
+	one[0] = 1 // Any non-zero counter value wakes epoll_wait
+	unix.Write(l.wake, one[:])
+}
+
+// add takes over the socket of conn and registers it with the loop.
+// conn itself is closed; the loop owns a duplicate of its descriptor.
+func (l *eventLoop) add(conn *net.TCPConn) error {
+	defer conn.Close()
+
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	fd := -1
+	var dupErr error
+	err = raw.Control(func(s uintptr) {
+		fd, dupErr = unix.Dup(int(s))
+	})
+	if err != nil {
+		return err
+	}
+	if dupErr != nil {
+		return dupErr
+	}
+	unix.CloseOnExec(fd)
+	if err := unix.SetNonblock(fd, true); err != nil {
+		unix.Close(fd)
+		return err
+	}
+
+	c := &epollConn{fd: fd, remote: conn.RemoteAddr().String()}
+	c.stats = stats.track(c.remote, c.shutdown)
+	l.mu.Lock()
+	l.gen++
+	c.gen = l.gen
+	l.conns[fd] = c
+	l.mu.Unlock()
+
+	ev := c.event(unix.EPOLLIN | unix.EPOLLRDHUP)
+	if err := unix.EpollCtl(l.epfd, unix.EPOLL_CTL_ADD, fd, &ev); err != nil {
+		l.mu.Lock()
+		delete(l.conns, fd)
+		l.mu.Unlock()
+		stats.untrack(c.stats)
+		unix.Close(fd)
+		return err
+	}
+	return nil
+}
+
+// run is the event loop. It waits for ready connections and handles
+// each of them without blocking.
+func (l *eventLoop) run() {
+	events := make([]unix.EpollEvent, maxEvents)
+	buf := make([]byte, readBufferSize) // Shared by all connections of this loop
+
+	for {
+		n, err := unix.EpollWait(l.epfd, events, -1)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			log.Fatalf("Fatal error: epoll_wait: %s", err)
+		}
+
+		for _, ev := range events[:n] {
+			if int(ev.Fd) == l.wake {
+				l.closeAll()
+				return
+			}
+
+			l.mu.Lock()
+			c := l.conns[int(ev.Fd)]
+			l.mu.Unlock()
+			if c == nil || c.gen != ev.Pad {
+				// Closed, and maybe its descriptor already reused
+				continue
+			}
+
+			switch {
+			case ev.Events&unix.EPOLLERR != 0:
+				l.close(c)
+			case ev.Events&unix.EPOLLOUT != 0:
+				l.flush(c)
+			case ev.Events&(unix.EPOLLIN|unix.EPOLLRDHUP|unix.EPOLLHUP) != 0:
+				l.echo(c, buf)
+			}
+		}
+	}
+}
+
+// echo reads what the client sent and writes it back. Replies the
+// socket cannot take right away are kept in a pooled buffer, and the
+// connection is not read again until they have been sent.
+func (l *eventLoop) echo(c *epollConn, buf []byte) {
+	n, err := unix.Read(c.fd, buf)
+	if err == unix.EAGAIN {
+		return
+	}
+	if err != nil || n == 0 {
+		// n == 0 means the client closed its side
+		if err != nil && !c.stats.closed.Load() {
+			stats.errors.Add(1)
+		}
+		l.close(c)
+		return
+	}
+	c.stats.messages.Add(1)
+	c.stats.bytesIn.Add(int64(n))
+
+	if !quiet {
+		fmt.Printf("Received from %s: %s", c.remote, buf[:n])
+	}
+
+	written, err := write(c.fd, buf[:n])
+	c.stats.bytesOut.Add(int64(written))
+	if err != nil {
+		l.writeError(c, err)
+		return
+	}
+	if written == n {
+		return
+	}
+
+	// Socket buffer full: keep the rest and wait until it is writable
+	c.buf = bufferPool.Get().(*[]byte)
+	c.pending = (*c.buf)[:copy(*c.buf, buf[written:n])]
+	l.watch(c, unix.EPOLLOUT)
+}
+
+// flush sends the pending reply of c once its socket is writable again.
+func (l *eventLoop) flush(c *epollConn) {
+	written, err := write(c.fd, c.pending)
+	c.stats.bytesOut.Add(int64(written))
+	if err != nil {
+		l.writeError(c, err)
+		return
+	}
+	c.pending = c.pending[written:]
+	if len(c.pending) > 0 {
+		return
+	}
+
+	l.release(c)
+	l.watch(c, unix.EPOLLIN|unix.EPOLLRDHUP)
+}
+
+// write writes as much of b as the socket accepts without blocking.
+func write(fd int, b []byte) (int, error) {
+	written := 0
+	for written < len(b) {
+		n, err := unix.Write(fd, b[written:])
+		if err == unix.EAGAIN {
+			break
+		}
+		if err != nil {
+			return written, err
+		}
+		written += n
+	}
+	return written, nil
+}
+
+// writeError closes c after a failed write. Writes fail on purpose after
+// the admin endpoint shut the socket down; only other failures are errors.
+func (l *eventLoop) writeError(c *epollConn, err error) {
+	if !c.stats.closed.Load() {
+		log.Printf("Write error to %s: %v", c.remote, err)
+		stats.errors.Add(1)
+	}
+	l.close(c)
+}
+
+// watch changes which events the loop waits for on c.
+func (l *eventLoop) watch(c *epollConn, events uint32) {
+	ev := c.event(events)
+	if err := unix.EpollCtl(l.epfd, unix.EPOLL_CTL_MOD, c.fd, &ev); err != nil {
+		log.Printf("epoll_ctl for %s: %v", c.remote, err)
+		l.close(c)
+	}
+}
+
+// event is the epoll registration of c for events. The kernel hands the
+// descriptor and generation back unchanged with every event.
+func (c *epollConn) event(events uint32) unix.EpollEvent {
+	return unix.EpollEvent{Events: events, Fd: int32(c.fd), Pad: c.gen}
+}
+
+// release returns the pending buffer of c to the pool.
+func (l *eventLoop) release(c *epollConn) {
+	if c.buf != nil {
+		bufferPool.Put(c.buf)
+		c.buf, c.pending = nil, nil
+	}
+}
+
+// close unregisters c and closes its socket.
+func (l *eventLoop) close(c *epollConn) {
+	l.release(c)
+	l.mu.Lock()
+	delete(l.conns, c.fd)
+	l.mu.Unlock()
+	stats.untrack(c.stats)
+
+	// Closing the descriptor also removes it from the epoll set
+	c.mu.Lock()
+	c.closed = true
+	unix.Close(c.fd)
+	c.mu.Unlock()
+}
+
+// closeAll closes every connection of the loop and then the loop's own
+// descriptors.
+func (l *eventLoop) closeAll() {
+	l.mu.Lock()
+	conns := slices.Collect(maps.Values(l.conns))
+	l.mu.Unlock()
+	for _, c := range conns {
+		l.close(c)
+	}
+	unix.Close(l.wake)
+	unix.Close(l.epfd)
+}
+
+// shutdown is how the admin endpoint closes c. Only the event loop may
+// close the descriptor, so shutdown just hangs up the socket; the loop
+// then sees the hangup and closes c itself. It is safe to call from any
+// goroutine and does nothing once c is closed, when the descriptor
+// number may already belong to another connection.
+func (c *epollConn) shutdown() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		unix.Shutdown(c.fd, unix.SHUT_RDWR)
+	}
+}
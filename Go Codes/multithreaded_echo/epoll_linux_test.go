@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// openFiles counts the descriptors of the test process.
+func openFiles(t *testing.T) int {
+	t.Helper()
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip(err)
+	}
+	return len(entries)
+}
+
+// TestEpollStop checks that serveEpoll, once its listener is closed,
+// closes the connections of its event loops and their epoll and eventfd
+// descriptors before it returns.
+func TestEpollStop(t *testing.T) {
+	before := openFiles(t)
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- serveEpoll(listener, 4) }()
+
+	var conns []net.Conn
+	for range 8 {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if err := roundTrip(conn); err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, conn)
+	}
+
+	listener.Close()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("serveEpoll = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("serveEpoll did not return")
+	}
+
+	for _, conn := range conns {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+			t.Errorf("client read %v, want EOF", err)
+		}
+		conn.Close()
+	}
+	if after := openFiles(t); after != before {
+		t.Errorf("%d descriptors open after serveEpoll returned, %d before", after, before)
+	}
+}
+
+// bufferLimit returns the largest size the kernel grows a TCP socket
+// buffer to, the last value of net.ipv4.tcp_rmem or tcp_wmem.
+func bufferLimit(t *testing.T, name string) int {
+	t.Helper()
+	data, err := os.ReadFile("/proc/sys/net/ipv4/" + name)
+	if err != nil {
+		t.Skip(err)
+	}
+	fields := strings.Fields(string(data))
+	n, err := strconv.Atoi(fields[len(fields)-1])
+	if err != nil {
+		t.Fatalf("%s: %q", name, data)
+	}
+	return n
+}
+
+// TestEpollPartialWrite sends more than the socket buffers on the way
+// can hold while the client does not read. The event loop must keep the
+// unsent reply, wait for EPOLLOUT and stop reading until it is sent; the
+// echo arrives complete and in order.
+func TestEpollPartialWrite(t *testing.T) {
+	addr := startBenchServer(t, epollMode)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.(*net.TCPConn).SetReadBuffer(256 * 1024)
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	// Both send buffers and the server's receive buffer; the margin
+	// covers the client's receive buffer
+	data := make([]byte, bufferLimit(t, "tcp_rmem")+2*bufferLimit(t, "tcp_wmem")+1<<20)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	written := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(data)
+		written <- err
+	}()
+
+	// Not reading yet fills the buffers on both ends
+	time.Sleep(200 * time.Millisecond)
+	select {
+	case err := <-written:
+		t.Fatalf("write of %d bytes finished without a reader: %v", len(data), err)
+	default:
+	}
+
+	got := make([]byte, len(data))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("echo differs from what was sent")
+	}
+}
+
+// TestEpollFdReuse churns through short-lived connections on a single
+// event loop, so closed descriptor numbers are reused at once and events
+// of old connections can share a batch with the new ones. Every new
+// connection must get its own echo, and a long-lived one is unaffected.
+func TestEpollFdReuse(t *testing.T) {
+	addr := startBenchServer(t, benchMode{"epoll", func(l *net.TCPListener) { serveEpoll(l, 1) }})
+	steady, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer steady.Close()
+	steady.SetDeadline(time.Now().Add(30 * time.Second))
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Go(func() {
+			for range 100 {
+				// One connection hangs up with its message unanswered,
+				// the next is likely to get the same descriptor
+				gone, err := net.Dial("tcp", addr)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				gone.Write(benchPayload)
+				gone.(*net.TCPConn).SetLinger(0)
+				gone.Close()
+
+				conn, err := net.Dial("tcp", addr)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				conn.SetDeadline(time.Now().Add(10 * time.Second))
+				err = roundTrip(conn)
+				conn.Close()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		})
+	}
+	for range 100 {
+		if err := roundTrip(steady); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+
+	// Only the steady connection is left once the loop saw every hangup
+	deadline := time.Now().Add(5 * time.Second)
+	for len(stats.connections()) != 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := len(stats.connections()); n != 1 {
+		t.Errorf("%d connections tracked, want 1", n)
+	}
+}
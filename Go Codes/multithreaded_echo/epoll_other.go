@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// serveEpoll is only available on Linux; use -mode goroutine or pool elsewhere.
+func serveEpoll(listener *net.TCPListener, loops int) error {
+	return errors.New("epoll mode is only available on Linux")
+}
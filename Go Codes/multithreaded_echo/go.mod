@@ -0,0 +1,5 @@
+module multithreaded_echo
+
+go 1.25.3
+
+require golang.org/x/sys v0.43.0
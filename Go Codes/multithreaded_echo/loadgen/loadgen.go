@@ -0,0 +1,354 @@
+// Package main implements a load generator for the echo servers in this
+// repository (multithreaded_echo and multi_protocol_server).
+//
+// The generator opens a number of concurrent TCP connections (or UDP
+// sockets), sends fixed-size payloads over each of them and waits for the
+// reply before sending the next one. It measures how long every request
+// took and reports throughput and latency percentiles per run.
+//
+//	┌──────────────┐   payload    ┌─────────────┐
+//	│ Connection 1 │─────────────▶│             │
+//	│     ...      │              │ Echo server │
+//	│ Connection N │◀─────────────│             │
+//	└──────────────┘    reply     └─────────────┘
+//	        │
+//	        ▼
+//	 latency per request ──▶ p50 / p95 / p99, msg/s
+//
+// -c takes a comma separated list, so one invocation can sweep several
+// concurrency levels; every level is a separate run and a separate row in
+// the report.
+//
+// Usage:
+//
+//	go run loadgen.go -addr 127.0.0.1:1201 -c 1,10,100,1000 -n 200
+//	go run loadgen.go -addr 127.0.0.1:1201 -c 500 -duration 30s -rate 50 -size 256
+//	go run loadgen.go -addr 127.0.0.1:1200 -reply any -c 100          # multi_protocol_server TCP
+//	go run loadgen.go -addr 127.0.0.1:1200 -proto udp -reply any -c 10 # multi_protocol_server UDP
+//	go run loadgen.go -c 10,100 -json > results.json
+//
+// Reply modes:
+//   - echo: the reply must be the payload itself (multithreaded_echo).
+//   - any: the next read is taken as the reply, whatever it contains
+//     (multi_protocol_server, which prefixes its replies). Keep -size below
+//     the server's 512 byte read buffer in this mode, otherwise one
+//     request produces several replies.
+//
+// Each TCP connection needs a file descriptor on both ends; raise the
+// limit with ulimit -n before running thousands of connections.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// config holds the command-line options of a run.
+type config struct {
+	addr     string
+	proto    string
+	size     int
+	rate     float64
+	messages int
+	duration time.Duration
+	timeout  time.Duration
+	reply    string
+}
+
+// result is the outcome of one run at one concurrency level.
+// It is also the JSON output format.
+type result struct {
+	Proto        string  `json:"proto"`
+	Addr         string  `json:"addr"`
+	Connections  int     `json:"connections"`
+	ConnectFails int     `json:"connect_failures"`
+	PayloadSize  int     `json:"payload_size"`
+	Messages     int     `json:"messages"`
+	Errors       int     `json:"errors"`
+	Timeouts     int     `json:"timeouts"`
+	Mismatches   int     `json:"mismatches"`
+	ElapsedSec   float64 `json:"elapsed_sec"`
+	MsgPerSec    float64 `json:"msg_per_sec"`
+	MiBPerSec    float64 `json:"mib_per_sec"`
+	MeanMs       float64 `json:"mean_ms"`
+	P50Ms        float64 `json:"p50_ms"`
+	P95Ms        float64 `json:"p95_ms"`
+	P99Ms        float64 `json:"p99_ms"`
+	MaxMs        float64 `json:"max_ms"`
+}
+
+// connStats is what a single connection measured. Connections collect
+// their own stats and merge them once at the end, so the hot path does
+// not contend on a lock.
+type connStats struct {
+	latencies  []time.Duration
+	bytesIn    int64
+	errors     int
+	timeouts   int
+	mismatches int
+	dialFailed bool
+}
+
+// errMismatch reports an echo reply that differs from the payload.
+var errMismatch = errors.New("reply does not match payload")
+
+// main parses the flags and runs one load test per concurrency level.
+func main() {
+	var cfg config
+	levels := flag.String("c", "10", "concurrent connections, a comma separated list runs one test per level")
+	flag.StringVar(&cfg.addr, "addr", "127.0.0.1:1201", "server address")
+	flag.StringVar(&cfg.proto, "proto", "tcp", "transport: tcp or udp")
+	flag.IntVar(&cfg.size, "size", 64, "payload size in bytes, including the trailing newline")
+	flag.Float64Var(&cfg.rate, "rate", 0, "messages per second per connection, 0 sends the next message as soon as the reply arrives")
+	flag.IntVar(&cfg.messages, "n", 100, "messages per connection, 0 for no limit (requires -duration)")
+	flag.DurationVar(&cfg.duration, "duration", 0, "stop each run after this long, 0 for no limit")
+	flag.DurationVar(&cfg.timeout, "timeout", 5*time.Second, "dial and reply timeout")
+	flag.StringVar(&cfg.reply, "reply", "echo", "expected reply: echo (payload sent back verbatim) or any (the next read)")
+	asJSON := flag.Bool("json", false, "print the results as JSON instead of a table")
+	flag.Parse()
+
+	counts, err := parseLevels(*levels)
+	checkError(err)
+	switch {
+	case cfg.proto != "tcp" && cfg.proto != "udp":
+		log.Fatalf("Fatal error: unknown protocol %q", cfg.proto)
+	case cfg.reply != "echo" && cfg.reply != "any":
+		log.Fatalf("Fatal error: unknown reply mode %q", cfg.reply)
+	case cfg.size < 1:
+		log.Fatalf("Fatal error: -size must be at least 1")
+	case cfg.messages <= 0 && cfg.duration <= 0:
+		log.Fatalf("Fatal error: set -n or -duration, otherwise the run never ends")
+	}
+
+	payload := makePayload(cfg.size)
+	var results []result
+	for _, n := range counts {
+		if !*asJSON {
+			log.Printf("Running %d %s connections against %s...", n, cfg.proto, cfg.addr)
+		}
+		results = append(results, run(cfg, n, payload))
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		checkError(enc.Encode(results))
+		return
+	}
+	printTable(results)
+}
+
+// parseLevels parses the -c list, for example "1,10,100".
+func parseLevels(s string) ([]int, error) {
+	var counts []int
+	for _, field := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid connection count %q", field)
+		}
+		counts = append(counts, n)
+	}
+	return counts, nil
+}
+
+// makePayload builds a printable payload of the given size ending in a
+// newline, so line-based servers see one complete line per message.
+func makePayload(size int) []byte {
+	payload := make([]byte, size)
+	for i := range payload {
+		payload[i] = 'a' + byte(i%26)
+	}
+	payload[size-1] = '\n'
+	return payload
+}
+
+// run starts n connections at once, waits for all of them to finish and
+// merges their stats into one result.
+func run(cfg config, n int, payload []byte) result {
+	stats := make([]connStats, n)
+	var deadline time.Time
+	if cfg.duration > 0 {
+		deadline = time.Now().Add(cfg.duration)
+	}
+
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := range stats {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stats[i] = runConn(cfg, payload, deadline)
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	res := result{
+		Proto:       cfg.proto,
+		Addr:        cfg.addr,
+		Connections: n,
+		PayloadSize: len(payload),
+		ElapsedSec:  elapsed.Seconds(),
+	}
+	var latencies []time.Duration
+	var bytesIn int64
+	for _, s := range stats {
+		latencies = append(latencies, s.latencies...)
+		bytesIn += s.bytesIn
+		res.Errors += s.errors
+		res.Timeouts += s.timeouts
+		res.Mismatches += s.mismatches
+		if s.dialFailed {
+			res.ConnectFails++
+		}
+	}
+
+	res.Messages = len(latencies)
+	res.MsgPerSec = float64(res.Messages) / elapsed.Seconds()
+	res.MiBPerSec = float64(bytesIn) / (1 << 20) / elapsed.Seconds()
+	if len(latencies) > 0 {
+		slices.Sort(latencies)
+		var sum time.Duration
+		for _, l := range latencies {
+			sum += l
+		}
+		res.MeanMs = ms(sum / time.Duration(len(latencies)))
+		res.P50Ms = ms(percentile(latencies, 50))
+		res.P95Ms = ms(percentile(latencies, 95))
+		res.P99Ms = ms(percentile(latencies, 99))
+		res.MaxMs = ms(latencies[len(latencies)-1])
+	}
+	return res
+}
+
+// runConn drives one connection until it has sent -n messages, the
+// deadline passes or the connection fails.
+//
+// With -rate the messages follow a fixed schedule and latency is measured
+// from the time a message was due, not from when it was actually sent.
+// Otherwise a server stall would delay the following sends and hide the
+// wait from the measurement.
+func runConn(cfg config, payload []byte, deadline time.Time) connStats {
+	var s connStats
+	conn, err := net.DialTimeout(cfg.proto, cfg.addr, cfg.timeout)
+	if err != nil {
+		s.dialFailed = true
+		return s
+	}
+	defer conn.Close()
+
+	var interval time.Duration
+	if cfg.rate > 0 {
+		interval = time.Duration(float64(time.Second) / cfg.rate)
+	}
+	buf := make([]byte, max(len(payload), 64*1024))
+	start := time.Now()
+
+	for i := 0; cfg.messages <= 0 || i < cfg.messages; i++ {
+		sent := time.Now()
+		if interval > 0 {
+			sent = start.Add(time.Duration(i) * interval)
+			time.Sleep(time.Until(sent))
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			break
+		}
+
+		conn.SetDeadline(time.Now().Add(cfg.timeout))
+		n, err := exchange(conn, cfg, payload, buf)
+		s.bytesIn += int64(n)
+		if err == nil {
+			s.latencies = append(s.latencies, time.Since(sent))
+			continue
+		}
+
+		s.errors++
+		var netErr net.Error
+		switch {
+		case errors.As(err, &netErr) && netErr.Timeout():
+			s.timeouts++
+			if cfg.proto == "udp" {
+				continue // Lost datagram, the socket is still usable
+			}
+		case errors.Is(err, errMismatch):
+			s.mismatches++
+		}
+		// A TCP stream cannot be resynchronized after an error
+		return s
+	}
+	return s
+}
+
+// exchange sends one payload and reads its reply. It returns the number
+// of reply bytes read.
+func exchange(conn net.Conn, cfg config, payload, buf []byte) (int, error) {
+	if _, err := conn.Write(payload); err != nil {
+		return 0, err
+	}
+
+	// UDP replies arrive as one datagram, as do short TCP replies in "any" mode
+	if cfg.proto == "udp" || cfg.reply == "any" {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return n, err
+		}
+		if cfg.reply == "echo" && !bytes.Equal(buf[:n], payload) {
+			return n, errMismatch
+		}
+		return n, nil
+	}
+
+	// A TCP echo may come back in several segments
+	n, err := io.ReadFull(conn, buf[:len(payload)])
+	if err != nil {
+		return n, err
+	}
+	if !bytes.Equal(buf[:n], payload) {
+		return n, errMismatch
+	}
+	return n, nil
+}
+
+// percentile returns the p-th percentile of sorted latencies using the
+// nearest-rank method.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(p/100*float64(len(sorted))+0.5) - 1
+	return sorted[min(max(rank, 0), len(sorted)-1)]
+}
+
+// ms converts a duration to fractional milliseconds.
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// printTable prints one row per run.
+func printTable(results []result) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "conns\tfailed\tmessages\terrors\telapsed\tmsg/s\tMiB/s\tmean ms\tp50 ms\tp95 ms\tp99 ms\tmax ms\t")
+	for _, r := range results {
+		fmt.Fprintf(w, "%d\t%d\t%d\t%d\t%.2fs\t%.0f\t%.2f\t%.3f\t%.3f\t%.3f\t%.3f\t%.3f\t\n",
+			r.Connections, r.ConnectFails, r.Messages, r.Errors, r.ElapsedSec,
+			r.MsgPerSec, r.MiBPerSec, r.MeanMs, r.P50Ms, r.P95Ms, r.P99Ms, r.MaxMs)
+	}
+	w.Flush()
+}
+
+// checkError handles fatal errors by logging and terminating the program.
+func checkError(err error) {
+	if err != nil {
+		log.Fatalf("Fatal error: %s", err.Error())
+	}
+}
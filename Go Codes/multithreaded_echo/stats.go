@@ -0,0 +1,216 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Connection statistics and the admin endpoint.
+//
+// Every served connection is registered in stats for as long as it is
+// open, whatever the mode. With -admin the server also answers HTTP on a
+// separate address:
+//
+//	GET    /stats              global counters
+//	GET    /connections        live connections with their counters
+//	GET    /connections/{id}   one live connection
+//	DELETE /connections/{id}   force-close a connection
+//
+// For example:
+//
+//	curl localhost:8081/connections
+//	curl -X DELETE localhost:8081/connections/17
+//
+// The endpoint has no authentication; bind it to a loopback or lab
+// address only.
+
+// connStats tracks one connection. The counters are written by the
+// connection's handler and read by the admin endpoint, hence atomic.
+type connStats struct {
+	id       uint64
+	remote   string
+	start    time.Time
+	bytesIn  atomic.Int64
+	bytesOut atomic.Int64
+	messages atomic.Int64 // Reads served, each one echoed back
+	closed   atomic.Bool  // Set when closed through the admin endpoint
+	close    func()       // Forces the connection to close
+}
+
+// serverStats holds the global counters and the live connections.
+type serverStats struct {
+	start       time.Time
+	accepted    atomic.Int64 // Connections that reached a handler (in pool mode, a worker)
+	rejected    atomic.Int64 // Connections refused because the pool queue was full
+	errors      atomic.Int64 // Accept, read and write errors
+	forceClosed atomic.Int64 // Connections closed through the admin endpoint
+	nextID      atomic.Uint64
+
+	mu    sync.Mutex
+	conns map[uint64]*connStats
+}
+
+// stats is the server-wide statistics registry.
+var stats = &serverStats{start: time.Now(), conns: make(map[uint64]*connStats)}
+
+// track registers a new connection. close must make the connection's
+// handler notice and finish, for example by closing its socket.
+func (s *serverStats) track(remote string, close func()) *connStats {
+	c := &connStats{
+		id:     s.nextID.Add(1),
+		remote: remote,
+		start:  time.Now(),
+		close:  close,
+	}
+	s.accepted.Add(1)
+	s.mu.Lock()
+	s.conns[c.id] = c
+	s.mu.Unlock()
+	return c
+}
+
+// untrack removes a connection once its handler is done with it and
+// logs its totals.
+func (s *serverStats) untrack(c *connStats) {
+	s.mu.Lock()
+	delete(s.conns, c.id)
+	s.mu.Unlock()
+	logf("Client %s disconnected (%d messages, %d bytes in, %d bytes out, %s)",
+		c.remote, c.messages.Load(), c.bytesIn.Load(), c.bytesOut.Load(),
+		time.Since(c.start).Round(time.Millisecond))
+}
+
+// forceClose closes the connection with the given id. It reports false
+// if there is no such live connection.
+func (s *serverStats) forceClose(id uint64) bool {
+	s.mu.Lock()
+	c := s.conns[id]
+	s.mu.Unlock()
+	if c == nil || c.closed.Swap(true) {
+		return false
+	}
+	s.forceClosed.Add(1)
+	log.Printf("Admin: closing connection %d (%s)", c.id, c.remote)
+	c.close()
+	return true
+}
+
+// connInfo is the JSON form of a live connection.
+type connInfo struct {
+	ID          uint64  `json:"id"`
+	Remote      string  `json:"remote"`
+	Since       string  `json:"since"`
+	DurationSec float64 `json:"duration_sec"`
+	BytesIn     int64   `json:"bytes_in"`
+	BytesOut    int64   `json:"bytes_out"`
+	Messages    int64   `json:"messages"`
+}
+
+func (c *connStats) info() connInfo {
+	return connInfo{
+		ID:          c.id,
+		Remote:      c.remote,
+		Since:       c.start.Format(time.RFC3339),
+		DurationSec: time.Since(c.start).Seconds(),
+		BytesIn:     c.bytesIn.Load(),
+		BytesOut:    c.bytesOut.Load(),
+		Messages:    c.messages.Load(),
+	}
+}
+
+// connections returns the live connections ordered by id.
+func (s *serverStats) connections() []connInfo {
+	s.mu.Lock()
+	list := make([]connInfo, 0, len(s.conns))
+	for _, c := range s.conns {
+		list = append(list, c.info())
+	}
+	s.mu.Unlock()
+	slices.SortFunc(list, func(a, b connInfo) int { return cmp.Compare(a.ID, b.ID) })
+	return list
+}
+
+// serveAdmin runs the admin HTTP endpoint on addr.
+func serveAdmin(addr string, mode string) {
+	log.Printf("Admin endpoint listening on %s", addr)
+	checkError(http.ListenAndServe(addr, adminHandler(mode)))
+}
+
+// adminHandler returns the routes of the admin endpoint; mode is the
+// connection handling mode reported by /stats.
+func adminHandler(mode string) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /stats", func(w http.ResponseWriter, r *http.Request) {
+		stats.mu.Lock()
+		active := len(stats.conns)
+		stats.mu.Unlock()
+		writeJSON(w, http.StatusOK, map[string]any{
+			"mode":         mode,
+			"uptime_sec":   time.Since(stats.start).Seconds(),
+			"active":       active,
+			"accepted":     stats.accepted.Load(),
+			"rejected":     stats.rejected.Load(),
+			"errors":       stats.errors.Load(),
+			"force_closed": stats.forceClosed.Load(),
+		})
+	})
+
+	mux.HandleFunc("GET /connections", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, stats.connections())
+	})
+
+	mux.HandleFunc("GET /connections/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid connection id"})
+			return
+		}
+		stats.mu.Lock()
+		c := stats.conns[id]
+		stats.mu.Unlock()
+		if c == nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "no such connection"})
+			return
+		}
+		writeJSON(w, http.StatusOK, c.info())
+	})
+
+	mux.HandleFunc("DELETE /connections/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid connection id"})
+			return
+		}
+		if !stats.forceClose(id) {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "no such connection"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"closed": id})
+	})
+
+	return mux
+}
+
+// writeJSON writes v as the JSON response body. v is encoded before
+// anything is sent, so an encoding failure can still become a 500.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	body, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		log.Printf("Admin: cannot encode response: %v", err)
+		http.Error(w, "cannot encode response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(append(body, '\n')); err != nil {
+		log.Printf("Admin: cannot write response: %v", err)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// adminRequest sends a request to the admin endpoint and decodes the
+// JSON reply into v. It returns the status code.
+func adminRequest(t *testing.T, method, url string, v any) int {
+	t.Helper()
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("%s %s: Content-Type %q", method, url, ct)
+	}
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("%s %s: %v", method, url, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// findConn returns the entry of GET /connections for the client whose
+// local address is remote, waiting until its counters reach messages.
+func findConn(t *testing.T, admin, remote string, messages int64) connInfo {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var list []connInfo
+		adminRequest(t, "GET", admin+"/connections", &list)
+		for _, c := range list {
+			if c.Remote == remote && c.Messages == messages && c.BytesOut == c.BytesIn {
+				return c
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no connection %s with %d messages in %+v", remote, messages, list)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// adminStats is the part of GET /stats the test checks.
+type adminStats struct {
+	Mode        string `json:"mode"`
+	Active      int    `json:"active"`
+	ForceClosed int64  `json:"force_closed"`
+}
+
+// TestAdmin lists a client through the admin endpoint, closes it with
+// DELETE and checks that the client sees EOF and the counters follow.
+func TestAdmin(t *testing.T) {
+	for _, mode := range []benchMode{goroutineMode, poolMode, epollMode} {
+		t.Run(mode.name, func(t *testing.T) {
+			addr := startBenchServer(t, mode)
+			admin := httptest.NewServer(adminHandler(mode.name))
+			defer admin.Close()
+
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			for range 3 {
+				if err := roundTrip(conn); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			c := findConn(t, admin.URL, conn.LocalAddr().String(), 3)
+			if want := int64(3 * len(benchPayload)); c.BytesIn != want {
+				t.Errorf("bytes_in %d, want %d", c.BytesIn, want)
+			}
+			var one connInfo
+			if code := adminRequest(t, "GET", fmt.Sprintf("%s/connections/%d", admin.URL, c.ID), &one); code != http.StatusOK || one.ID != c.ID || one.Remote != c.Remote {
+				t.Errorf("GET /connections/%d: %d %+v", c.ID, code, one)
+			}
+
+			var before adminStats
+			adminRequest(t, "GET", admin.URL+"/stats", &before)
+			if before.Mode != mode.name || before.Active < 1 {
+				t.Errorf("stats %+v", before)
+			}
+
+			var closed map[string]uint64
+			if code := adminRequest(t, "DELETE", fmt.Sprintf("%s/connections/%d", admin.URL, c.ID), &closed); code != http.StatusOK || closed["closed"] != c.ID {
+				t.Fatalf("DELETE: %d %v", code, closed)
+			}
+
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+				t.Errorf("client read %v after DELETE, want EOF", err)
+			}
+
+			// The handler untracks the connection once it noticed the close
+			deadline := time.Now().Add(5 * time.Second)
+			for adminRequest(t, "GET", fmt.Sprintf("%s/connections/%d", admin.URL, c.ID), nil) != http.StatusNotFound {
+				if time.Now().After(deadline) {
+					t.Fatal("connection still listed after DELETE")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			var after adminStats
+			adminRequest(t, "GET", admin.URL+"/stats", &after)
+			if after.ForceClosed != before.ForceClosed+1 || after.Active > before.Active-1 {
+				t.Errorf("stats before %+v, after %+v", before, after)
+			}
+
+			if code := adminRequest(t, "DELETE", fmt.Sprintf("%s/connections/%d", admin.URL, c.ID), nil); code != http.StatusNotFound {
+				t.Errorf("second DELETE: %d", code)
+			}
+			for _, id := range []string{"x", "-1"} {
+				if code := adminRequest(t, "DELETE", admin.URL+"/connections/"+id, nil); code != http.StatusBadRequest {
+					t.Errorf("DELETE /connections/%s: %d", id, code)
+				}
+			}
+		})
+	}
+}
+
+// TestWriteJSONError checks that a value that cannot be encoded gives a
+// 500 instead of a 200 with a truncated body.
+func TestWriteJSONError(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writeJSON(rec, http.StatusOK, map[string]float64{"x": math.Inf(1)})
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status %d, want 500", rec.Code)
+	}
+}